@@ -9,8 +9,8 @@ import (
 	logger "github.com/sirupsen/logrus"
 )
 
-var validProcessors = []string{"docker", "kubernetes", "kubernetes-in-cluster"}
-var aliasProcessors = []string{"docker", "k8s", "k8s-ic"}
+var validProcessors = []string{"docker", "podman", "local", "kubernetes", "kubernetes-in-cluster"}
+var aliasProcessors = []string{"docker", "podman", "local", "k8s", "k8s-ic"}
 var configOptions *ConfigOptions
 var validLogLevel = []string{"debug", "info"}
 
@@ -19,9 +19,14 @@ type ConfigOptions struct {
 	ConfigFile string
 	LogLevel   string `validate:"logrus" name:"--loglevel" default:"info"`
 	Timeout    int    `validate:"number,min=30" default:"120" name:"--timeout"`
-	ProxyURL   string `validate:"omitempty,proxy" name:"--proxy"`
-	DryRun     bool
-	OnlineMode bool
+	// The timeouts of the other build steps, in seconds (0 disables them)
+	ResolveTimeout int    `validate:"number,min=0" default:"300" name:"--resolve-timeout"`
+	PullTimeout    int    `validate:"number,min=0" default:"600" name:"--pull-timeout"`
+	CopyTimeout    int    `validate:"number,min=0" default:"600" name:"--copy-timeout"`
+	HookTimeout    int    `validate:"number,min=0" default:"600" name:"--hook-timeout"`
+	ProxyURL       string `validate:"omitempty,proxy" name:"--proxy"`
+	DryRun         bool
+	OnlineMode     bool
 
 	configErrors bool
 }
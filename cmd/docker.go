@@ -13,15 +13,22 @@ func NewDockerCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Comman
 	dockerCmd := &cobra.Command{
 		Use:   "docker",
 		Short: "Build kernel modules and eBPF probes against a docker daemon.",
-		Run: func(c *cobra.Command, args []string) {
+		RunE: func(c *cobra.Command, args []string) error {
 			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
-			if !configOptions.DryRun {
-				if err := driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")).Start(rootOpts.toBuild()); err != nil {
-					logger.WithError(err).Fatal("exiting")
-				}
+			if configOptions.DryRun {
+				return nil
 			}
+			b, err := rootOpts.toBuild()
+			if err != nil {
+				return err
+			}
+			return driverbuilder.Run(c.Context(), driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), dockerOptions.PoolSize), b)
 		},
 	}
+	// Add docker options flags
+	flags := dockerCmd.Flags()
+	addDockerFlags(flags)
+	dockerCmd.PersistentFlags().AddFlagSet(flags)
 	// Add root flags
 	dockerCmd.PersistentFlags().AddFlagSet(rootFlags) 	//PersistentFlags: 持久flag, 也就是可以作用于它以及它之下的命令.
 
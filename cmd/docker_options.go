@@ -0,0 +1,13 @@
+package cmd
+
+import flag "github.com/spf13/pflag"
+
+var dockerOptions = &DockerOptions{}
+
+type DockerOptions struct {
+	PoolSize int `validate:"min=0" name:"pool-size" default:"0"`
+}
+
+func addDockerFlags(flags *flag.FlagSet) {
+	flags.IntVar(&dockerOptions.PoolSize, "pool-size", 0, "number of builder containers per image to keep alive and recycle across successive builds, they exit once idle for longer than --timeout (0 disables the pool)")
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// Exit codes of driverkit, telling apart why it failed.
+const (
+	ExitError                = 1
+	ExitInvalidOptions       = 2
+	ExitBuildFailed          = 3
+	ExitHeadersNotFound      = 4
+	ExitBuilderImageNotFound = 5
+	ExitInterrupted          = 130
+)
+
+// ValidationErr is returned when the options do not validate, details are logged.
+var ValidationErr = errors.New("exiting for validation errors")
+
+// exitCode maps the error a command returned to the exit code of driverkit.
+func exitCode(err error) int {
+	var parseErr *kernelrelease.ParseError
+	var scriptErr *driverbuilder.ScriptError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ValidationErr), errors.As(err, &parseErr):
+		return ExitInvalidOptions
+	case errors.Is(err, builder.HeadersNotFoundErr):
+		return ExitHeadersNotFound
+	case errors.Is(err, builder.BuilderImageNotFoundErr):
+		return ExitBuilderImageNotFound
+	case errors.As(err, &scriptErr):
+		return ExitBuildFailed
+	}
+	return ExitError
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/diagnostics"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"gotest.tools/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	_, parseErr := kernelrelease.Parse("not-a-kernel")
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{fmt.Errorf("boom"), ExitError},
+		{ValidationErr, ExitInvalidOptions},
+		{parseErr, ExitInvalidOptions},
+		{fmt.Errorf("%w: for ubuntu 5.15.0", builder.HeadersNotFoundErr), ExitHeadersNotFound},
+		{fmt.Errorf("%w: could not load any builder image", builder.BuilderImageNotFoundErr), ExitBuilderImageNotFound},
+		{&diagnostics.Error{Err: &driverbuilder.ScriptError{ExitCode: 2}, Report: &diagnostics.Report{}}, ExitBuildFailed},
+		{fmt.Errorf("docker build interrupted: %w", context.Canceled), ExitInterrupted},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, exitCode(tt.err), tt.code, "%v", tt.err)
+	}
+}
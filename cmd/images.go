@@ -13,10 +13,15 @@ func NewImagesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Comman
 	imagesCmd := &cobra.Command{
 		Use:   "images",
 		Short: "List builder images",
-		Run: func(c *cobra.Command, args []string) {
+		RunE: func(c *cobra.Command, args []string) error {
 			logger.WithField("processor", c.Name()).Info("listing images")
-			b := rootOpts.toBuild()
-			b.LoadImages()
+			b, err := rootOpts.toBuild()
+			if err != nil {
+				return err
+			}
+			if err := b.LoadImages(c.Context()); err != nil {
+				return err
+			}
 
 			table := tablewriter.NewWriter(os.Stdout)
 			table.SetHeader([]string{"Image", "Target", "Arch", "GCC"})
@@ -32,6 +37,7 @@ func NewImagesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Comman
 				table.Append(data)
 			}
 			table.Render() // Send output
+			return nil
 		},
 	}
 	// Add root flags
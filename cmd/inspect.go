@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/modinfo"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var validInspectOutputs = []string{"table", "json"}
+
+// inspectResult is what driverkit inspect reports about a driver file.
+type inspectResult struct {
+	File         string              `json:"file"`
+	Kind         string              `json:"kind"`
+	Machine      string              `json:"machine"`
+	Class        string              `json:"class"`
+	Architecture string              `json:"architecture,omitempty"`
+	Modinfo      map[string][]string `json:"modinfo,omitempty"`
+	Signature    *modinfo.Signature  `json:"signature,omitempty"`
+	License      string              `json:"license,omitempty"`
+	BTF          bool                `json:"btf"`
+	Sections     []modinfo.Section   `json:"sections,omitempty"`
+}
+
+func inspectFile(path string) (*inspectResult, error) {
+	info, err := modinfo.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &inspectResult{
+		File:         path,
+		Kind:         "elf",
+		Machine:      info.Machine.String(),
+		Class:        info.Class.String(),
+		Architecture: info.Architecture().String(),
+		Signature:    info.Signature,
+		BTF:          info.HasBTF(),
+	}
+	switch {
+	case len(info.Vermagic()) > 0:
+		res.Kind = "kernel module"
+		res.Modinfo = info.Fields
+		res.License = info.Get("license")
+	case info.IsBPF():
+		res.Kind = "eBPF probe"
+		res.License = info.License
+		res.Sections = info.Sections
+	}
+	return res, nil
+}
+
+func (r *inspectResult) rows() [][]string {
+	rows := [][]string{
+		{"file", r.File},
+		{"kind", r.Kind},
+		{"machine", fmt.Sprintf("%s (%s)", r.Machine, r.Class)},
+	}
+	if len(r.Architecture) > 0 {
+		rows = append(rows, []string{"architecture", r.Architecture})
+	}
+	if r.Modinfo != nil {
+		for _, key := range []string{"name", "version", "vermagic", "srcversion", "depends", "license", "retpoline", "intree"} {
+			if values, ok := r.Modinfo[key]; ok {
+				rows = append(rows, []string{key, strings.Join(values, ", ")})
+			}
+		}
+		signed := "no"
+		if r.Signature != nil {
+			signed = fmt.Sprintf("yes (%s, %d bytes)", r.Signature.Type, r.Signature.Length)
+		}
+		rows = append(rows, []string{"signed", signed})
+	}
+	if r.Kind == "eBPF probe" {
+		rows = append(rows, []string{"license", r.License})
+		rows = append(rows, []string{"btf", fmt.Sprintf("%t", r.BTF)})
+		for _, s := range r.Sections {
+			rows = append(rows, []string{"section", fmt.Sprintf("%s (%s, %d bytes)", s.Name, s.Type, s.Size)})
+		}
+	}
+	return rows
+}
+
+func printInspectResults(w io.Writer, results []*inspectResult, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	for _, r := range results {
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Field", "Value"})
+		table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+		table.SetCenterSeparator("|")
+		table.SetAutoWrapText(false)
+		table.AppendBulk(r.rows())
+		table.Render()
+	}
+	return nil
+}
+
+// NewInspectCmd creates the `driverkit inspect` command.
+func NewInspectCmd() *cobra.Command {
+	var output string
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <file>...",
+		Short: "Show which kernel and architecture built kernel modules and eBPF probes are for.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("output must be one of %v", validInspectOutputs)
+			}
+			results := make([]*inspectResult, 0, len(args))
+			for _, path := range args {
+				res, err := inspectFile(path)
+				if err != nil {
+					return err
+				}
+				results = append(results, res)
+			}
+			return printInspectResults(os.Stdout, results, output)
+		},
+	}
+	inspectCmd.Flags().StringVarP(&output, "output", "o", "table", fmt.Sprintf("output format, one of %v", validInspectOutputs))
+	inspectCmd.RegisterFlagCompletionFunc("output", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return validInspectOutputs, cobra.ShellCompDirectiveDefault
+	})
+
+	return inspectCmd
+}
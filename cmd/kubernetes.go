@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -11,6 +13,8 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // NewKubernetesCmd creates the `driverkit kubernetes` command.
@@ -43,13 +47,12 @@ func NewKubernetesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Co
 
 	kubefactory := factory.NewFactory(configFlags)
 
-	kubernetesCmd.Run = func(cmd *cobra.Command, args []string) {
+	kubernetesCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		logger.WithField("processor", cmd.Name()).Info("driver building, it will take a few seconds")
-		if !configOptions.DryRun {
-			if err := kubernetesRun(cmd, args, kubefactory, rootOpts); err != nil {
-				logger.WithError(err).Fatal("exiting")
-			}
+		if configOptions.DryRun {
+			return nil
 		}
+		return kubernetesRun(cmd, args, kubefactory, rootOpts)
 	}
 
 	return kubernetesCmd
@@ -57,7 +60,6 @@ func NewKubernetesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Co
 
 func kubernetesRun(cmd *cobra.Command, args []string, kubefactory factory.Factory, rootOpts *RootOptions) error {
 	f := cmd.Flags()
-	b := rootOpts.toBuild()
 
 	namespaceStr, err := f.GetString("namespace")
 	if err != nil {
@@ -79,6 +81,78 @@ func kubernetesRun(cmd *cobra.Command, args []string, kubefactory factory.Factor
 		return err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), clientConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, viper.GetInt("timeout"), viper.GetString("proxy"))
-	return buildProcessor.Start(b)
+	return kubernetesStart(cmd.Context(), f, kc, clientConfig, rootOpts)
+}
+
+// kubernetesStart runs the build, or one build per distinct node kernel when --all-nodes is set.
+func kubernetesStart(ctx context.Context, f *pflag.FlagSet, kc kubernetes.Interface, clientConfig *rest.Config, rootOpts *RootOptions) error {
+	if err := mergeKubernetesConfig(f); err != nil {
+		return err
+	}
+	jobOptions, err := kubernetesOptions.toJobOptions(kc)
+	if err != nil {
+		return err
+	}
+
+	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc, clientConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, viper.GetInt("timeout"), viper.GetString("proxy"), jobOptions)
+	if !kubernetesOptions.AllNodes {
+		b, err := rootOpts.toBuild()
+		if err != nil {
+			return err
+		}
+		return driverbuilder.Run(ctx, buildProcessor, b)
+	}
+
+	kernels, skipped, err := driverbuilder.ListNodeKernels(ctx, kc.CoreV1())
+	if err != nil {
+		return err
+	}
+	for node, reason := range skipped {
+		logger.WithError(reason).WithField("node", node).Warn("skipping node")
+	}
+	logger.WithField("kernels", len(kernels)).Info("distinct node kernels found")
+
+	failed := 0
+	for _, nk := range kernels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opts := rootOpts.forNodeKernel(nk)
+		fields := logger.Fields{
+			"target":        nk.Target,
+			"kernelrelease": nk.KernelRelease,
+			"kernelversion": nk.KernelVersion,
+			"arch":          nk.Architecture,
+			"nodes":         nk.Nodes,
+		}
+		if errs := opts.Validate(); errs != nil {
+			for _, err := range errs {
+				logger.WithError(err).WithFields(fields).Error("invalid options for node kernel")
+			}
+			failed++
+			continue
+		}
+		b, err := opts.toBuild()
+		if err != nil {
+			logger.WithError(err).WithFields(fields).Error("invalid options for node kernel")
+			failed++
+			continue
+		}
+		if err := driverbuilder.Run(ctx, buildProcessor, b); err != nil {
+			logger.WithError(err).WithFields(fields).Error("build failed")
+			failed++
+			continue
+		}
+		if len(b.ModuleOutPutFilePath) > 0 {
+			fields["output-module"] = b.ModuleOutPutFilePath
+		}
+		if len(b.ProbeFilePath) > 0 {
+			fields["output-probe"] = b.ProbeFilePath
+		}
+		logger.WithFields(fields).Info("artifacts built for nodes")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d out of %d node kernels failed to build", failed, len(kernels))
+	}
+	return nil
 }
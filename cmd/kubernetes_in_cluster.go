@@ -1,12 +1,10 @@
 package cmd
 
 import (
-	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/kubernetes/factory"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"github.com/spf13/viper"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -26,34 +24,29 @@ func NewKubernetesInClusterCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet)
 	// Add root flags
 	kubernetesInClusterCmd.PersistentFlags().AddFlagSet(rootFlags)
 
-	kubernetesInClusterCmd.Run = func(cmd *cobra.Command, args []string) {
+	kubernetesInClusterCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		logger.WithField("processor", cmd.Name()).Info("driver building, it will take a few seconds")
-		if !configOptions.DryRun {
-			config, err := rest.InClusterConfig()
-			if err != nil {
-				logger.WithError(err).Fatal("exiting")
-			}
-			if err = factory.SetKubernetesDefaults(config); err != nil {
-				logger.WithError(err).Fatal("exiting")
-			}
-			if err = kubernetesInClusterRun(cmd, args, config, rootOpts); err != nil {
-				logger.WithError(err).Fatal("exiting")
-			}
+		if configOptions.DryRun {
+			return nil
 		}
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		if err = factory.SetKubernetesDefaults(config); err != nil {
+			return err
+		}
+		return kubernetesInClusterRun(cmd, args, config, rootOpts)
 	}
 
 	return kubernetesInClusterCmd
 }
 
-func kubernetesInClusterRun(_ *cobra.Command, _ []string, kubeConfig *rest.Config, rootOpts *RootOptions) error {
-	b := rootOpts.toBuild()
-
+func kubernetesInClusterRun(cmd *cobra.Command, _ []string, kubeConfig *rest.Config, rootOpts *RootOptions) error {
 	kc, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
 		return err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), kubeConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, viper.GetInt("timeout"), viper.GetString("proxy"))
-
-	return buildProcessor.Start(b)
+	return kubernetesStart(cmd.Context(), cmd.Flags(), kc, kubeConfig, rootOpts)
 }
@@ -1,6 +1,17 @@
 package cmd
 
-import flag "github.com/spf13/pflag"
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
 
 var kubernetesOptions = &KubeOptions{}
 
@@ -8,10 +19,122 @@ type KubeOptions struct {
 	RunAsUser       int64  `json:"runAsUser,omitempty" protobuf:"varint,2,opt,name=runAsUser" default:"0"`
 	Namespace       string `validate:"required" name:"namespace" default:"default"`
 	ImagePullSecret string `validate:"omitempty" name:"image-pull-secret" default:""`
+
+	RequestsCPU    string            `validate:"omitempty" name:"requests-cpu" default:"1000m"`
+	RequestsMemory string            `validate:"omitempty" name:"requests-memory" default:"2000Mi"`
+	LimitsCPU      string            `validate:"omitempty" name:"limits-cpu" default:"4"`
+	LimitsMemory   string            `validate:"omitempty" name:"limits-memory" default:"4G"`
+	NodeSelector   map[string]string `validate:"omitempty" name:"node-selector"`
+	Tolerations    []string          `validate:"omitempty" name:"toleration"`
+	ServiceAccount string            `validate:"omitempty" name:"service-account" default:""`
+	Labels         map[string]string `validate:"omitempty" name:"labels"`
+
+	AllNodes    bool   `name:"all-nodes"`
+	ResultStore string `validate:"omitempty" name:"result-store" default:""`
 }
 
 func addKubernetesFlags(flags *flag.FlagSet) {
 	flags.StringVarP(&kubernetesOptions.Namespace, "namespace", "n", "default", "If present, the namespace scope for the pods and its config ")
 	flags.Int64Var(&kubernetesOptions.RunAsUser, "run-as-user", 0, "Pods runner user")
 	flags.StringVar(&kubernetesOptions.ImagePullSecret, "image-pull-secret", "", "ImagePullSecret")
+
+	flags.StringVar(&kubernetesOptions.RequestsCPU, "requests-cpu", "1000m", "CPU requested by the build pods")
+	flags.StringVar(&kubernetesOptions.RequestsMemory, "requests-memory", "2000Mi", "memory requested by the build pods")
+	flags.StringVar(&kubernetesOptions.LimitsCPU, "limits-cpu", "4", "CPU limit of the build pods")
+	flags.StringVar(&kubernetesOptions.LimitsMemory, "limits-memory", "4G", "memory limit of the build pods")
+	flags.StringToStringVar(&kubernetesOptions.NodeSelector, "node-selector", nil, "node labels the build pods must be scheduled on (e.g. --node-selector pool=builders), kubernetes.io/arch defaults to --architecture")
+	flags.StringArrayVar(&kubernetesOptions.Tolerations, "toleration", nil, "taint tolerated by the build pods, as key[=value][:effect] (e.g. --toleration dedicated=builders:NoSchedule)")
+	flags.StringVar(&kubernetesOptions.ServiceAccount, "service-account", "", "service account running the build pods")
+	flags.BoolVar(&kubernetesOptions.AllNodes, "all-nodes", false, "build for every distinct kernel running on the cluster nodes, with target, kernel release, kernel version and architecture taken from the nodes; nodes whose kernel version cannot be derived are skipped")
+	flags.StringToStringVar(&kubernetesOptions.Labels, "labels", nil, "labels added to the build jobs, pods and config maps (e.g. --labels team=security)")
+	flags.StringVar(&kubernetesOptions.ResultStore, "result-store", "", "also persist the artifacts into the cluster, labeled by target, kernel release and architecture: one of pvc://<claim>, configmap, secret, http(s)://<url>; configmap and secret hold at most 1MiB of artifacts")
+}
+
+// mergeKubernetesConfig fills the kubernetes flags not given on the command line
+// from the kubernetes section of the config file, if any.
+func mergeKubernetesConfig(flags *flag.FlagSet) error {
+	var errs []string
+	flags.VisitAll(func(f *flag.Flag) {
+		key := "kubernetes." + f.Name
+		if f.Changed || !viper.IsSet(key) {
+			return
+		}
+		var values []string
+		switch f.Value.Type() {
+		case "stringToString":
+			m := viper.GetStringMapString(key)
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]string, 0, len(m))
+			for _, k := range keys {
+				pairs = append(pairs, k+"="+m[k])
+			}
+			values = []string{strings.Join(pairs, ",")}
+		case "stringArray":
+			values = viper.GetStringSlice(key)
+		default:
+			values = []string{viper.GetString(key)}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid kubernetes config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// toJobOptions converts the kubernetes options into the scheduling options of the build jobs.
+func (ko *KubeOptions) toJobOptions(kc kubernetes.Interface) (driverbuilder.KubernetesJobOptions, error) {
+	opts := driverbuilder.KubernetesJobOptions{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{},
+			Limits:   corev1.ResourceList{},
+		},
+		NodeSelector:   ko.NodeSelector,
+		ServiceAccount: ko.ServiceAccount,
+		Labels:         ko.Labels,
+	}
+	quantities := []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+		flag  string
+	}{
+		{opts.Resources.Requests, corev1.ResourceCPU, ko.RequestsCPU, "requests-cpu"},
+		{opts.Resources.Requests, corev1.ResourceMemory, ko.RequestsMemory, "requests-memory"},
+		{opts.Resources.Limits, corev1.ResourceCPU, ko.LimitsCPU, "limits-cpu"},
+		{opts.Resources.Limits, corev1.ResourceMemory, ko.LimitsMemory, "limits-memory"},
+	}
+	for _, q := range quantities {
+		if len(q.value) == 0 {
+			continue
+		}
+		parsed, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --%s %q: %v", q.flag, q.value, err)
+		}
+		q.list[q.name] = parsed
+	}
+	for _, t := range ko.Tolerations {
+		toleration, err := driverbuilder.ParseToleration(t)
+		if err != nil {
+			return opts, err
+		}
+		opts.Tolerations = append(opts.Tolerations, toleration)
+	}
+	if len(ko.ResultStore) > 0 {
+		store, err := driverbuilder.NewResultStore(ko.ResultStore, kc, ko.Namespace)
+		if err != nil {
+			return opts, err
+		}
+		opts.ResultStore = store
+	}
+	return opts, nil
 }
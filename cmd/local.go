@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewLocalCmd creates the `driverkit local` command.
+func NewLocalCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	localCmd := &cobra.Command{
+		Use:   "local",
+		Short: "Build kernel modules and eBPF probes on the host, without any container.",
+		RunE: func(c *cobra.Command, args []string) error {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
+			if configOptions.DryRun {
+				return nil
+			}
+			b, err := rootOpts.toBuild()
+			if err != nil {
+				return err
+			}
+			return driverbuilder.Run(c.Context(), driverbuilder.NewLocalBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")), b)
+		},
+	}
+	// Add root flags
+	localCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return localCmd
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewPodmanCmd creates the `driverkit podman` command.
+func NewPodmanCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	podmanCmd := &cobra.Command{
+		Use:   "podman",
+		Short: "Build kernel modules and eBPF probes against a (rootless) podman API socket.",
+		RunE: func(c *cobra.Command, args []string) error {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
+			if configOptions.DryRun {
+				return nil
+			}
+			bp, err := driverbuilder.NewPodmanBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), dockerOptions.PoolSize, podmanOptions.Socket)
+			if err != nil {
+				return err
+			}
+			b, err := rootOpts.toBuild()
+			if err != nil {
+				return err
+			}
+			return driverbuilder.Run(c.Context(), bp, b)
+		},
+	}
+	// Add podman options flags
+	flags := podmanCmd.Flags()
+	addDockerFlags(flags)
+	addPodmanFlags(flags)
+	podmanCmd.PersistentFlags().AddFlagSet(flags)
+	// Add root flags
+	podmanCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return podmanCmd
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import flag "github.com/spf13/pflag"
+
+var podmanOptions = &PodmanOptions{}
+
+type PodmanOptions struct {
+	Socket string `validate:"omitempty" name:"podman-socket" default:""`
+}
+
+func addPodmanFlags(flags *flag.FlagSet) {
+	flags.StringVar(&podmanOptions.Socket, "podman-socket", "", "podman API socket (e.g. unix:///run/user/1000/podman/podman.sock), discovered from CONTAINER_HOST or the default rootless/rootful locations when empty")
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/repository"
+	"github.com/olekukonko/tablewriter"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// repositoryOptions are the flags shared by the repository commands.
+type repositoryOptions struct {
+	dir    string
+	filter repository.Filter
+}
+
+func (o *repositoryOptions) addFilterFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&o.filter.Target, "target", "t", "", "only the artifacts of the given target")
+	flags.StringVar(&o.filter.KernelRelease, "kernelrelease", "", "only the artifacts of the given kernel release")
+	flags.StringVar(&o.filter.KernelVersion, "kernelversion", "", "only the artifacts of the given kernel version")
+	flags.StringVar(&o.filter.Arch, "architecture", "", "only the artifacts of the given architecture")
+	flags.StringVar(&o.filter.ModuleVersion, "module-version", "", "only the artifacts of the given module version, ie. the short hash of the module source")
+	flags.StringVar(&o.filter.Kind, "kind", "", "only the artifacts of the given kind, one of [module,probe]")
+}
+
+// open opens the repository given by --repository, or by the repository key of the config file.
+func (o *repositoryOptions) open() (*repository.Repository, error) {
+	dir := o.dir
+	if len(dir) == 0 {
+		dir = viper.GetString("repository")
+	}
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("--repository is required")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("no repository at %s: %v", dir, err)
+	}
+	return repository.Open(dir)
+}
+
+// NewRepositoryCmd creates the `driverkit repository` command.
+func NewRepositoryCmd() *cobra.Command {
+	opts := &repositoryOptions{}
+	repositoryCmd := &cobra.Command{
+		Use:   "repository",
+		Short: "Query and maintain the artifact repository the builds add their artifacts to with --repository.",
+	}
+	repositoryCmd.PersistentFlags().StringVar(&opts.dir, "repository", "", "directory of the artifact repository")
+
+	repositoryCmd.AddCommand(newRepositoryListCmd(opts))
+	repositoryCmd.AddCommand(newRepositoryGetCmd(opts))
+	repositoryCmd.AddCommand(newRepositoryPruneCmd(opts))
+	repositoryCmd.AddCommand(newRepositoryExportCmd(opts))
+	return repositoryCmd
+}
+
+func newRepositoryListCmd(opts *repositoryOptions) *cobra.Command {
+	var output string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the artifacts of the repository.",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("output must be one of %v", validInspectOutputs)
+			}
+			repo, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			entries, err := repo.List(opts.filter)
+			if err != nil {
+				return err
+			}
+			return printRepositoryEntries(c.OutOrStdout(), entries, output)
+		},
+	}
+	opts.addFilterFlags(listCmd.Flags())
+	listCmd.Flags().StringVarP(&output, "output", "o", "table", fmt.Sprintf("output format, one of %v", validInspectOutputs))
+	return listCmd
+}
+
+func printRepositoryEntries(w io.Writer, entries []repository.Entry, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Target", "Kernel release", "Kernel version", "Arch", "Module version", "Kind", "Size", "Created"})
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.SetAutoWrapText(false)
+	for _, e := range entries {
+		table.Append([]string{e.Target, e.KernelRelease, e.KernelVersion, e.Arch, e.ModuleVersion, e.Kind, fmt.Sprintf("%d", e.Size), e.CreatedAt.Format(time.RFC3339)})
+	}
+	table.Render()
+	return nil
+}
+
+func newRepositoryGetCmd(opts *repositoryOptions) *cobra.Command {
+	var outputDir, outputName string
+	getCmd := &cobra.Command{
+		Use:   "get",
+		Short: "Copy the most recent artifacts matching the given kernel out of the repository; fails when there is none.",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			f := opts.filter
+			if len(f.Target) == 0 || len(f.KernelRelease) == 0 || len(f.Arch) == 0 {
+				return fmt.Errorf("--target, --kernelrelease and --architecture are required")
+			}
+			repo, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			entries, err := repo.List(f)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return fmt.Errorf("no artifact for %s %s %s into the repository", f.Target, f.KernelRelease, f.Arch)
+			}
+			nameTemplate, err := builder.OutputNameTemplate(outputName, viper.GetStringMapString("output.presets"))
+			if err != nil {
+				return err
+			}
+			// Entries come the most recent first
+			got := map[string]bool{}
+			for _, e := range entries {
+				if got[e.Kind] {
+					continue
+				}
+				got[e.Kind] = true
+				if err := getRepositoryEntry(repo, e, outputDir, nameTemplate); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	opts.addFilterFlags(getCmd.Flags())
+	getCmd.Flags().StringVar(&outputDir, "output-dir", ".", "directory where to copy the artifacts")
+	getCmd.Flags().StringVar(&outputName, "output-name", builder.DefaultOutputNamePreset, "name of the artifacts into --output-dir, as for the build --output-name")
+	return getCmd
+}
+
+func getRepositoryEntry(repo *repository.Repository, e repository.Entry, dir string, nameTemplate string) error {
+	b := &builder.Build{
+		TargetType:    builder.Type(e.Target),
+		KernelRelease: e.KernelRelease,
+		KernelVersion: e.KernelVersion,
+		Architecture:  e.Arch,
+	}
+	if err := b.SetOutputDir(dir, nameTemplate); err != nil {
+		return err
+	}
+	dst := b.ModuleOutPutFilePath
+	if e.Kind == repository.KindProbe {
+		dst = b.ProbeFilePath
+	}
+	files := map[string]string{repo.Path(e): dst}
+	if manifest := repo.ManifestPath(e); len(manifest) > 0 {
+		files[manifest] = dst + driverbuilder.ManifestSuffix
+	}
+	for src, dst := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		// Output names may lay the artifacts out into subdirectories
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+	}
+	logger.WithField("path", dst).Infof("%s available", e.Kind)
+	return nil
+}
+
+func newRepositoryPruneCmd(opts *repositoryOptions) *cobra.Command {
+	var olderThan time.Duration
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the matching artifacts from the repository; without any filter, only the entries whose artifact went missing are removed.",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			repo, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			f := opts.filter
+			if olderThan > 0 {
+				f.OlderThan = time.Now().Add(-olderThan)
+			}
+			removed, err := repo.Prune(f)
+			for _, e := range removed {
+				logger.WithField("path", repo.Path(e)).Debug("artifact removed")
+			}
+			logger.WithField("removed", len(removed)).Info("repository pruned")
+			return err
+		},
+	}
+	opts.addFilterFlags(pruneCmd.Flags())
+	pruneCmd.Flags().DurationVar(&olderThan, "older-than", 0, "only the artifacts added before the given duration, eg. 720h")
+	return pruneCmd
+}
+
+func newRepositoryExportCmd(opts *repositoryOptions) *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export <file.tar.gz>",
+		Short: "Export the matching artifacts, with their manifests and an index.json, as a tar.gz archive; - writes to stdout.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			repo, err := opts.open()
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			entries, err := repo.List(opts.filter)
+			if err != nil {
+				return err
+			}
+			w := c.OutOrStdout()
+			if args[0] != "-" {
+				f, err := os.Create(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			if err := repo.Export(w, entries); err != nil {
+				return err
+			}
+			logger.WithField("artifacts", len(entries)).Info("repository exported")
+			return nil
+		},
+	}
+	opts.addFilterFlags(exportCmd.Flags())
+	return exportCmd
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/repository"
+	"gotest.tools/assert"
+)
+
+func TestGetRepositoryEntry(t *testing.T) {
+	repo, err := repository.Open(t.TempDir())
+	assert.NilError(t, err)
+	defer repo.Close()
+	module := filepath.Join(t.TempDir(), "falco.ko")
+	assert.NilError(t, os.WriteFile(module, []byte("module"), 0644))
+	key := repository.Key{Target: "ubuntu", KernelRelease: "5.15.0-1019-aws", KernelVersion: "20", Arch: "amd64", ModuleVersion: "abc"}
+	e, err := repo.Add(key, repository.KindModule, module, "sum-module")
+	assert.NilError(t, err)
+
+	// The falco-repo preset lays the artifacts out into a directory per architecture
+	nameTemplate, err := builder.OutputNameTemplate("falco-repo", nil)
+	assert.NilError(t, err)
+	dir := t.TempDir()
+	assert.NilError(t, getRepositoryEntry(repo, *e, dir, nameTemplate))
+	data, err := os.ReadFile(filepath.Join(dir, "x86_64", "falco_ubuntu_5.15.0-1019-aws_20.ko"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "module")
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io"
@@ -9,7 +10,9 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
 	"github.com/falcosecurity/driverkit/pkg/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -23,19 +26,31 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 	return func(c *cobra.Command, args []string) error {
 		// Early exit if detect some error into config flags
 		if configOptions.configErrors {
-			return fmt.Errorf("exiting for validation errors")
+			return ValidationErr
 		}
 		// Merge environment variables or config file values into the RootOptions instance
 		skip := map[string]bool{ // do not merge these
-			"config":   true,
-			"timeout":  true,
+			"config":          true,
+			"timeout":         true,
+			"resolve-timeout": true,
+			"pull-timeout":    true,
+			"copy-timeout":    true,
+			"hook-timeout":    true,
 			//"loglevel": true,
-			"dryrun":   true,
-			"proxy":    true,
+			"dryrun": true,
+			"proxy":  true,
 		}
 		nested := map[string]string{ // handle nested options in config file
-			"output-module": "output.module",
-			"output-probe":  "output.probe",
+			"output-module":    "output.module",
+			"output-probe":     "output.probe",
+			"output-dir":       "output.dir",
+			"output-name":      "output.name",
+			"publish":          "publish.url",
+			"publish-endpoint": "publish.endpoint",
+			"publish-region":   "publish.region",
+			"push-oci":         "publish.oci",
+			"pre-build-hook":   "hooks.pre",
+			"post-build-hook":  "hooks.post",
 		}
 		rootCommand.c.Flags().VisitAll(func(f *pflag.Flag) {
 			if name := f.Name; !skip[name] {
@@ -73,13 +88,11 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 			lvl, err := logger.ParseLevel(configOptions.LogLevel)
 			if err != nil {
 				logger.WithField("--loglevel", configOptions.LogLevel).Errorln("not a valid log level")
-				return fmt.Errorf("exiting for validation errors")
+				return ValidationErr
 			}
 			logger.SetLevel(lvl)
 		}
 
-		builder.SetOnlineMode(configOptions.OnlineMode)
-
 		// Avoid sensitive info into default values help line
 		rootCommand.StripSensitive()
 
@@ -88,21 +101,42 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 			rootOpts.Target = "ubuntu"
 		}
 
+		// Building for every node, target and kernel release are not known yet
+		if f := c.Flags().Lookup("all-nodes"); f != nil && f.Value.String() == "true" {
+			rootOpts.perNodeKernel = true
+		}
+
 		// Do not block root or help command to exec disregarding the root flags validity
-		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" {
+		builds := c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" && c.Name() != "inspect" && !isRepositoryCmd(c)
+		if builds && c.Name() != "serve" {
 			if errs := rootOpts.Validate(); errs != nil {
 				for _, err := range errs {
 					logger.WithError(err).Error("error validating build options")
 				}
-				return fmt.Errorf("exiting for validation errors")
+				return ValidationErr
 			}
 			rootOpts.Log()
 		}
+		if builds && !configOptions.DryRun {
+			if err := rootOpts.openEvents(c.OutOrStdout()); err != nil {
+				return err
+			}
+		}
 
 		return nil
 	}
 }
 
+// isRepositoryCmd tells whether c is the repository command or one of its subcommands, which do not build anything.
+func isRepositoryCmd(c *cobra.Command) bool {
+	for ; c != nil; c = c.Parent() {
+		if c.Name() == "repository" {
+			return true
+		}
+	}
+	return false
+}
+
 // RootCmd wraps the main cobra.Command.
 type RootCmd struct {
 	c *cobra.Command
@@ -145,12 +179,18 @@ func NewRootCmd() *RootCmd {
 	flags.StringVarP(&configOptions.ConfigFile, "config", "c", configOptions.ConfigFile, "config file path (default $HOME/.driverkit.yaml if exists)")
 	flags.StringVarP(&configOptions.LogLevel, "loglevel", "l", configOptions.LogLevel, "log level: [info, debug]")
 	flags.IntVar(&configOptions.Timeout, "timeout", configOptions.Timeout, "timeout in seconds")
+	flags.IntVar(&configOptions.ResolveTimeout, "resolve-timeout", configOptions.ResolveTimeout, "timeout in seconds of the lookup of the kernel headers and of the builder images (0 to disable)")
+	flags.IntVar(&configOptions.PullTimeout, "pull-timeout", configOptions.PullTimeout, "timeout in seconds of the pull of the builder image (0 to disable)")
+	flags.IntVar(&configOptions.CopyTimeout, "copy-timeout", configOptions.CopyTimeout, "timeout in seconds of the copy of each artifact out of the builder (0 to disable)")
+	flags.IntVar(&configOptions.HookTimeout, "hook-timeout", configOptions.HookTimeout, "timeout in seconds of each of the pre and post build hooks (0 to disable)")
 	flags.BoolVar(&configOptions.DryRun, "dryrun", configOptions.DryRun, "do not actually perform the action")
 	flags.StringVar(&configOptions.ProxyURL, "proxy", configOptions.ProxyURL, "the proxy to use to download data")
 	flags.BoolVar(&configOptions.OnlineMode, "onlinemode", configOptions.OnlineMode, "get image and kernel header from remote with internet access")
 
 	flags.StringVar(&rootOpts.Output.Module, "output-module", rootOpts.Output.Module, "filepath where to save the resulting kernel module")
 	flags.StringVar(&rootOpts.Output.Probe, "output-probe", rootOpts.Output.Probe, "filepath where to save the resulting eBPF probe")
+	flags.StringVar(&rootOpts.Output.Dir, "output-dir", rootOpts.Output.Dir, "directory where to save both the kernel module and the eBPF probe, as supported by the kernel, named after --output-name")
+	flags.StringVar(&rootOpts.Output.Name, "output-name", builder.DefaultOutputNamePreset, "name of the outputs into --output-dir: a preset among [falco,falco-repo,full] and the output.presets of the config file, or a template using {{ .Target }}, {{ .KernelRelease }}, {{ .KernelVersion }}, {{ .Arch }}, {{ .Architecture }} and {{ .DriverName }}")
 	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver, one of "+kernelrelease.SupportedArchs.String())
 	flags.StringVar(&rootOpts.ModuleFilePath, "modulefilepath", rootOpts.ModuleFilePath, "the tar.gz filepath of kernel module source code")
 	flags.StringVar(&rootOpts.KernelVersion, "kernelversion", rootOpts.KernelVersion, "kernel version to build the module for, it's the numeric value after the hash when you execute 'uname -v'")
@@ -169,6 +209,20 @@ func NewRootCmd() *RootCmd {
 	flags.StringVar(&rootOpts.Repo.Name, "repo-name", rootOpts.Repo.Name, "repository github name")
 
 	flags.StringVar(&rootOpts.LocalKernelDir, "localkerneldir", rootOpts.LocalKernelDir, "get kernel file from local directory")
+	flags.StringVar(&rootOpts.CacheDir, "cachedir", rootOpts.CacheDir, "directory where to cache the built artifacts, keyed by a hash of all the build inputs; identical rebuilds are skipped (disabled if empty)")
+	flags.StringVar(&rootOpts.BuildLog, "build-log", rootOpts.BuildLog, "file where to write the complete, timestamped, output of the build script; with --all-nodes, one file per node kernel")
+	flags.StringVar(&rootOpts.Diagnostics, "diagnostics", rootOpts.Diagnostics, "file where to write the diagnosis of a failed build: compiler errors, missing headers, undefined symbols and vermagic issues")
+	flags.StringVar(&rootOpts.DiagnosticsFormat, "diagnostics-format", "json", "format of the --diagnostics file, one of [json,sarif]")
+	flags.StringVar(&rootOpts.Repository, "repository", rootOpts.Repository, "directory of the artifact repository: builds add their artifacts to it, and are skipped when it already holds them for the same target, kernel, architecture and module source. See driverkit repository")
+	flags.StringVar(&rootOpts.Hooks.Pre, "pre-build-hook", rootOpts.Hooks.Pre, "shell command run before each build, failing the build if it fails. The build is described by the DRIVERKIT_TARGET, DRIVERKIT_KERNELRELEASE, DRIVERKIT_KERNELVERSION, DRIVERKIT_ARCH, DRIVERKIT_MODULE and DRIVERKIT_PROBE environment variables")
+	flags.StringVar(&rootOpts.Hooks.Post, "post-build-hook", rootOpts.Hooks.Post, "shell command run after each build, along with the environment variables of --pre-build-hook, DRIVERKIT_RESULT (done or failed) and DRIVERKIT_ERROR")
+	flags.StringVar(&rootOpts.EventsJSON, "events-json", rootOpts.EventsJSON, "file where to write the progress events of the builds, as line-delimited JSON, or - for the standard output")
+	flags.StringVar(&rootOpts.KernelHeadersCache, "headerscache", rootOpts.KernelHeadersCache, "host directory or docker volume name where to keep the prepared kernel headers across builds, keyed by target, kernel release and architecture (disabled if empty)")
+
+	flags.StringVar(&rootOpts.Publish.URL, "publish", rootOpts.Publish.URL, "publish the artifacts and their manifests to an S3-compatible object store, as s3://<bucket>[/<prefix>]; the prefix may use the fields of --output-name, eg. s3://drivers/{{ .Arch }}. Artifacts already published with the same hash are skipped. Credentials come from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	flags.StringVar(&rootOpts.Publish.Endpoint, "publish-endpoint", rootOpts.Publish.Endpoint, "S3-compatible endpoint to --publish to, eg. http://localhost:9000 for MinIO (AWS S3 if empty)")
+	flags.StringVar(&rootOpts.Publish.Region, "publish-region", driverbuilder.DefaultPublishRegion, "region of the --publish bucket")
+	flags.StringVar(&rootOpts.Publish.OCIRef, "push-oci", rootOpts.Publish.OCIRef, "push the artifacts and their manifest as an OCI artifact to the given repository, eg. ghcr.io/org/drivers, annotated with target, kernel release and architecture. The tag is derived from the build, eg. ubuntu_5.15.0-1019-aws_20_x86_64, unless given; it may use the fields of --output-name. Credentials come from docker login")
 
 	viper.BindPFlags(flags)
 
@@ -188,7 +242,12 @@ func NewRootCmd() *RootCmd {
 	rootCmd.AddCommand(NewKubernetesCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewKubernetesInClusterCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewDockerCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewPodmanCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewLocalCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewImagesCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewInspectCmd())
+	rootCmd.AddCommand(NewRepositoryCmd())
+	rootCmd.AddCommand(NewServeCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewCompletionCmd())
 
 	ret.StripSensitive()
@@ -231,17 +290,20 @@ func (r *RootCmd) SetArgs(args []string) {
 }
 
 // Execute proxies the cobra.Command execution.
+// Commands get a context canceled on interrupt, see cobra.Command.Context.
 func (r *RootCmd) Execute() error {
-	return r.c.Execute()
+	return r.c.ExecuteContext(signals.WithStandardSignals(context.Background()))
 }
 
 // Start creates the root command and runs it.
+// It exits with one of the Exit* codes when the command fails.
 //执行顺序：init()里除了cobra.OnInitialize()的语句 -> Start() -> init()中的cobra.OnInitialize(initConfig)
 // -> Start()中的rootCmd.PersistentPreRunE = persistentValidateFunc(ret, rootOpts) -> rootCmd.Run()
 func Start() {
 	root := NewRootCmd()
 	if err := root.Execute(); err != nil {
-		logger.WithError(err).Fatal("error executing driverkit")
+		logger.WithError(err).Error("error executing driverkit")
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -2,19 +2,46 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
+		"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/creasty/defaults"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/driverkit"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"github.com/falcosecurity/driverkit/validate"
 	"github.com/go-playground/validator/v10"
 	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 // OutputOptions wraps the two drivers that driverkit builds.
+//
+// Output paths may contain the fields of builder.OutputNameData, eg. {{ .KernelRelease }}.
+// With an output directory, both drivers are laid out into it, named after the Name preset or template.
 type OutputOptions struct {
-	Module string `validate:"required_without=Probe,filepath,omitempty,endswith=.ko" name:"--output-module"`
-	Probe  string `validate:"required_without=Module,filepath,omitempty,endswith=.o" name:"--output-probe"`
+	Module string `validate:"required_without_all=Probe Dir,filepath,omitempty,endswith=.ko" name:"--output-module"`
+	Probe  string `validate:"required_without_all=Module Dir,filepath,omitempty,endswith=.o" name:"--output-probe"`
+	Dir    string `validate:"omitempty" name:"--output-dir"`
+	Name   string `validate:"omitempty" name:"--output-name"`
+}
+
+// PublishOptions describe where to publish the artifacts after the build.
+type PublishOptions struct {
+	URL      string `validate:"omitempty,startswith=s3://" name:"--publish"`
+	Endpoint string `validate:"omitempty,url" name:"--publish-endpoint"`
+	Region   string `validate:"omitempty" name:"--publish-region"`
+	OCIRef   string `validate:"omitempty" name:"--push-oci"`
+}
+
+// HookOptions are the shell commands run around each build.
+type HookOptions struct {
+	Pre  string `validate:"omitempty" name:"--pre-build-hook"`
+	Post string `validate:"omitempty" name:"--post-build-hook"`
 }
 
 type RepoOptions struct {
@@ -35,11 +62,26 @@ type RootOptions struct {
 	BuilderImage     	  string   `validate:"omitempty,imagename" name:"--builderimage"`
 	BuilderRepos     	  []string `validate:"omitempty" name:"--builderrepo"`
 	GCCVersion       	  string   `validate:"omitempty,semvertolerant" name:"--gccversion"`
-	KernelUrls       	  []string `name:"--kernelurls"`
+	KernelUrls       	  []string `validate:"omitempty,dive,httpurl" name:"--kernelurls"`
 	Repo             	  RepoOptions
 	Output           	  OutputOptions
+	Publish          	  PublishOptions
 
 	LocalKernelDir		  string	`validate:"omitempty,isExistDirPath" name:"--localkerneldir"`
+	CacheDir			  string	`validate:"omitempty" name:"--cachedir"`
+	KernelHeadersCache	  string	`validate:"omitempty" name:"--headerscache"`
+	BuildLog			  string	`validate:"omitempty" name:"--build-log"`
+	Diagnostics			  string	`validate:"omitempty" name:"--diagnostics"`
+	DiagnosticsFormat	  string	`validate:"omitempty,oneof=json sarif" name:"--diagnostics-format"`
+	Repository			  string	`validate:"omitempty" name:"--repository"`
+	Hooks				  HookOptions
+	EventsJSON			  string	`validate:"omitempty" name:"--events-json"`
+
+	// events receives the progress of the builds, when EventsJSON is set
+	events				  builder.EventHandler
+
+	// perNodeKernel is true when target, kernel release and architecture come from the cluster nodes
+	perNodeKernel		  bool
 }
 
 func init() {
@@ -61,14 +103,28 @@ func (ro *RootOptions) Validate() []error {
 		errors := err.(validator.ValidationErrors)
 		errArr := []error{}
 		for _, e := range errors {
+			if ro.perNodeKernel && (e.StructField() == "Target" || e.StructField() == "KernelRelease") {
+				continue
+			}
 			// Translate each error one at a time
 			errArr = append(errArr, fmt.Errorf(e.Translate(validate.T)))
 		}
-		return errArr
+		if len(errArr) > 0 {
+			return errArr
+		}
+	}
+	if err := ro.toRequest().ValidateOutput(); err != nil {
+		return []error{err}
+	}
+	if ro.perNodeKernel {
+		return nil
 	}
 
 	// check that the kernel versions supports at least one of probe and module
-	kr := kernelrelease.FromString(ro.KernelRelease)
+	kr, err := kernelrelease.Parse(ro.KernelRelease)
+	if err != nil {
+		return []error{err}
+	}
 	kr.Architecture = kernelrelease.Architecture(ro.Architecture)
 	if !kr.SupportsModule() && !kr.SupportsProbe() {
 		return []error{fmt.Errorf("both module and probe are not supported by given options")}
@@ -88,6 +144,10 @@ func (ro *RootOptions) Log() {
 		fields["output-probe"] = ro.Output.Probe
 
 	}
+	if ro.Output.Dir != "" {
+		fields["output-dir"] = ro.Output.Dir
+		fields["output-name"] = ro.Output.Name
+	}
 	fields["modulefilepath"] = ro.ModuleFilePath
 	if ro.KernelRelease != "" {
 		fields["kernelrelease"] = ro.KernelRelease
@@ -112,52 +172,150 @@ func (ro *RootOptions) Log() {
 	if ro.LocalKernelDir != "" {
 		fields["localkerneldir"] = ro.LocalKernelDir
 	}
+	if ro.CacheDir != "" {
+		fields["cachedir"] = ro.CacheDir
+	}
+	if ro.BuildLog != "" {
+		fields["build-log"] = ro.BuildLog
+	}
+	if ro.Diagnostics != "" {
+		fields["diagnostics"] = ro.Diagnostics
+		fields["diagnostics-format"] = ro.DiagnosticsFormat
+	}
+	if ro.KernelHeadersCache != "" {
+		fields["headerscache"] = ro.KernelHeadersCache
+	}
+	if ro.Repository != "" {
+		fields["repository"] = ro.Repository
+	}
+	if ro.Hooks.Pre != "" {
+		fields["pre-build-hook"] = ro.Hooks.Pre
+	}
+	if ro.Hooks.Post != "" {
+		fields["post-build-hook"] = ro.Hooks.Post
+	}
+	if ro.EventsJSON != "" {
+		fields["events-json"] = ro.EventsJSON
+	}
+	if ro.Publish.URL != "" {
+		fields["publish"] = ro.Publish.URL
+		if ro.Publish.Endpoint != "" {
+			fields["publish-endpoint"] = ro.Publish.Endpoint
+		}
+		fields["publish-region"] = ro.Publish.Region
+	}
+	if ro.Publish.OCIRef != "" {
+		fields["push-oci"] = ro.Publish.OCIRef
+	}
 
 	logger.WithFields(fields).Debug("running with options")
 }
 
-func (ro *RootOptions) toBuild() *builder.Build {
-	kernelConfigData := ro.KernelConfigData
-	if len(kernelConfigData) == 0 {
-		kernelConfigData = "bm8tZGF0YQ==" // no-data
-	}
-
-	build := &builder.Build{
-		TargetType:       		builder.Type(ro.Target),
-		ModuleFilePath:   		ro.ModuleFilePath,
-		KernelVersion:    		ro.KernelVersion,
-		KernelRelease:    		ro.KernelRelease,
-		Architecture:     		ro.Architecture,
-		KernelConfigData: 		kernelConfigData,
-		ModuleOutPutFilePath:   ro.Output.Module,
-		ProbeFilePath:    		ro.Output.Probe,
-		ModuleDriverName: 		ro.ModuleDriverName,
-		ModuleDeviceName: 		ro.ModuleDeviceName,
-		GCCVersion:       		ro.GCCVersion,
-		BuilderImage:     		ro.BuilderImage,
-		BuilderRepos:     		ro.BuilderRepos,
-		KernelUrls:       		ro.KernelUrls,
-		RepoOrg:          		ro.Repo.Org,
-		RepoName:         		ro.Repo.Name,
-		LocalKernelDir: 		ro.LocalKernelDir,
-	}
-
-	// Always append falcosecurity repo; Note: this is a prio first slice
-	// therefore, default falcosecurity repo has lowest prio.
-	build.BuilderRepos = append(build.BuilderRepos, "docker.io/falcosecurity/driverkit")
-
-	// attempt the build in case it comes from an invalid config
-	kr := build.KernelReleaseFromBuildConfig()
-	if len(build.ModuleOutPutFilePath) > 0 && !kr.SupportsModule() {
-		build.ModuleOutPutFilePath = ""
-		logger.Warningf("Skipping build attempt of module for unsupported kernel version %s", kr.String())
-	}
-	if len(build.ProbeFilePath) > 0 && !kr.SupportsProbe() {
-		build.ProbeFilePath = ""
-		logger.Warningf("Skipping build attempt of probe for unsupported kernel version %s", kr.String())
-	}
-
-	return build
+// forNodeKernel returns a copy of the options targeting the given node kernel.
+// Output paths get the kernel in their name, so that the artifacts of different kernels do not collide.
+func (ro *RootOptions) forNodeKernel(nk driverbuilder.NodeKernel) *RootOptions {
+	opts := *ro
+	opts.perNodeKernel = false
+	opts.Target = nk.Target.String()
+	opts.KernelRelease = nk.KernelRelease
+	opts.KernelVersion = nk.KernelVersion
+	opts.Architecture = nk.Architecture
+	// Templated output paths already name the kernel
+	if !builder.IsOutputTemplate(ro.Output.Module) {
+		opts.Output.Module = nodeKernelOutputPath(ro.Output.Module, nk)
+	}
+	if !builder.IsOutputTemplate(ro.Output.Probe) {
+		opts.Output.Probe = nodeKernelOutputPath(ro.Output.Probe, nk)
+	}
+	opts.BuildLog = nodeKernelOutputPath(ro.BuildLog, nk)
+	opts.Diagnostics = nodeKernelOutputPath(ro.Diagnostics, nk)
+	return &opts
+}
+
+// nodeKernelOutputPath turns eg. falco.ko into falco_ubuntu_5.15.0-1019-aws_20_amd64.ko
+func nodeKernelOutputPath(p string, nk driverbuilder.NodeKernel) string {
+	if len(p) == 0 {
+		return ""
+	}
+	ext := filepath.Ext(p)
+	return fmt.Sprintf("%s_%s_%s_%s_%s%s", strings.TrimSuffix(p, ext), nk.Target, nk.KernelRelease, nk.KernelVersion, nk.Architecture, ext)
+}
+
+// toRequest maps the options onto the library build request.
+func (ro *RootOptions) toRequest() *driverkit.BuildRequest {
+	return &driverkit.BuildRequest{
+		Target:				ro.Target,
+		KernelRelease:		ro.KernelRelease,
+		KernelVersion:		ro.KernelVersion,
+		Architecture:		ro.Architecture,
+		KernelConfigData:	ro.KernelConfigData,
+		ModuleFilePath:		ro.ModuleFilePath,
+		ModuleDriverName:	ro.ModuleDriverName,
+		ModuleDeviceName:	ro.ModuleDeviceName,
+		BuilderImage:		ro.BuilderImage,
+		BuilderRepos:		ro.BuilderRepos,
+		GCCVersion:			ro.GCCVersion,
+		KernelUrls:			ro.KernelUrls,
+		RepoOrg:			ro.Repo.Org,
+		RepoName:			ro.Repo.Name,
+		OnlineMode:			configOptions.OnlineMode,
+		LocalKernelDir:		ro.LocalKernelDir,
+		Output: driverkit.Output{
+			Module:		ro.Output.Module,
+			Probe:		ro.Output.Probe,
+			Dir:		ro.Output.Dir,
+			Name:		ro.Output.Name,
+			Presets:	viper.GetStringMapString("output.presets"),
+		},
+		Publish: driverkit.Publish{
+			URL:		ro.Publish.URL,
+			Endpoint:	ro.Publish.Endpoint,
+			Region:		ro.Publish.Region,
+			OCIRef:		ro.Publish.OCIRef,
+		},
+		Hooks: driverkit.Hooks{
+			Pre:	ro.Hooks.Pre,
+			Post:	ro.Hooks.Post,
+		},
+		Events:				ro.events,
+		Timeouts: driverkit.Timeouts{
+			Resolve:	time.Duration(viper.GetInt("resolve-timeout"))*time.Second,
+			Pull:		time.Duration(viper.GetInt("pull-timeout"))*time.Second,
+			Copy:		time.Duration(viper.GetInt("copy-timeout"))*time.Second,
+			Hook:		time.Duration(viper.GetInt("hook-timeout"))*time.Second,
+		},
+		CacheDir:			ro.CacheDir,
+		KernelHeadersCache:	ro.KernelHeadersCache,
+		RepositoryDir:		ro.Repository,
+		BuildLog:			ro.BuildLog,
+		Diagnostics:		ro.Diagnostics,
+		DiagnosticsFormat:	ro.DiagnosticsFormat,
+	}
+}
+
+// openEvents sets up the line-delimited JSON stream of the build events, into the --events-json file or into out for "-".
+// The file is kept open until exit, since every build of the run reports to it.
+func (ro *RootOptions) openEvents(out io.Writer) error {
+	if len(ro.EventsJSON) == 0 || ro.events != nil {
+		return nil
+	}
+	if ro.EventsJSON != "-" {
+		f, err := os.Create(ro.EventsJSON)
+		if err != nil {
+			return fmt.Errorf("could not create the events file: %v", err)
+		}
+		out = f
+	}
+	ro.events = builder.NewJSONEventWriter(out)
+	return nil
+}
+
+func (ro *RootOptions) toBuild() (*builder.Build, error) {
+	build, err := ro.toRequest().Build()
+	if err != nil {
+		return nil, fmt.Errorf("error preparing the build: %w", err)
+	}
+	return build, nil
 }
 
 // RootOptionsLevelValidation validates KernelConfigData and Target at the same time.
@@ -178,6 +336,11 @@ func RootOptionsLevelValidation(level validator.StructLevel) {
 		level.ReportError(opts.KernelVersion, "kernelVersion", "KernelVersion", "required_kernelversion_with_target_ubuntu", "")
 	}
 
+	// The output directory lays out both the drivers by itself
+	if opts.Output.Dir != "" && (opts.Output.Module != "" || opts.Output.Probe != "") {
+		level.ReportError(opts.Output.Dir, "outputDir", "OutputDir", "excluded_outputdir_with_output_paths", "")
+	}
+
 	// Target redhat requires a valid build image (has to be registered in order to download packages)
 	if opts.Target == builder.TargetTypeRedhat.String() && opts.BuilderImage == "" {
 		level.ReportError(opts.BuilderImage, "builderimage", "builderimage", "required_builderimage_with_target_redhat", "")
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/server"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var validServeProcessors = []string{"docker", "podman", "local"}
+
+// ServeOptions are the flags of the build service.
+type ServeOptions struct {
+	Listen    string
+	Processor string
+	Workers   int
+	QueueSize int
+	DataDir   string
+	// AllowLocal allows the local processor, running the build scripts of the requests straight on the server host
+	AllowLocal bool
+	// AllowBuilderImage and AllowKernelUrls let the requests pick the builder image and the kernel header urls
+	AllowBuilderImage bool
+	AllowKernelUrls   bool
+	// JobRetention and MaxFinishedJobs bound the finished builds kept, along with their logs and artifacts
+	JobRetention    time.Duration
+	MaxFinishedJobs int
+}
+
+// buildSpec are the RootOptions a build request may set.
+// The options about the server filesystem (output paths, caches, local kernel files, ...) are not part of it,
+// while the builder image and the kernel urls are only accepted when the server allows them.
+type buildSpec struct {
+	Architecture     string   `json:"architecture"`
+	KernelVersion    string   `json:"kernelversion"`
+	ModuleDriverName string   `json:"moduledrivername"`
+	ModuleDeviceName string   `json:"moduledevicename"`
+	KernelRelease    string   `json:"kernelrelease"`
+	Target           string   `json:"target"`
+	KernelConfigData string   `json:"kernelconfigdata"`
+	BuilderImage     string   `json:"builderimage"`
+	GCCVersion       string   `json:"gccversion"`
+	KernelUrls       []string `json:"kernelurls"`
+	OutputName       string   `json:"outputname"`
+}
+
+// buildFromSpec returns the server.BuildFunc applying the build requests over the options the server runs with.
+func buildFromSpec(rootOpts *RootOptions, serveOpts *ServeOptions) server.BuildFunc {
+	return func(data json.RawMessage, moduleFile string, outputDir string) (*builder.Build, error) {
+		var spec buildSpec
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			return nil, &server.ValidationError{Errors: []error{fmt.Errorf("invalid spec: %v", err)}}
+		}
+		var errs []error
+		if len(spec.BuilderImage) > 0 && !serveOpts.AllowBuilderImage {
+			errs = append(errs, fmt.Errorf("builderimage is not allowed by the server, see --allow-builderimage"))
+		}
+		if len(spec.KernelUrls) > 0 && !serveOpts.AllowKernelUrls {
+			errs = append(errs, fmt.Errorf("kernelurls is not allowed by the server, see --allow-kernelurls"))
+		}
+		if len(errs) > 0 {
+			return nil, &server.ValidationError{Errors: errs}
+		}
+
+		opts := *rootOpts
+		opts.perNodeKernel = false
+		opts.ModuleFilePath = moduleFile
+		opts.Output = OutputOptions{Dir: outputDir, Name: spec.OutputName}
+		if len(spec.Architecture) > 0 {
+			opts.Architecture = spec.Architecture
+		}
+		if len(spec.KernelVersion) > 0 {
+			opts.KernelVersion = spec.KernelVersion
+		}
+		opts.ModuleDriverName = spec.ModuleDriverName
+		opts.ModuleDeviceName = spec.ModuleDeviceName
+		opts.KernelRelease = spec.KernelRelease
+		opts.Target = spec.Target
+		opts.KernelConfigData = spec.KernelConfigData
+		opts.BuilderImage = spec.BuilderImage
+		opts.GCCVersion = spec.GCCVersion
+		opts.KernelUrls = spec.KernelUrls
+		// The diagnosis of the job is written by the server itself
+		opts.BuildLog = ""
+		opts.Diagnostics = ""
+
+		if errs := opts.Validate(); errs != nil {
+			return nil, &server.ValidationError{Errors: errs}
+		}
+		return opts.toRequest().Build()
+	}
+}
+
+// NewServeCmd creates the `driverkit serve` command.
+func NewServeCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	serveOptions := &ServeOptions{}
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a REST API building kernel modules and eBPF probes on demand. Build flags act as the defaults of the build requests.",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			var bp driverbuilder.BuildProcessor
+			switch serveOptions.Processor {
+			case "docker":
+				bp = driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), dockerOptions.PoolSize)
+			case "podman":
+				var err error
+				bp, err = driverbuilder.NewPodmanBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"), dockerOptions.PoolSize, podmanOptions.Socket)
+				if err != nil {
+					return err
+				}
+			case "local":
+				if !serveOptions.AllowLocal {
+					return fmt.Errorf("the local processor runs the build scripts on this host, it requires --allow-local")
+				}
+				bp = driverbuilder.NewLocalBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy"))
+			default:
+				return fmt.Errorf("processor must be one of %v", validServeProcessors)
+			}
+			if serveOptions.Workers < 1 || serveOptions.QueueSize < 0 {
+				return fmt.Errorf("--workers must be at least 1, and --queue-size cannot be negative")
+			}
+			if serveOptions.JobRetention < 0 || serveOptions.MaxFinishedJobs < 0 {
+				return fmt.Errorf("--job-retention and --max-finished-jobs cannot be negative")
+			}
+
+			dataDir := serveOptions.DataDir
+			if len(dataDir) == 0 {
+				var err error
+				if dataDir, err = os.MkdirTemp("", "driverkit-serve-"); err != nil {
+					return err
+				}
+				defer os.RemoveAll(dataDir)
+			}
+			dataDir, err := filepath.Abs(dataDir)
+			if err != nil {
+				return err
+			}
+			if configOptions.DryRun {
+				logger.WithField("processor", bp.String()).Info("dry run, not serving")
+				return nil
+			}
+
+			srv := server.NewServer(bp, buildFromSpec(rootOpts, serveOptions), dataDir, serveOptions.Workers, serveOptions.QueueSize)
+			srv.JobRetention = serveOptions.JobRetention
+			srv.MaxFinishedJobs = serveOptions.MaxFinishedJobs
+			return srv.Run(c.Context(), serveOptions.Listen)
+		},
+	}
+	flags := serveCmd.Flags()
+	flags.StringVar(&serveOptions.Listen, "listen", ":8080", "address the API listens on")
+	flags.StringVar(&serveOptions.Processor, "processor", "docker", fmt.Sprintf("processor running the builds, one of %v (local requires --allow-local)", validServeProcessors))
+	flags.IntVar(&serveOptions.Workers, "workers", 1, "number of builds running at the same time")
+	flags.IntVar(&serveOptions.QueueSize, "queue-size", 16, "number of builds waiting for a worker, further requests are rejected")
+	flags.StringVar(&serveOptions.DataDir, "data-dir", "", "directory keeping the uploaded modules, the logs and the artifacts of the builds (a temporary one, removed on exit, if empty)")
+	flags.DurationVar(&serveOptions.JobRetention, "job-retention", server.DefaultJobRetention, "how long finished builds are kept, along with their logs and artifacts (0 keeps them regardless of their age)")
+	flags.IntVar(&serveOptions.MaxFinishedJobs, "max-finished-jobs", server.DefaultMaxFinishedJobs, "number of finished builds kept, the oldest ones are removed first (0 does not bound them)")
+	flags.BoolVar(&serveOptions.AllowLocal, "allow-local", false, "allow the local processor, running the build scripts of the requests straight on this host")
+	flags.BoolVar(&serveOptions.AllowBuilderImage, "allow-builderimage", false, "let the build requests pick the builder image")
+	flags.BoolVar(&serveOptions.AllowKernelUrls, "allow-kernelurls", false, "let the build requests pick the kernel header urls")
+	addDockerFlags(flags)
+	addPodmanFlags(flags)
+	serveCmd.RegisterFlagCompletionFunc("processor", func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return validServeProcessors, cobra.ShellCompDirectiveDefault
+	})
+	// Add root flags
+	serveCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return serveCmd
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestBuildFromSpec(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "libs.tar.gz")
+	assert.NilError(t, os.WriteFile(module, []byte("module source"), 0644))
+	rootOpts := NewRootOptions()
+	rootOpts.Architecture = "amd64"
+	serveOpts := &ServeOptions{}
+
+	// The builder image and the kernel urls are up to the server
+	build := buildFromSpec(rootOpts, serveOpts)
+	_, err := build([]byte(`{"target":"ubuntu","kernelrelease":"5.15.0-1019-aws","builderimage":"evil/image:latest"}`), module, dir)
+	assert.ErrorContains(t, err, "builderimage is not allowed by the server")
+	_, err = build([]byte(`{"target":"ubuntu","kernelrelease":"5.15.0-1019-aws","kernelurls":["https://example.com/headers.deb"]}`), module, dir)
+	assert.ErrorContains(t, err, "kernelurls is not allowed by the server")
+
+	// Even when allowed, the kernel urls end up into the build script, so only http(s) urls are accepted
+	serveOpts.AllowKernelUrls = true
+	_, err = build([]byte(`{"target":"ubuntu","kernelrelease":"5.15.0-1019-aws","kernelurls":["http://x; touch /tmp/pwned"]}`), module, dir)
+	assert.ErrorContains(t, err, "must be a valid http(s) URL")
+}
@@ -0,0 +1,327 @@
+// Package diagnostics extracts the cause of a failed build from the output of the build script.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind is the category of a diagnostic.
+type Kind string
+
+const (
+	// KindCompiler is a gcc or clang diagnostic, eg. "file.c:12:3: error: ..."
+	KindCompiler Kind = "compiler"
+	// KindMissingHeader is a header the compiler could not find.
+	KindMissingHeader Kind = "missing-header"
+	// KindUndefinedSymbol is a symbol modpost could not resolve against the kernel.
+	KindUndefinedSymbol Kind = "undefined-symbol"
+	// KindVermagic is a mismatch between the module and the kernel it is built for.
+	KindVermagic Kind = "vermagic"
+)
+
+// Severity of a diagnostic.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+// Diagnostic is a single issue found in the build output.
+type Diagnostic struct {
+	Kind     Kind     `json:"kind"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Message  string   `json:"message"`
+	Symbol   string   `json:"symbol,omitempty"`
+	Header   string   `json:"header,omitempty"`
+	// Hint is a short, human readable, explanation of the likely cause.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Summary returns a one line description of the diagnostic.
+func (d Diagnostic) Summary() string {
+	var s string
+	switch d.Kind {
+	case KindUndefinedSymbol:
+		s = fmt.Sprintf("undefined symbol %s", d.Symbol)
+	case KindMissingHeader:
+		s = fmt.Sprintf("missing header %s", d.Header)
+	case KindCompiler:
+		s = fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	default:
+		s = d.Message
+	}
+	if len(d.Hint) > 0 {
+		s += ": " + d.Hint
+	}
+	return s
+}
+
+// Report is the structured result of the diagnosis of a build.
+type Report struct {
+	Summary     []string     `json:"summary"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Empty tells whether nothing was found.
+func (r *Report) Empty() bool {
+	return r == nil || len(r.Diagnostics) == 0
+}
+
+// Errors returns the diagnostics with error severity.
+func (r *Report) Errors() []Diagnostic {
+	var errs []Diagnostic
+	for _, d := range r.Diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Write writes the report in the given format, either json or sarif.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "", FormatJSON:
+		return r.WriteJSON(w)
+	case FormatSARIF:
+		return r.WriteSARIF(w)
+	}
+	return fmt.Errorf("unknown diagnostics format %q", format)
+}
+
+// Supported report formats.
+const (
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+// Error is a build error along with the diagnosis of the build output.
+type Error struct {
+	Err    error
+	Report *Report
+}
+
+func (e *Error) Error() string {
+	if len(e.Report.Summary) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Report.Summary[0])
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var (
+	compilerRegex        = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+	missingHeaderRegex   = regexp.MustCompile(`^(\S+): No such file or directory$`)
+	undefinedSymbolRegex = regexp.MustCompile(`^(WARNING|ERROR): (?:modpost: )?"([^"]+)" \[([^\]]+)\] undefined!$`)
+	versionMagicRegex    = regexp.MustCompile(`version magic '([^']*)' should be '([^']*)'`)
+	symbolVersionRegex   = regexp.MustCompile(`disagrees about version of symbol (\S+)`)
+)
+
+// symbolConfigs maps the kernel symbols used by the drivers to the kernel config providing them.
+var symbolConfigs = map[string]string{
+	"tracepoint_probe_register":   "CONFIG_TRACEPOINTS",
+	"tracepoint_probe_unregister": "CONFIG_TRACEPOINTS",
+	"for_each_kernel_tracepoint":  "CONFIG_TRACEPOINTS",
+	"__fentry__":                  "CONFIG_FUNCTION_TRACER",
+	"mcount":                      "CONFIG_FUNCTION_TRACER",
+	"_mcount":                     "CONFIG_FUNCTION_TRACER",
+	"__stack_chk_fail":            "CONFIG_STACKPROTECTOR",
+	"__stack_chk_guard":           "CONFIG_STACKPROTECTOR",
+}
+
+// symbolPrefixConfigs is like symbolConfigs, for whole families of symbols.
+var symbolPrefixConfigs = []struct {
+	prefix string
+	config string
+}{
+	{"__tracepoint_", "CONFIG_TRACEPOINTS"},
+	{"__traceiter_", "CONFIG_TRACEPOINTS"},
+	{"__x86_indirect_thunk_", "CONFIG_RETPOLINE"},
+	{"__x86_return_thunk", "CONFIG_RETHUNK"},
+}
+
+func undefinedSymbolHint(symbol string) string {
+	config, ok := symbolConfigs[symbol]
+	if !ok {
+		for _, p := range symbolPrefixConfigs {
+			if strings.HasPrefix(symbol, p.prefix) {
+				config, ok = p.config, true
+				break
+			}
+		}
+	}
+	if ok {
+		return fmt.Sprintf("missing %s in kernel config", config)
+	}
+	return "the kernel headers do not match the kernel, or a required kernel config is missing"
+}
+
+func missingHeaderHint(header string) string {
+	switch {
+	case strings.HasPrefix(header, "generated/"):
+		return "kernel headers are not prepared (make modules_prepare did not run)"
+	case strings.HasPrefix(header, "asm/"):
+		return "kernel headers are for a different architecture, or incomplete"
+	case strings.HasPrefix(header, "linux/") || strings.HasPrefix(header, "uapi/"):
+		return "kernel headers are incomplete, or too old for the driver"
+	}
+	return "header not found, check the kernel headers and the driver version"
+}
+
+// Parser finds the diagnostics in a build output, fed one line at a time.
+type Parser struct {
+	diagnostics []Diagnostic
+	seen        map[string]bool
+	// compilerDiffers is set while reading the lines following the kernel warning about the compiler
+	compilerDiffers *Diagnostic
+}
+
+// NewParser returns an empty Parser.
+func NewParser() *Parser {
+	return &Parser{seen: make(map[string]bool)}
+}
+
+// Parse returns the diagnosis of the whole output read from r.
+func Parse(r io.Reader) (*Report, error) {
+	p := NewParser()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		p.Add(line)
+	}
+	return p.Report(), nil
+}
+
+func (p *Parser) add(d Diagnostic) {
+	// Headers get included by many files, keep every issue once
+	key := fmt.Sprintf("%s|%s|%d|%d|%s", d.Kind, d.File, d.Line, d.Column, d.Message)
+	if p.seen[key] {
+		return
+	}
+	p.seen[key] = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// Add parses a single line of the build output.
+func (p *Parser) Add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	trimmed := strings.TrimSpace(line)
+
+	if p.compilerDiffers != nil {
+		if strings.HasPrefix(trimmed, "The kernel was built by:") || strings.HasPrefix(trimmed, "You are using:") {
+			p.compilerDiffers.Message += "; " + trimmed
+			return
+		}
+		p.add(*p.compilerDiffers)
+		p.compilerDiffers = nil
+	}
+
+	if strings.Contains(trimmed, "the compiler differs from the one used to build the kernel") {
+		p.compilerDiffers = &Diagnostic{
+			Kind:     KindVermagic,
+			Severity: SeverityWarning,
+			Message:  "the compiler differs from the one used to build the kernel",
+			Hint:     "use --gccversion to match the compiler of the kernel",
+		}
+		return
+	}
+
+	if m := undefinedSymbolRegex.FindStringSubmatch(trimmed); m != nil {
+		p.add(Diagnostic{
+			Kind:     KindUndefinedSymbol,
+			Severity: SeverityError,
+			File:     m[3],
+			Message:  fmt.Sprintf("%q undefined", m[2]),
+			Symbol:   m[2],
+			Hint:     undefinedSymbolHint(m[2]),
+		})
+		return
+	}
+
+	if m := versionMagicRegex.FindStringSubmatch(trimmed); m != nil {
+		p.add(Diagnostic{
+			Kind:     KindVermagic,
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("version magic %q should be %q", m[1], m[2]),
+			Hint:     "the module was built against headers of a different kernel",
+		})
+		return
+	}
+
+	if m := symbolVersionRegex.FindStringSubmatch(trimmed); m != nil {
+		p.add(Diagnostic{
+			Kind:     KindVermagic,
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("disagrees about version of symbol %s", m[1]),
+			Symbol:   m[1],
+			Hint:     "the module was built against headers of a different kernel",
+		})
+		return
+	}
+
+	m := compilerRegex.FindStringSubmatch(trimmed)
+	if m == nil {
+		return
+	}
+	d := Diagnostic{
+		Kind:    KindCompiler,
+		File:    m[1],
+		Message: m[5],
+	}
+	d.Line, _ = strconv.Atoi(m[2])
+	d.Column, _ = strconv.Atoi(m[3])
+	switch m[4] {
+	case "warning":
+		d.Severity = SeverityWarning
+	case "note":
+		d.Severity = SeverityNote
+	default:
+		d.Severity = SeverityError
+	}
+	if h := missingHeaderRegex.FindStringSubmatch(d.Message); h != nil {
+		d.Kind = KindMissingHeader
+		d.Header = h[1]
+		d.Hint = missingHeaderHint(h[1])
+	}
+	p.add(d)
+}
+
+// Report returns the diagnosis of the lines added so far.
+func (p *Parser) Report() *Report {
+	if p.compilerDiffers != nil {
+		p.add(*p.compilerDiffers)
+		p.compilerDiffers = nil
+	}
+	r := &Report{Diagnostics: append([]Diagnostic{}, p.diagnostics...)}
+	// Errors explain the failure better than warnings, and notes only make sense along their error
+	for _, sev := range []Severity{SeverityError, SeverityWarning} {
+		for _, d := range r.Diagnostics {
+			if d.Severity == sev && (sev == SeverityError || d.Kind != KindCompiler) {
+				r.Summary = append(r.Summary, d.Summary())
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,82 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const failedBuildOutput = `make -C /lib/modules/5.10.0/build M=/tmp/driver modules
+  CC [M]  /tmp/driver/main.o
+In file included from /tmp/driver/main.c:23:
+/tmp/driver/ppm.h:14:10: fatal error: generated/utsrelease.h: No such file or directory
+   14 | #include <generated/utsrelease.h>
+      |          ^~~~~~~~~~~~~~~~~~~~~~~~
+/tmp/driver/main.c:120:5: warning: unused variable 'x' [-Wunused-variable]
+/tmp/driver/main.c:130:9: error: implicit declaration of function 'foo'
+/tmp/driver/main.c:130:9: error: implicit declaration of function 'foo'
+warning: the compiler differs from the one used to build the kernel
+  The kernel was built by: gcc (Debian 10.2.1-6) 10.2.1 20210110
+  You are using:           gcc (Debian 8.3.0-6) 8.3.0
+WARNING: modpost: "tracepoint_probe_register" [/tmp/driver/falco.ko] undefined!
+ERROR: modpost: "some_symbol" [/tmp/driver/falco.ko] undefined!
+make: *** [Makefile:8: all] Error 2
+`
+
+func TestParse(t *testing.T) {
+	r, err := Parse(strings.NewReader(failedBuildOutput))
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Diagnostics), 6)
+
+	header := r.Diagnostics[0]
+	assert.Equal(t, header.Kind, KindMissingHeader)
+	assert.Equal(t, header.Severity, SeverityError)
+	assert.Equal(t, header.Header, "generated/utsrelease.h")
+	assert.Equal(t, header.File, "/tmp/driver/ppm.h")
+	assert.Equal(t, header.Line, 14)
+	assert.Equal(t, header.Column, 10)
+
+	assert.Equal(t, r.Diagnostics[1].Severity, SeverityWarning)
+	assert.Equal(t, r.Diagnostics[2].Message, "implicit declaration of function 'foo'")
+
+	compiler := r.Diagnostics[3]
+	assert.Equal(t, compiler.Kind, KindVermagic)
+	assert.Assert(t, strings.Contains(compiler.Message, "You are using:"))
+
+	symbol := r.Diagnostics[4]
+	assert.Equal(t, symbol.Kind, KindUndefinedSymbol)
+	assert.Equal(t, symbol.Symbol, "tracepoint_probe_register")
+	assert.Equal(t, symbol.Summary(), "undefined symbol tracepoint_probe_register: missing CONFIG_TRACEPOINTS in kernel config")
+	assert.Equal(t, r.Diagnostics[5].Symbol, "some_symbol")
+
+	// Errors first, then the non-compiler warnings
+	assert.Equal(t, len(r.Summary), 5)
+	assert.Assert(t, strings.HasPrefix(r.Summary[0], "missing header generated/utsrelease.h"))
+	assert.Assert(t, strings.HasPrefix(r.Summary[4], "the compiler differs"))
+}
+
+func TestParseNothing(t *testing.T) {
+	r, err := Parse(strings.NewReader("make: Nothing to be done for 'all'.\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, r.Empty())
+}
+
+func TestWriteSARIF(t *testing.T) {
+	r, err := Parse(strings.NewReader(failedBuildOutput))
+	assert.NilError(t, err)
+	var buf bytes.Buffer
+	assert.NilError(t, r.Write(&buf, FormatSARIF))
+
+	var log sarifLog
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, log.Version, sarifVersion)
+	assert.Equal(t, len(log.Runs), 1)
+	results := log.Runs[0].Results
+	assert.Equal(t, len(results), len(r.Diagnostics))
+	assert.Equal(t, results[0].RuleID, string(KindMissingHeader))
+	assert.Equal(t, results[0].Locations[0].PhysicalLocation.Region.StartLine, 14)
+	assert.Equal(t, len(results[3].Locations), 0)
+}
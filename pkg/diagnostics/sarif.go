@@ -0,0 +1,112 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/falcosecurity/driverkit/pkg/version"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// The subset of SARIF 2.1.0 needed to report build diagnostics.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+var sarifRules = []sarifRule{
+	{ID: string(KindCompiler), ShortDescription: sarifMessage{Text: "compiler diagnostic"}},
+	{ID: string(KindMissingHeader), ShortDescription: sarifMessage{Text: "header not found"}},
+	{ID: string(KindUndefinedSymbol), ShortDescription: sarifMessage{Text: "symbol undefined in the kernel"}},
+	{ID: string(KindVermagic), ShortDescription: sarifMessage{Text: "module and kernel mismatch"}},
+}
+
+// WriteSARIF writes the report as a SARIF 2.1.0 log.
+func (r *Report) WriteSARIF(w io.Writer) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:    "driverkit",
+			Version: version.String(),
+			Rules:   sarifRules,
+		}},
+		Results: []sarifResult{},
+	}
+	for _, d := range r.Diagnostics {
+		res := sarifResult{
+			RuleID:  string(d.Kind),
+			Level:   string(d.Severity),
+			Message: sarifMessage{Text: d.Summary()},
+		}
+		if len(d.File) > 0 {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: d.File},
+			}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			res.Locations = append(res.Locations, loc)
+		}
+		run.Results = append(run.Results, res)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	})
+}
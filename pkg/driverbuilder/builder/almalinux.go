@@ -2,6 +2,7 @@ package builder
 
 import (
 	_ "embed"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
@@ -38,7 +39,7 @@ func (c *alma) TemplateScript() string {
 	return almaTemplate
 }
 
-func (c *alma) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *alma) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	return fetchAlmaKernelURLS(kr), nil
 }
 
@@ -5,14 +5,13 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"database/sql"
 	_ "embed"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 	"strings"
 
@@ -75,8 +74,8 @@ func (a *amazonlinux) TemplateScript() string {
 	return amazonlinuxTemplate
 }
 
-func (a *amazonlinux) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
@@ -111,8 +110,8 @@ func (a *amazonlinux2022) Name() string {
 	return TargetTypeAmazonLinux2022.String()
 }
 
-func (a *amazonlinux2022) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2022) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux2022) repos() []string {
@@ -134,8 +133,8 @@ func (a *amazonlinux2) Name() string {
 	return TargetTypeAmazonLinux2.String()
 }
 
-func (a *amazonlinux2) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchAmazonLinuxPackagesURLs(a, kr)
+func (a *amazonlinux2) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchAmazonLinuxPackagesURLs(ctx, a, kr)
 }
 
 func (a *amazonlinux2) repos() []string {
@@ -186,7 +185,7 @@ func unzipFuncFromBuilder(a amazonBuilder) (unzipFunc, error) {
 	return nil, fmt.Errorf("unsupported extension: %s", a.ext())
 }
 
-func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
+func fetchAmazonLinuxPackagesURLs(ctx context.Context, a amazonBuilder, kv kernelrelease.KernelRelease) ([]string, error) {
 	urls := []string{}
 	visited := make(map[string]struct{})
 
@@ -197,7 +196,7 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 		}
 
 		// Obtain the repo URL by getting mirror URL content
-		mirrorRes, err := http.Get(mirror)
+		mirrorRes, err := httpGet(ctx, mirror)
 		if err != nil {
 			return nil, err
 		}
@@ -218,7 +217,7 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 			continue
 		}
 		// Download the repo database
-		repoRes, err := http.Get(repoDatabaseURL)
+		repoRes, err := httpGet(ctx, repoDatabaseURL)
 		logger.WithField("url", repoDatabaseURL).Debug("downloading...")
 		if err != nil {
 			return nil, err
@@ -268,7 +267,7 @@ func fetchAmazonLinuxPackagesURLs(a amazonBuilder, kv kernelrelease.KernelReleas
 			var href string
 			err = rows.Scan(&href)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
 			urls = append(urls, fmt.Sprintf("%s/%s", repo, href))
 		}
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"errors"
 	"fmt"
@@ -40,7 +41,7 @@ func (c *archlinux) TemplateScript() string {
 	return archlinuxTemplate
 }
 
-func (c *archlinux) URLs(cfg Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *archlinux) URLs(_ context.Context, cfg Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	urls := []string{}
 	possibleCompressionSuffixes := []string{
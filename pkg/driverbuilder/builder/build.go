@@ -1,7 +1,10 @@
 package builder
 
 import (
+	"context"
 	"fmt"
+	"path"
+	"time"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
@@ -26,20 +29,57 @@ type Build struct {
 	Images           		ImagesMap
 
 	LocalKernelDir			string
+	CacheDir				string
+	KernelHeadersCache		string
+	DockerHost				string
+	// OnlineMode is true when the builder images and the kernel headers are fetched from the internet,
+	// instead of being looked up locally
+	OnlineMode				bool
+	// ResolveTimeout bounds the lookup of the kernel headers and of the builder images (unbounded if zero)
+	ResolveTimeout			time.Duration
+	// PullTimeout bounds the pull of the builder image (unbounded if zero)
+	PullTimeout				time.Duration
+	// CopyTimeout bounds the copy of each artifact out of the builder (unbounded if zero)
+	CopyTimeout				time.Duration
+	// WorkDir relocates the build script work directory, DefaultWorkDir if empty
+	WorkDir					string
+	// HostToolchain is true when the build runs with the host compiler, instead of a builder image
+	HostToolchain			bool
+	// ResolvedKernelURLs are the kernel header packages the build script downloads, set by Script in online mode
+	ResolvedKernelURLs		[]string
+	// BuildLogPath is where to persist the full, timestamped, build script output (disabled if empty)
+	BuildLogPath			string
+	// DiagnosticsPath is where to write the diagnosis of a failed build (disabled if empty)
+	DiagnosticsPath			string
+	// DiagnosticsFormat is the format of the diagnosis, either json or sarif
+	DiagnosticsFormat		string
+	// PublishURL is where to publish the artifacts and their manifests, as s3://<bucket>[/<prefix>] (disabled if empty)
+	PublishURL				string
+	// PublishEndpoint is the S3-compatible endpoint to publish to, AWS S3 if empty
+	PublishEndpoint			string
+	// PublishRegion is the region of the publish bucket
+	PublishRegion			string
+	// PushOCIRef is the repository, and optionally the tag, to push the artifacts to as an OCI artifact (disabled if empty)
+	PushOCIRef				string
+	// RepositoryDir is the artifact repository the artifacts are added to, and looked up before building (disabled if empty)
+	RepositoryDir			string
+	// PreBuildHook is a shell command run before the build, failing the build if it fails (disabled if empty)
+	PreBuildHook			string
+	// PostBuildHook is a shell command run once the build is done or failed (disabled if empty)
+	PostBuildHook			string
+	// HookTimeout bounds each of the build hooks (unbounded if zero)
+	HookTimeout				time.Duration
+	// Events observes the progress of the build (disabled if nil)
+	Events					EventHandler
+	// startedAt is when the build reported EventStarted
+	startedAt				time.Time
 }
 
-var onlineMode bool
-func SetOnlineMode(mode bool) {
-	onlineMode = mode
-}
-func IsOnlineMode() bool {
-	return onlineMode
-}
-
-func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
-	kv := kernelrelease.FromString(b.KernelRelease)
+// KernelReleaseFromBuildConfig parses the kernel release of the build, for its architecture.
+func (b *Build) KernelReleaseFromBuildConfig() (kernelrelease.KernelRelease, error) {
+	kv, err := kernelrelease.Parse(b.KernelRelease)
 	kv.Architecture = kernelrelease.Architecture(b.Architecture)
-	return kv
+	return kv, err
 }
 
 func (b *Build) toGithubRepoArchive() string {
@@ -54,3 +94,45 @@ func (b *Build) ToConfig() Config {
 		Build:           b,
 	}
 }
+
+func (b *Build) workDir() string {
+	if len(b.WorkDir) > 0 {
+		return b.WorkDir
+	}
+	return DefaultWorkDir
+}
+
+// DriverDir returns the directory the build script stores the driver into.
+func (b *Build) DriverDir() string {
+	if len(b.WorkDir) > 0 {
+		return path.Join(b.WorkDir, "driver")
+	}
+	return DriverDirectory
+}
+
+// SupportDir returns the directory the build script reads its support files from:
+// the module Makefile, the driver config filler and the kernel config.
+func (b *Build) SupportDir() string {
+	if len(b.WorkDir) > 0 {
+		return path.Join(b.WorkDir, "driverkit")
+	}
+	return SupportDirectory
+}
+
+// ModulePath returns where the build script places the compiled module.
+func (b *Build) ModulePath() string {
+	return path.Join(b.DriverDir(), ModuleFileName)
+}
+
+// ProbePath returns where the build script places the compiled eBPF probe.
+func (b *Build) ProbePath() string {
+	return path.Join(b.DriverDir(), "bpf", ProbeFileName)
+}
+
+// WithTimeout is like context.WithTimeout, except that a timeout not greater than zero sets no deadline.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -2,23 +2,41 @@ package builder
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/exec"
 	"path"
+	"regexp"
 	"strings"
 	"text/template"
 
 	logger "github.com/sirupsen/logrus"
 )
 
+//go:embed templates/partials.sh
+var partialsTemplate string
+
+// DefaultWorkDir is the directory the build scripts work into, within the builder containers.
+const DefaultWorkDir = "/tmp"
+
 // DriverDirectory is the directory the processor uses to store the driver.
 const DriverDirectory = "/tmp/driver"
 
+// SupportDirectory is the directory the processors place the build script and its support files into, within the builder containers.
+const SupportDirectory = "/driverkit"
+
+// ModuleArchiveFileName is the file name of the module sources archive, into the work directory.
+const ModuleArchiveFileName = "kernel-module.tar.gz"
+
 // ModuleFileName is the standard file name for the kernel module.
 const ModuleFileName = "module.ko"
 
@@ -31,8 +49,19 @@ var ModuleFullPath = path.Join(DriverDirectory, ModuleFileName)
 // ProbeFullPath is the standard path for the eBPF probe. Builders must place the compiled probe at this location.
 var ProbeFullPath = path.Join(DriverDirectory, "bpf", ProbeFileName)
 
+// KernelHeadersCacheMountPath is where processors mount the kernel headers cache, if any.
+const KernelHeadersCacheMountPath = "/driverkit-headers"
+
+// KernelFileName returns the file name of the i-th local kernel headers package, into the work directory.
+func KernelFileName(i int) string {
+	return fmt.Sprintf("kernel%d", i)
+}
+
 var HeadersNotFoundErr = errors.New("kernel headers not found")
 
+// BuilderImageNotFoundErr is returned when no builder image provides the requested target and gcc version.
+var BuilderImageNotFoundErr = errors.New("builder image not found")
+
 // Config contains all the configurations needed to build the kernel module or the eBPF probe.
 type Config struct {
 	DriverName      string
@@ -42,20 +71,24 @@ type Config struct {
 }
 
 type commonTemplateData struct {
-	DriverBuildDir    string
-	ModuleDownloadURL string
-	ModuleDriverName  string
-	ModuleFullPath    string
-	BuildModule       bool
-	BuildProbe        bool
-	GCCVersion        string
+	WorkDir          string
+	SupportDir       string
+	DriverBuildDir   string
+	ModuleDriverName string
+	ModuleFullPath   string
+	BuildModule      bool
+	BuildProbe       bool
+	GCCVersion       string
+	GCCPath          string
+
+	KernelHeadersCacheDir string
 }
 
 // Builder represents a builder capable of generating a script for a driverkit target.
 type Builder interface {
 	Name() string
 	TemplateScript() string
-	URLs(c Config, kr kernelrelease.KernelRelease) ([]string, error)
+	URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error)
 	TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} // error return type is managed
 
 	SearchLocalKernelFilepath(cfg Config, kr kernelrelease.KernelRelease) ([]string, error)
@@ -67,22 +100,32 @@ type MinimumURLsBuilder interface {
 	MinimumURLs() int
 }
 
-func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(b.Name())
-	parsed, err := t.Parse(b.TemplateScript())
-	if err != nil {
-		return "", err
-	}
+// VermagicBuilder is an optional interface
+// to specify the kernel release found into the vermagic of the built module,
+// when it is not the requested one; an empty kernel release is not checked.
+type VermagicBuilder interface {
+	VermagicKernelRelease(kr kernelrelease.KernelRelease) string
+}
 
-	minimumURLs := 1
-	if bb, ok := b.(MinimumURLsBuilder); ok {
-		minimumURLs = bb.MinimumURLs()
+// Script renders the build script of the builder.
+//
+// It looks up the builder images and, in online mode, the kernel headers: both within the resolve timeout of the build.
+func Script(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	ctx, cancel := WithTimeout(ctx, c.ResolveTimeout)
+	defer cancel()
+	if !c.HostToolchain {
+		// The gcc version is chosen among the ones of the builder images
+		if err := c.LoadImages(ctx); err != nil {
+			return "", err
+		}
 	}
 
-	var urls []string
-	if IsOnlineMode() {
+	if c.Build.OnlineMode {
+		var urls []string
+		var err error
+		c.Emit(Event{Type: EventResolvingHeaders})
 		if c.KernelUrls == nil {
-			urls, err = b.URLs(c, kr)
+			urls, err = b.URLs(ctx, c, kr)
 			if err != nil {
 				return "", err
 			}
@@ -90,20 +133,37 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 			// Otherwise, it is up to the builder to return an error
 			if len(urls) > 0 {
 				// Check (and filter) existing kernels before continuing
-				urls, err = getResolvingURLs(urls)
+				urls, err = getResolvingURLs(ctx, urls)
 			}
 		} else {
-			urls, err = getResolvingURLs(c.KernelUrls)
+			urls, err = getResolvingURLs(ctx, c.KernelUrls)
 		}
 		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", fmt.Errorf("kernel headers lookup interrupted: %w", ctxErr)
+			}
 			return "", err
 		}
 
-		if len(urls) < minimumURLs {
-			return "", fmt.Errorf("not enough headers packages found; expected %d, found %d", minimumURLs, len(urls))
+		if len(urls) < minimumURLs(b) {
+			return "", fmt.Errorf("not enough headers packages found; expected %d, found %d", minimumURLs(b), len(urls))
 		}
-	} else {
-		urls = make([]string, minimumURLs)
+		c.ResolvedKernelURLs = urls
+	}
+	return RenderScript(b, c, kr)
+}
+
+// RenderScript renders the build script of the builder with the kernel headers Script already resolved,
+// e.g. again once the work directory of the build is known.
+func RenderScript(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	parsed, err := parseScript(b)
+	if err != nil {
+		return "", err
+	}
+
+	urls := c.ResolvedKernelURLs
+	if !c.Build.OnlineMode {
+		urls = make([]string, minimumURLs(b))
 	}
 
 	td := b.TemplateData(c, kr, urls)
@@ -119,6 +179,34 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 	return buf.String(), nil
 }
 
+// minimumURLs returns how many kernel headers packages the builder needs.
+func minimumURLs(b Builder) int {
+	if bb, ok := b.(MinimumURLsBuilder); ok {
+		return bb.MinimumURLs()
+	}
+	return 1
+}
+
+// parseScript parses the template script of the builder, along with the partials shared by every builder.
+// The templates quote the values they interpolate with shquote.
+func parseScript(b Builder) (*template.Template, error) {
+	t, err := template.New(b.Name()).Funcs(template.FuncMap{"shquote": shellQuote}).Parse(partialsTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return t.Parse(b.TemplateScript())
+}
+
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s as a single shell word, leaving it as is when no character of it is special to the shell.
+func shellQuote(s string) string {
+	if shellSafeRegex.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
 type GCCVersionRequestor interface {
 	// GCCVersion returns the GCC version to be used.
 	// If the returned value is empty, the default algorithm will be enforced.
@@ -146,21 +234,19 @@ func defaultGCC(kr kernelrelease.KernelRelease) semver.Version {
 	}
 }
 
-func mustParseTolerant(gccStr string) semver.Version {
-	g, err := semver.ParseTolerant(gccStr)
-	if err != nil {
-		panic(err)
-	}
-	return g
-}
-
 // Algorithm.
-// * always load images (note that it loads only images that provide gccversion, if set by user)
+// * images are already loaded by Script (note that it loads only images that provide gccversion, if set by user)
 // * if user set a fixed gccversion, we are good to go
 // * otherwise, try to fix the best-match gcc version provided by any of the loaded images;
 // see below for algorithm explanation
 func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
-	b.LoadImages()
+	if b.HostToolchain {
+		// No builder image is involved, the host compiler is the only one available
+		if len(b.GCCVersion) == 0 {
+			b.GCCVersion = hostGCCVersion()
+		}
+		return
+	}
 
 	if len(b.GCCVersion) > 0 {
 		// If set from user, go on
@@ -220,13 +306,14 @@ func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
 		Debug("foundGCC=", b.GCCVersion)
 }
 
-func (b *Build) GetBuilderImage() string {
+// GetBuilderImage returns the builder image, either the requested one or one among the loaded images.
+func (b *Build) GetBuilderImage() (string, error) {
 	imageTag := "latest"
 	if len(b.BuilderImage) > 0 {
 		customNames := strings.Split(b.BuilderImage, ":")
 		if customNames[0] != "auto" {
 			// BuilderImage MUST have requested GCC installed inside
-			return b.BuilderImage
+			return b.BuilderImage, nil
 		}
 
 		// Updated image tag if "auto:tag" is passed
@@ -237,12 +324,18 @@ func (b *Build) GetBuilderImage() string {
 		}
 	}
 
-	// NOTE: here below we are already sure that we are going
-	// to find an image, because setGCCVersion()
+	// NOTE: here below we are expected to find an image, because setGCCVersion()
 	// has already set an existent gcc version
-	// (ie: one provided by an image) for us
-	image, _ := b.Images.findImage(b.TargetType, mustParseTolerant(b.GCCVersion))
-	return image.Name + ":" + imageTag
+	// (ie: one provided by an image) for us, unless the user set it
+	gccVersion, err := semver.ParseTolerant(b.GCCVersion)
+	if err != nil {
+		return "", fmt.Errorf("invalid gcc version %q: %w", b.GCCVersion, err)
+	}
+	image, ok := b.Images.findImage(b.TargetType, gccVersion)
+	if !ok {
+		return "", fmt.Errorf("%w for target %s and gcc %s", BuilderImageNotFoundErr, b.TargetType, b.GCCVersion)
+	}
+	return image.Name + ":" + imageTag, nil
 }
 
 // Factory returns a builder for the given target.
@@ -257,52 +350,119 @@ func Factory(target Type) (Builder, error) {
 func (c Config) toTemplateData(b Builder, kr kernelrelease.KernelRelease) commonTemplateData {
 	c.setGCCVersion(b, kr)
 	return commonTemplateData{
-		DriverBuildDir:    DriverDirectory,
-		ModuleDownloadURL: fmt.Sprintf("%s/%s", c.DownloadBaseURL, c.ModuleFilePath),
-		ModuleDriverName:  c.DriverName,
-		ModuleFullPath:    ModuleFullPath,
-		BuildModule:       len(c.ModuleOutPutFilePath) > 0,
-		BuildProbe:        len(c.ProbeFilePath) > 0,
-		GCCVersion:        c.GCCVersion,
+		WorkDir:          c.workDir(),
+		SupportDir:       c.SupportDir(),
+		DriverBuildDir:   c.DriverDir(),
+		ModuleDriverName: c.DriverName,
+		ModuleFullPath:   c.ModulePath(),
+		BuildModule:      len(c.ModuleOutPutFilePath) > 0,
+		BuildProbe:       len(c.ProbeFilePath) > 0,
+		GCCVersion:       c.GCCVersion,
+		GCCPath:          c.gccPath(),
+
+		KernelHeadersCacheDir: c.kernelHeadersCacheDir(),
+	}
+}
+
+// gccPath returns the compiler the build script must use.
+// Builder images ship every gcc as /usr/bin/gcc-<version>, while on the host
+// the versioned binary may not exist and the default gcc is used instead.
+func (c Config) gccPath() string {
+	p := "/usr/bin/gcc-" + c.GCCVersion
+	if c.HostToolchain {
+		if _, err := os.Stat(p); err != nil {
+			return "gcc"
+		}
+	}
+	return p
+}
+
+// hostGCCVersion returns the version of the gcc found on the host.
+func hostGCCVersion() string {
+	out, err := exec.Command("gcc", "-dumpversion").Output()
+	if err != nil {
+		logger.WithError(err).Warn("could not detect the host gcc version")
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// kernelHeadersCacheDir returns the directory, below the mounted kernel headers cache,
+// that holds the prepared kernel tree for the current target, kernel release and architecture.
+// The kernel config and the kernel header packages are part of the key too,
+// since the config affects how some targets prepare the tree, and the packages may be given by the user.
+func (c Config) kernelHeadersCacheDir() string {
+	if len(c.KernelHeadersCache) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "config=%s\n", c.KernelConfigData)
+	for _, u := range c.ResolvedKernelURLs {
+		fmt.Fprintf(h, "url=%s\n", u)
 	}
+	key := fmt.Sprintf("%s_%s_%s_%s", c.TargetType, c.KernelRelease, c.Architecture, hex.EncodeToString(h.Sum(nil))[:12])
+	return path.Join(KernelHeadersCacheMountPath, key)
 }
 
-func resolveURLReference(u string) string {
+func resolveURLReference(u string) (string, error) {
 	uu, err := url.Parse(u)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	base, err := url.Parse(uu.Host)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return base.ResolveReference(uu).String()
+	return base.ResolveReference(uu).String(), nil
 }
 
-func getResolvingURLs(urls []string) ([]string, error) {
-	if !IsOnlineMode() {
-		return urls, nil
-	}
-
+// getResolvingURLs filters the urls that can be reached, it is meant for the online mode.
+// It fails with the error of ctx once ctx is done, rather than returning partial results.
+func getResolvingURLs(ctx context.Context, urls []string) ([]string, error) {
 	var results []string
 	for _, u := range urls {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		// in case url has some relative paths
 		// (kernel-crawler does not resolve them for us,
 		// neither it is expected, because they are effectively valid urls),
 		// resolve the absolute one.
 		// HEAD would fail otherwise.
-		u = resolveURLReference(u)
-		res, err := http.Head(u)
+		u, err := resolveURLReference(u)
+		if err != nil {
+			logger.WithError(err).Debug("skipping invalid kernel header url")
+			continue
+		}
+		res, err := httpDo(ctx, http.MethodHead, u)
 		if err != nil {
 			continue
 		}
+		res.Body.Close()
 		if res.StatusCode == http.StatusOK {
 			results = append(results, u)
 			logger.WithField("url", u).Debug("kernel header url found")
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if len(results) == 0 {
 		return nil, HeadersNotFoundErr
 	}
 	return results, nil
 }
+
+// httpDo sends a request without body, canceled along with ctx.
+func httpDo(ctx context.Context, method string, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// httpGet is http.Get, canceled along with ctx.
+func httpGet(ctx context.Context, u string) (*http.Response, error) {
+	return httpDo(ctx, http.MethodGet, u)
+}
@@ -1,8 +1,17 @@
 package builder
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +99,181 @@ func TestDefaultGCC(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkDirRelocation(t *testing.T) {
+	b := &Build{}
+	if b.ModulePath() != ModuleFullPath || b.ProbePath() != ProbeFullPath {
+		t.Fatalf("default paths must not change: %s, %s", b.ModulePath(), b.ProbePath())
+	}
+
+	b.WorkDir = "/work"
+	if got := b.DriverDir(); got != "/work/driver" {
+		t.Errorf("unexpected driver dir: %s", got)
+	}
+	if got := b.ModulePath(); got != "/work/driver/"+ModuleFileName {
+		t.Errorf("unexpected module path: %s", got)
+	}
+	if got := b.ProbePath(); got != "/work/driver/bpf/"+ProbeFileName {
+		t.Errorf("unexpected probe path: %s", got)
+	}
+}
+
+func TestStageMarkers(t *testing.T) {
+	stages := []Stage{StageModuleExtraction, StageHeaderFetch, StageKernelPrepare, StageModuleBuild, StageProbeBuild}
+	for target, b := range BuilderByTarget {
+		script := b.TemplateScript()
+		for _, stage := range stages {
+			if !strings.Contains(script, "echo \""+StageMarker+string(stage)+"\"\n") {
+				t.Errorf("%s: missing marker of stage %s", target, stage)
+			}
+		}
+	}
+
+	if stage, ok := ParseStageMarker("driverkit-stage: module-build"); !ok || stage != StageModuleBuild {
+		t.Errorf("unexpected stage: %s", stage)
+	}
+	if _, ok := ParseStageMarker("+ echo 'driverkit-stage: module-build'"); ok {
+		t.Error("the shell trace of the marker must not count")
+	}
+}
+
+// hostDirRegex matches the system directories the kernel headers packages would be installed into
+var hostDirRegex = regexp.MustCompile(`(?m)(^|\s)/(usr|lib)(/src)?/?(\s|$)`)
+
+func TestScriptTemplates(t *testing.T) {
+	for target, b := range BuilderByTarget {
+		if f, ok := b.(*flatcar); ok && f.info == nil {
+			// Do not look the flatcar release up
+			f.info = &flatcarReleaseInfo{GCCVersion: semver.Version{Major: 8}}
+			defer func() { f.info = nil }()
+		}
+		build := &Build{
+			TargetType:           target,
+			KernelRelease:        "5.15.0-1019-aws",
+			KernelVersion:        "20",
+			Architecture:         "amd64",
+			GCCVersion:           "8",
+			ModuleOutPutFilePath: "/out/falco.ko",
+			ProbeFilePath:        "/out/probe.o",
+			KernelHeadersCache:   "/cache",
+			WorkDir:              "/work",
+			ResolvedKernelURLs:   []string{"https://example.com/headers_all.deb", "https://example.com/headers_amd64.deb"},
+		}
+		kr, err := build.KernelReleaseFromBuildConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		td := b.TemplateData(build.ToConfig(), kr, build.ResolvedKernelURLs)
+		if err, ok := td.(error); ok {
+			t.Fatalf("%s: %v", target, err)
+		}
+		parsed, err := parseScript(b)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		var script bytes.Buffer
+		if err := parsed.Execute(&script, td); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+
+		// The shared partials render the same headers cache handling into every script
+		if !strings.Contains(script.String(), "cp -a $headersdir $cachetmp/tree\n") {
+			t.Errorf("%s: the kernel headers are not saved into the cache", target)
+		}
+		// The module sources and the support files are read from the work directory only
+		if !strings.Contains(script.String(), "tar -xzf /work/kernel-module.tar.gz ") {
+			t.Errorf("%s: the module sources are not extracted from the work directory", target)
+		}
+		if strings.Contains(script.String(), " /driverkit/") {
+			t.Errorf("%s: support files are read from outside the work directory", target)
+		}
+		if hostDirRegex.MatchString(script.String()) {
+			t.Errorf("%s: the kernel headers are installed outside the work directory", target)
+		}
+		cmd := exec.Command("bash", "-n")
+		cmd.Stdin = &script
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: invalid script: %v: %s", target, err, out)
+		}
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	build := &Build{
+		TargetType:           TargetTypeCentos,
+		KernelRelease:        "5.14.0-70.el9.x86_64",
+		KernelVersion:        "1",
+		Architecture:         "amd64",
+		GCCVersion:           "11",
+		ModuleOutPutFilePath: "/out/falco.ko",
+		OnlineMode:           true,
+		WorkDir:              "/tmp/driverkit-build.abc123",
+		// As resolved by Script, which is not looked up again
+		ResolvedKernelURLs: []string{"https://example.com/kernel-devel.rpm"},
+	}
+	kr, err := build.KernelReleaseFromBuildConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := RenderScript(BuilderByTarget[TargetTypeCentos], build.ToConfig(), kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"curl --silent -o kernel.rpm -SL https://example.com/kernel-devel.rpm\n",
+		"tar -xzf /tmp/driverkit-build.abc123/kernel-module.tar.gz ",
+		"mv *.ko /tmp/driverkit-build.abc123/driver/module.ko\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q", want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("/tmp/driver"); got != "/tmp/driver" {
+		t.Errorf("safe words must be left as is, got %s", got)
+	}
+	for _, s := range []string{"", "linux-headers-*amd64", "http://x; touch /tmp/pwned", "$(id)", "it's `id`", "a\nb"} {
+		out, err := exec.Command("bash", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("%q was quoted as %s, the shell read %q", s, shellQuote(s), out)
+		}
+	}
+}
+
+func TestKernelHeadersCacheDir(t *testing.T) {
+	b := &Build{TargetType: "ubuntu", KernelRelease: "5.15.0-1019-aws", Architecture: "amd64", KernelHeadersCache: "/cache"}
+	dir := b.ToConfig().kernelHeadersCacheDir()
+	if !strings.HasPrefix(dir, KernelHeadersCacheMountPath+"/ubuntu_5.15.0-1019-aws_amd64_") {
+		t.Fatalf("unexpected cache dir: %s", dir)
+	}
+	// Other header packages, as given by --kernelurls, get other headers
+	b.ResolvedKernelURLs = []string{"https://example.com/headers.deb"}
+	if b.ToConfig().kernelHeadersCacheDir() == dir {
+		t.Error("the kernel header packages are not part of the cache key")
+	}
+}
+
+func TestGetResolvingURLsCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// resolveURLReference does not handle an IP host along with a port
+	u := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/headers.deb"
+
+	urls, err := getResolvingURLs(context.Background(), []string{u})
+	if err != nil || len(urls) != 1 {
+		t.Fatalf("expected the url to resolve, got %v, %v", urls, err)
+	}
+
+	// A canceled lookup is not reported as headers not found
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = getResolvingURLs(ctx, []string{u})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
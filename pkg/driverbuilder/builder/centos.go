@@ -2,6 +2,7 @@ package builder
 
 import (
 	_ "embed"
+	"context"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
@@ -34,7 +35,7 @@ func (c *centos) TemplateScript() string {
 	return centosTemplate
 }
 
-func (c *centos) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (c *centos) URLs(_ context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 	vaultReleases := []string{
 		"6.0/os",
 		"6.0/updates",
@@ -1,12 +1,12 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"errors"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io/ioutil"
-	"net/http"
 	"regexp"
 	"strings"
 )
@@ -50,8 +50,8 @@ func (v *debian) TemplateScript() string {
 	return debianTemplate
 }
 
-func (v *debian) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return fetchDebianKernelURLs(kr)
+func (v *debian) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return fetchDebianKernelURLs(ctx, kr)
 }
 
 func (v *debian) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []string) interface{} {
@@ -74,13 +74,13 @@ func (v *debian) MinimumURLs() int {
 	return debianRequiredURLs
 }
 
-func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
-	kbuildURL, err := debianKbuildURLFromRelease(kr)
+func fetchDebianKernelURLs(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
+	kbuildURL, err := debianKbuildURLFromRelease(ctx, kr)
 	if err != nil {
 		return nil, err
 	}
 
-	urls, err := debianHeadersURLFromRelease(kr)
+	urls, err := debianHeadersURLFromRelease(ctx, kr)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +89,7 @@ func fetchDebianKernelURLs(kr kernelrelease.KernelRelease) ([]string, error) {
 	return urls, nil
 }
 
-func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, error) {
+func debianHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease) ([]string, error) {
 	baseURLS := []string{
 		"http://security-cdn.debian.org/pool/main/l/linux/",
 		"http://security-cdn.debian.org/pool/updates/main/l/linux/",
@@ -97,7 +97,7 @@ func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, erro
 	}
 
 	for _, u := range baseURLS {
-		urls, err := fetchDebianHeadersURLFromRelease(u, kr)
+		urls, err := fetchDebianHeadersURLFromRelease(ctx, u, kr)
 
 		if err == nil {
 			return urls, err
@@ -107,7 +107,7 @@ func debianHeadersURLFromRelease(kr kernelrelease.KernelRelease) ([]string, erro
 	return nil, HeadersNotFoundErr
 }
 
-func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
+func fetchDebianHeadersURLFromRelease(ctx context.Context, baseURL string, kr kernelrelease.KernelRelease) ([]string, error) {
 	extraVersionPartial := strings.TrimSuffix(kr.FullExtraversion, "-"+kr.Architecture.String())
 	matchExtraGroup := kr.Architecture.String()
 	rmatch := `href="(linux-headers-%d\.%d\.%d%s-(%s)_.*(%s|all)\.deb)"`
@@ -125,7 +125,7 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 	}
 
 	// download index
-	resp, err := http.Get(baseURL)
+	resp, err := httpGet(ctx, baseURL)
 	if err != nil {
 		return nil, err
 	}
@@ -172,7 +172,7 @@ func fetchDebianHeadersURLFromRelease(baseURL string, kr kernelrelease.KernelRel
 	return foundURLs, nil
 }
 
-func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error) {
+func debianKbuildURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease) (string, error) {
 	rmatch := `href="(linux-kbuild-%d\.%d.*%s\.deb)"`
 
 	kbuildPattern := regexp.MustCompile(fmt.Sprintf(rmatch, kr.Major, kr.Minor, kr.Architecture.String()))
@@ -181,7 +181,7 @@ func debianKbuildURLFromRelease(kr kernelrelease.KernelRelease) (string, error)
 		baseURL = "http://mirrors.kernel.org/debian/pool/main/l/linux-tools/"
 	}
 
-	resp, err := http.Get(baseURL)
+	resp, err := httpGet(ctx, baseURL)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of progress a build reports.
+type EventType string
+
+const (
+	EventStarted          EventType = "started"
+	EventResolvingHeaders EventType = "resolving-headers"
+	EventPullingImage     EventType = "pulling-image"
+	EventContainerStarted EventType = "container-started"
+	// EventStage is reported when the build script enters a new Stage
+	EventStage           EventType = "stage"
+	EventCopyingArtifact EventType = "copying-artifact"
+	EventDone            EventType = "done"
+	EventFailed          EventType = "failed"
+)
+
+// Event is the progress of a build.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Elapsed is the time since the build started, in nanoseconds once encoded
+	Elapsed       time.Duration `json:"elapsed"`
+	Target        string        `json:"target"`
+	KernelRelease string        `json:"kernelRelease"`
+	Architecture  string        `json:"architecture"`
+	Stage         Stage         `json:"stage,omitempty"`
+	Image         string        `json:"image,omitempty"`
+	// Artifact is the output path of the artifact being copied
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// EventHandler observes the progress of builds.
+//
+// Builds may run concurrently, so HandleEvent must be safe to call from multiple goroutines.
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// EventHandlerFunc adapts a function to an EventHandler.
+type EventHandlerFunc func(e Event)
+
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// JSONEventWriter writes the events as line-delimited JSON.
+type JSONEventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONEventWriter returns an EventHandler writing one JSON object per event into w.
+func NewJSONEventWriter(w io.Writer) *JSONEventWriter {
+	return &JSONEventWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *JSONEventWriter) HandleEvent(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(e)
+}
+
+// Emit reports e to the event handler of the build, if any, filling in the build and timing fields.
+func (b *Build) Emit(e Event) {
+	if b.Events == nil {
+		return
+	}
+	e.Time = time.Now()
+	if e.Type == EventStarted {
+		b.startedAt = e.Time
+	}
+	if !b.startedAt.IsZero() {
+		e.Elapsed = e.Time.Sub(b.startedAt)
+	}
+	e.Target = b.TargetType.String()
+	e.KernelRelease = b.KernelRelease
+	e.Architecture = b.Architecture
+	b.Events.HandleEvent(e)
+}
@@ -1,13 +1,13 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"errors"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io/ioutil"
-	"net/http"
 	"strings"
 )
 
@@ -43,8 +43,8 @@ func (f *flatcar) TemplateScript() string {
 	return flatcarTemplate
 }
 
-func (f *flatcar) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	if err := f.fillFlatcarInfos(kr); err != nil {
+func (f *flatcar) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	if err := f.fillFlatcarInfos(ctx, kr); err != nil {
 		return nil, err
 	}
 	return fetchFlatcarKernelURLS(f.info.KernelVersion), nil
@@ -54,7 +54,7 @@ func (f *flatcar) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []
 	// This happens when `kernelurls` option is passed,
 	// therefore URLs() method is not called.
 	if f.info == nil {
-		if err := f.fillFlatcarInfos(kr); err != nil {
+		if err := f.fillFlatcarInfos(context.Background(), kr); err != nil {
 			return err
 		}
 	}
@@ -69,7 +69,15 @@ func (f *flatcar) GCCVersion(_ kernelrelease.KernelRelease) semver.Version {
 	return f.info.GCCVersion
 }
 
-func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
+// The requested kernel release is the flatcar version, the module is built for its kernel
+func (f *flatcar) VermagicKernelRelease(_ kernelrelease.KernelRelease) string {
+	if f.info == nil {
+		return ""
+	}
+	return f.info.KernelVersion + "-flatcar"
+}
+
+func (f *flatcar) fillFlatcarInfos(ctx context.Context, kr kernelrelease.KernelRelease) error {
 	if kr.Extraversion != "" {
 		return fmt.Errorf("unexpected extraversion: %s", kr.Extraversion)
 	}
@@ -80,7 +88,7 @@ func (f *flatcar) fillFlatcarInfos(kr kernelrelease.KernelRelease) error {
 	}
 
 	var err error
-	f.info, err = fetchFlatcarMetadata(kr)
+	f.info, err = fetchFlatcarMetadata(ctx, kr)
 	return err
 }
 
@@ -89,16 +97,16 @@ func fetchFlatcarKernelURLS(kernelVersion string) []string {
 	return []string{fetchVanillaKernelURLFromKernelVersion(kv)}
 }
 
-func fetchFlatcarMetadata(kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
+func fetchFlatcarMetadata(ctx context.Context, kr kernelrelease.KernelRelease) (*flatcarReleaseInfo, error) {
 	flatcarInfo := flatcarReleaseInfo{}
 	flatcarVersion := kr.Fullversion
-	packageIndexUrl, err := getResolvingURLs(fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
+	packageIndexUrl, err := getResolvingURLs(ctx, fetchFlatcarPackageListURL(kr.Architecture, flatcarVersion))
 	if err != nil {
 		return nil, err
 	}
 	// first part of the URL is the channel
 	flatcarInfo.Channel = strings.Split(packageIndexUrl[0], ".")[0][len("https://"):]
-	resp, err := http.Get(packageIndexUrl[0])
+	resp, err := httpGet(ctx, packageIndexUrl[0])
 	if err != nil {
 		return nil, err
 	}
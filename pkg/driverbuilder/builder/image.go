@@ -8,7 +8,6 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	logger "github.com/sirupsen/logrus"
-	"log"
 	"regexp"
 	"strings"
 )
@@ -45,10 +44,24 @@ func (im ImagesMap) findImage(target Type, gccVers semver.Version) (Image, bool)
 	return Image{}, false
 }
 
-func (b *Build) LoadImages() {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+// NewDockerClient returns a client for the docker API.
+// The daemon is configured from the environment, unless host is not empty.
+// Since the API is also served by podman, host may as well point to a podman socket.
+func NewDockerClient(host string) (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv}
+	if len(host) > 0 {
+		opts = append(opts, client.WithHost(host), client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// LoadImages looks up the builder images into the builder repos, or locally when not in online mode.
+//
+// It returns BuilderImageNotFoundErr when none is found.
+func (b *Build) LoadImages(ctx context.Context) error {
+	cli, err := NewDockerClient(b.DockerHost)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Create the proper regexes to load "any" and target-specific images for requested arch
@@ -62,8 +75,8 @@ func (b *Build) LoadImages() {
 	b.Images = make(ImagesMap)
 	var imgNames []string
 	for _, repo := range b.BuilderRepos {
-		if IsOnlineMode() {
-			imgs, err := cli.ImageSearch(context.Background(), repo, types.ImageSearchOptions{Limit: 100})
+		if b.OnlineMode {
+			imgs, err := cli.ImageSearch(ctx, repo, types.ImageSearchOptions{Limit: 100})
 			if err != nil {
 				logger.Warnf("image search error from repo %s: %s\n", repo, err.Error())
 				continue
@@ -72,7 +85,7 @@ func (b *Build) LoadImages() {
 				imgNames = append(imgNames, imgs[i].Name)
 			}
 		} else {
-			imgs, err := cli.ImageList(context.Background(), types.ImageListOptions{All: true})
+			imgs, err := cli.ImageList(ctx, types.ImageListOptions{All: true})
 			if err != nil {
 				logger.Warnf("list local image error: %s\n", err.Error())
 				continue
@@ -118,8 +131,13 @@ func (b *Build) LoadImages() {
 					if b.GCCVersion != "" && b.GCCVersion != gccVer {
 						continue
 					}
+					gccVersion, err := semver.ParseTolerant(gccVer)
+					if err != nil {
+						logger.Debug("Malformed image gcc version: ", imgName, gccVer)
+						continue
+					}
 					buildImage := Image{
-						GCCVersion: mustParseTolerant(gccVer),
+						GCCVersion: gccVersion,
 						Name:       imgName,
 					}
 					if regIdx == 0 {
@@ -136,6 +154,10 @@ func (b *Build) LoadImages() {
 		}
 	}
 	if len(b.Images) == 0 {
-		log.Fatal("Could not load any builder image. Leaving.")
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("builder images lookup interrupted: %w", err)
+		}
+		return fmt.Errorf("%w: could not load any builder image for target %s", BuilderImageNotFoundErr, b.TargetType)
 	}
+	return nil
 }
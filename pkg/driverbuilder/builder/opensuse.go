@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"errors"
 	"fmt"
@@ -76,7 +77,7 @@ func (o *opensuse) TemplateScript() string {
 	return opensuseTemplate
 }
 
-func (o *opensuse) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, error) {
+func (o *opensuse) URLs(ctx context.Context, _ Config, kr kernelrelease.KernelRelease) ([]string, error) {
 
 	// SUSE requires 2 urls: a kernel-default-devel*{arch}.rpm and a kernel-devel*noarch.rpm
 	kernelDefaultDevelPattern := fmt.Sprintf("kernel-default-devel-%s%s.rpm", kr.Fullversion, kr.FullExtraversion)
@@ -90,7 +91,7 @@ func (o *opensuse) URLs(_ Config, kr kernelrelease.KernelRelease) ([]string, err
 	possibleURLs := buildURLs(kr, kernelDefaultDevelPattern, kernelDevelNoArchPattern)
 
 	// trim the list to only resolving URLs
-	urls, err := getResolvingURLs(possibleURLs)
+	urls, err := getResolvingURLs(ctx, possibleURLs)
 	if err != nil {
 		return nil, err
 	}
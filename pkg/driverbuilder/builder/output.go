@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// DefaultDriverName is the driver name of the output names, when no driver name is given.
+const DefaultDriverName = "falco"
+
+// DefaultOutputNamePreset is the preset naming the outputs into an output directory.
+const DefaultOutputNamePreset = "falco"
+
+// OutputNamePresets are the built-in output name templates.
+var OutputNamePresets = map[string]string{
+	// the naming of the drivers Falco looks for
+	"falco": "{{ .DriverName }}_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}",
+	// the Falco naming, with a directory per architecture, as into the Falco drivers repository
+	"falco-repo": "{{ .Arch }}/{{ .DriverName }}_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}",
+	// the Falco naming, along with the architecture
+	"full": "{{ .DriverName }}_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}_{{ .Arch }}",
+}
+
+// OutputNameData are the fields available to the output name templates.
+type OutputNameData struct {
+	Target        string
+	KernelRelease string
+	KernelVersion string
+	// Arch is the kernel architecture name, eg. x86_64
+	Arch string
+	// Architecture is the driverkit architecture name, eg. amd64
+	Architecture string
+	DriverName   string
+}
+
+// IsOutputTemplate tells whether the output path or name contains template fields.
+func IsOutputTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// OutputNameData returns the fields of the output name templates for the build.
+func (b *Build) OutputNameData() OutputNameData {
+	driverName := b.ModuleDriverName
+	if len(driverName) == 0 {
+		driverName = DefaultDriverName
+	}
+	return OutputNameData{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		KernelVersion: b.KernelVersion,
+		Arch:          kernelrelease.Architecture(b.Architecture).ToNonDeb(),
+		Architecture:  b.Architecture,
+		DriverName:    driverName,
+	}
+}
+
+// RenderOutputPath fills the template fields of an output path.
+func (b *Build) RenderOutputPath(p string) (string, error) {
+	if !IsOutputTemplate(p) {
+		return p, nil
+	}
+	t, err := template.New("output").Option("missingkey=error").Parse(p)
+	if err != nil {
+		return "", fmt.Errorf("invalid output template %q: %v", p, err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, b.OutputNameData()); err != nil {
+		return "", fmt.Errorf("invalid output template %q: %v", p, err)
+	}
+	return buf.String(), nil
+}
+
+// OutputNameTemplate returns the template of the given preset, or name itself when it is not a preset.
+// The presets are looked up into the custom ones first, and then into the built-in ones.
+func OutputNameTemplate(name string, custom map[string]string) (string, error) {
+	if len(name) == 0 {
+		name = DefaultOutputNamePreset
+	}
+	if t, ok := custom[name]; ok {
+		return t, nil
+	}
+	if t, ok := OutputNamePresets[name]; ok {
+		return t, nil
+	}
+	if !IsOutputTemplate(name) {
+		presets := make([]string, 0, len(OutputNamePresets)+len(custom))
+		for p := range OutputNamePresets {
+			presets = append(presets, p)
+		}
+		for p := range custom {
+			presets = append(presets, p)
+		}
+		sort.Strings(presets)
+		return "", fmt.Errorf("unknown output name preset %q, expected one of %v or a template", name, presets)
+	}
+	return name, nil
+}
+
+// SetOutputDir lays out both the module and the probe into dir, named after the name template,
+// with the .ko and .o extensions respectively.
+// Names that are absolute or that leave dir are rejected.
+func (b *Build) SetOutputDir(dir string, nameTemplate string) error {
+	name, err := b.RenderOutputPath(nameTemplate)
+	if err != nil {
+		return err
+	}
+	if clean := filepath.Clean(name); filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid output name %q: it must stay into the output directory", name)
+	}
+	base := filepath.Join(dir, name)
+	b.ModuleOutPutFilePath = base + ".ko"
+	b.ProbeFilePath = base + ".o"
+	return nil
+}
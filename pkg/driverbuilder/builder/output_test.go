@@ -0,0 +1,60 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestOutputPaths(t *testing.T) {
+	b := &Build{
+		TargetType:    TargetTypeUbuntu,
+		KernelRelease: "4.15.0-20-generic",
+		KernelVersion: "21",
+		Architecture:  "amd64",
+	}
+
+	tests := []struct {
+		name   string
+		module string
+		probe  string
+	}{
+		{"falco", "/out/falco_ubuntu_4.15.0-20-generic_21.ko", "/out/falco_ubuntu_4.15.0-20-generic_21.o"},
+		{"falco-repo", "/out/x86_64/falco_ubuntu_4.15.0-20-generic_21.ko", "/out/x86_64/falco_ubuntu_4.15.0-20-generic_21.o"},
+		{"uhs_driver_{{ .Target }}_{{ .KernelRelease }}_{{ .KernelVersion }}_{{ .Arch }}", "/out/uhs_driver_ubuntu_4.15.0-20-generic_21_x86_64.ko", "/out/uhs_driver_ubuntu_4.15.0-20-generic_21_x86_64.o"},
+	}
+	for _, tt := range tests {
+		tmpl, err := OutputNameTemplate(tt.name, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := b.SetOutputDir("/out", tmpl); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.ModuleOutPutFilePath != tt.module || b.ProbeFilePath != tt.probe {
+			t.Errorf("%s: unexpected outputs %s, %s", tt.name, b.ModuleOutPutFilePath, b.ProbeFilePath)
+		}
+	}
+
+	b.ModuleDriverName = "custom"
+	tmpl, err := OutputNameTemplate("mine", map[string]string{"mine": "{{ .DriverName }}-{{ .Architecture }}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := b.RenderOutputPath("/out/" + tmpl + ".ko"); err != nil || p != "/out/custom-amd64.ko" {
+		t.Errorf("unexpected custom preset output %s: %v", p, err)
+	}
+	if p, err := b.RenderOutputPath("/out/plain.ko"); err != nil || p != "/out/plain.ko" {
+		t.Errorf("paths without templates must not change: %s, %v", p, err)
+	}
+
+	if _, err := OutputNameTemplate("unknown", nil); err == nil {
+		t.Error("unknown presets must be rejected")
+	}
+	if _, err := b.RenderOutputPath("/out/{{ .Unknown }}.ko"); err == nil {
+		t.Error("unknown fields must be rejected")
+	}
+	for _, name := range []string{"{{ .Target }}/../../../../etc/x", "/etc/{{ .Target }}", "{{ .Target }}/../.."} {
+		if err := b.SetOutputDir("/out", name); err == nil {
+			t.Errorf("%s: names leaving the output directory must be rejected", name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	_ "embed"
 	"errors"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
@@ -37,7 +38,7 @@ func (v *redhat) TemplateScript() string {
 	return redhatTemplate
 }
 
-func (v *redhat) URLs(_ Config, _ kernelrelease.KernelRelease) ([]string, error) {
+func (v *redhat) URLs(_ context.Context, _ Config, _ kernelrelease.KernelRelease) ([]string, error) {
 	return nil, nil
 }
 
@@ -0,0 +1,44 @@
+package builder
+
+import "strings"
+
+// StageMarker prefixes the lines the build scripts print when entering a new stage.
+const StageMarker = "driverkit-stage: "
+
+// Stage is a step of the build script.
+type Stage string
+
+const (
+	StageModuleExtraction Stage = "module-extraction"
+	StageHeaderFetch      Stage = "header-fetch"
+	StageKernelPrepare    Stage = "kernel-prepare"
+	StageModuleBuild      Stage = "module-build"
+	StageProbeBuild       Stage = "probe-build"
+)
+
+// ParseStageMarker returns the stage announced by a line of the build output, if any.
+// The shell trace of the marker echo does not count, since it is prefixed by '+'.
+func ParseStageMarker(line string) (Stage, bool) {
+	if !strings.HasPrefix(line, StageMarker) {
+		return "", false
+	}
+	stage := Stage(strings.TrimSpace(strings.TrimPrefix(line, StageMarker)))
+	return stage, len(stage) > 0
+}
+
+// HeaderMarker prefixes the lines the build scripts print after downloading a kernel headers package,
+// followed by the sha256 of the package and the URL it was downloaded from.
+const HeaderMarker = "driverkit-header: "
+
+// ParseHeaderMarker returns the sha256 and the URL of a downloaded kernel headers package
+// announced by a line of the build output, if any.
+func ParseHeaderMarker(line string) (sum string, url string, ok bool) {
+	if !strings.HasPrefix(line, HeaderMarker) {
+		return "", "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, HeaderMarker))
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
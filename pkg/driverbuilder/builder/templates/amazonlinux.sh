@@ -1,42 +1,55 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/driver/* {{ .DriverBuildDir | shquote }}
+
+cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
 {{ range $url := .KernelDownloadURLs }}
-curl --silent -o kernel.rpm -SL {{ $url }}
+curl --silent -o kernel.rpm -SL {{ $url | shquote }}
+driverkit_header kernel.rpm {{ $url | shquote }}
 rpm2cpio kernel.rpm | cpio --extract --make-directories
 rm -rf kernel.rpm
 {{ end }}
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv usr/src/kernels/*/* /tmp/kernel
+rm -Rf {{ .WorkDir | shquote }}/kernel
+mkdir -p {{ .WorkDir | shquote }}/kernel
+mv usr/src/kernels/*/* {{ .WorkDir | shquote }}/kernel
+
+{{ template "kernel-headers-fetched" . }}
+
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
 
 {{ if .BuildModule }}
 # Build the kernel module
-cd {{ .DriverBuildDir }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
 
-make KERNELDIR=/tmp/kernel CC=/usr/bin/gcc-{{ .GCCVersion }} LD=/usr/bin/ld.bfd CROSS_COMPILE=""
-mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
+make KERNELDIR={{ .WorkDir | shquote }}/kernel CC={{ .GCCPath | shquote }} LD=/usr/bin/ld.bfd CROSS_COMPILE=""
+mv {{ .ModuleDriverName | shquote }}.ko {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
+make KERNELDIR={{ .WorkDir | shquote }}/kernel
 ls -l probe.o
 {{ end }}
\ No newline at end of file
@@ -1,39 +1,52 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/driver/* {{ .DriverBuildDir | shquote }}
+
+cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
-curl --silent -o kernel-devel.pkg.tar.xz -SL {{ .KernelDownloadURL }}
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
+curl --silent -o kernel-devel.pkg.tar.xz -SL {{ .KernelDownloadURL | shquote }}
+driverkit_header kernel-devel.pkg.tar.xz {{ .KernelDownloadURL | shquote }}
 tar -xf kernel-devel.pkg.tar.xz
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv usr/lib/modules/*/build/* /tmp/kernel
+rm -Rf {{ .WorkDir | shquote }}/kernel
+mkdir -p {{ .WorkDir | shquote }}/kernel
+mv usr/lib/modules/*/build/* {{ .WorkDir | shquote }}/kernel
+
+{{ template "kernel-headers-fetched" . }}
+
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
 
 {{ if .BuildModule }}
 # Build the module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
-mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNELDIR={{ .WorkDir | shquote }}/kernel
+mv {{ .ModuleDriverName | shquote }}.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
+make KERNELDIR={{ .WorkDir | shquote }}/kernel
 ls -l probe.o
 {{ end }}
\ No newline at end of file
@@ -1,44 +1,57 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-tar -xzf kernel-module.tar.gz -C /tmp/module-download
-mv /tmp/module-download/*/* {{ .DriverBuildDir }}/
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-#cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-#bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/* {{ .DriverBuildDir | shquote }}/
+
+#cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+#bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
 if [[ "${MODE}" == "online" ]];then
-  curl --silent -o kernel.rpm -SL {{ .KernelDownloadURL }}
+  curl --silent -o kernel.rpm -SL {{ .KernelDownloadURL | shquote }}
+  driverkit_header kernel.rpm {{ .KernelDownloadURL | shquote }}
 else
-  mv /kernel0 kernel.rpm
+  mv {{ .WorkDir | shquote }}/kernel0 kernel.rpm
 fi
 
 rpm2cpio kernel.rpm | cpio --extract --make-directories
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv usr/src/kernels/*/* /tmp/kernel
+rm -Rf {{ .WorkDir | shquote }}/kernel
+mkdir -p {{ .WorkDir | shquote }}/kernel
+mv usr/src/kernels/*/* {{ .WorkDir | shquote }}/kernel
+
+{{ template "kernel-headers-fetched" . }}
+
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
 
 {{ if .BuildModule }}
 # Build the module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNEL_DIR=/tmp/kernel MODULE_DIR={{ .DriverBuildDir }}
-mv *.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNEL_DIR={{ .WorkDir | shquote }}/kernel MODULE_DIR={{ .DriverBuildDir | shquote }}
+mv *.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
+make KERNELDIR={{ .WorkDir | shquote }}/kernel
 ls -l probe.o
 {{ end }}
\ No newline at end of file
@@ -1,47 +1,57 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/driver/* {{ .DriverBuildDir | shquote }}
+
+cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel-download
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
 {{ range $url := .KernelDownloadURLS }}
-curl --silent -o kernel.deb -SL {{ $url }}
+curl --silent -o kernel.deb -SL {{ $url | shquote }}
+driverkit_header kernel.deb {{ $url | shquote }}
 ar x kernel.deb
 tar -xvf data.tar.xz
 {{ end }}
 
-cd /tmp/kernel-download/
-
-cp -r usr/* /usr
-cp -r lib/* /lib
+{{ template "kernel-headers-fetched" . }}
 
-cd /usr/src
-sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
+# The headers stay into the work directory: the absolute paths their Makefiles include are pointed there
+cd {{ .WorkDir | shquote }}/kernel-download/usr/src
+srcroot=$(printf '%s' "$PWD" | sed 's/[&|\\]/\\&/g')
+{ grep -rlZ --include=Makefile '/usr/src/' . || true; } | xargs -0 -r sed -i "s|/usr/src/|${srcroot}/|g"
+sourcedir=$(readlink -f "$(find . -type d -name {{ .KernelHeadersPattern | shquote }} | head -n 1)")
 
 {{ if .BuildModule }}
 # Build the module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir
-mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNELDIR=$sourcedir
+mv {{ .ModuleDriverName | shquote }}.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
 make KERNELDIR=$sourcedir
 ls -l probe.o
 {{ end }}
\ No newline at end of file
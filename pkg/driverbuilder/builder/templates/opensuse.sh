@@ -1,42 +1,54 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/driver/* {{ .DriverBuildDir | shquote }}
+
+cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel-download/usr/src
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
 {{range $url := .KernelDownloadURLs}}
-curl --silent -o kernel-devel.rpm -SL {{ $url }}
+curl --silent -o kernel-devel.rpm -SL {{ $url | shquote }}
+driverkit_header kernel-devel.rpm {{ $url | shquote }}
 # cpio will warn *extremely verbose* when trying to duplicate over the same directory - redirect stderr to null
 rpm2cpio kernel-devel.rpm | cpio --quiet --extract --make-directories 2> /dev/null
 {{end}}
-cd /tmp/kernel-download/usr/src
-ls -alh /tmp/kernel-download/usr/src
+{{ template "kernel-headers-fetched" . }}
+
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
+cd {{ .WorkDir | shquote }}/kernel-download/usr/src
+ls -alh {{ .WorkDir | shquote }}/kernel-download/usr/src
 sourcedir="$(find . -type d -name "linux-*-obj" | head -n 1 | xargs readlink -f)/*/default"
 
 {{ if .BuildModule }}
 # Build the module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=$sourcedir
-mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNELDIR=$sourcedir
+mv {{ .ModuleDriverName | shquote }}.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
+make KERNELDIR={{ .WorkDir | shquote }}/kernel
 ls -l probe.o
 {{ end }}
\ No newline at end of file
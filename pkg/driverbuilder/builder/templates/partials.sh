@@ -0,0 +1,39 @@
+{{/*
+Partials shared by the build scripts of every target, parsed along with each of them.
+
+The build scripts fetch the kernel headers into the $headersdir directory, between
+the "kernel-headers-fetch" and "kernel-headers-fetched" partials, and report each
+downloaded package with driverkit_header.
+*/}}
+
+{{ define "kernel-headers-fetch" -}}
+# driverkit_header reports the sha256 of the kernel headers package $1, downloaded from $2
+driverkit_header() {
+  echo "driverkit-header: $(sha256sum "$1" | cut -d' ' -f1) $2" | tee -a {{ .WorkDir | shquote }}/driverkit-headers
+}
+rm -f {{ .WorkDir | shquote }}/driverkit-headers
+touch {{ .WorkDir | shquote }}/driverkit-headers
+{{ if .KernelHeadersCacheDir -}}
+if [ -f {{ .KernelHeadersCacheDir | shquote }}/.driverkit-ready ]; then
+  # Reuse the kernel headers prepared by a previous build, reporting the packages they come from
+  rm -Rf $headersdir
+  mkdir -p $(dirname $headersdir)
+  cp -a {{ .KernelHeadersCacheDir | shquote }}/tree $headersdir
+  cat {{ .KernelHeadersCacheDir | shquote }}/headers
+else
+{{ end -}}
+{{ end }}
+
+{{ define "kernel-headers-fetched" -}}
+{{ if .KernelHeadersCacheDir }}
+  # Save the prepared kernel headers for the next builds, along with the packages they come from,
+  # into a private directory renamed into place at once, so that concurrent builds never see them partially saved;
+  # the copy of a build that saved them meanwhile is kept
+  cachetmp=$(mktemp -d {{ .KernelHeadersCacheDir | shquote }}.XXXXXX)
+  cp -a $headersdir $cachetmp/tree
+  cp {{ .WorkDir | shquote }}/driverkit-headers $cachetmp/headers
+  touch $cachetmp/.driverkit-ready
+  mv -T $cachetmp {{ .KernelHeadersCacheDir | shquote }} 2>/dev/null || rm -Rf $cachetmp
+fi
+{{ end -}}
+{{ end }}
@@ -1,52 +1,64 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-tar -xzf kernel-module.tar.gz -C /tmp/module-download
-mv /tmp/module-download/*/* {{ .DriverBuildDir }}/
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-#cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-#bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/* {{ .DriverBuildDir | shquote }}/
+
+#cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+#bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-mkdir /tmp/kernel-download
-cd /tmp/kernel-download
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel-download/usr/src
+{{ template "kernel-headers-fetch" . }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+cd {{ .WorkDir | shquote }}/kernel-download
 if [[ "${MODE}" == "online" ]];then
   {{range $url := .KernelDownloadURLS}}
-  curl --silent -o kernel.deb -SL {{ $url }}
+  curl --silent -o kernel.deb -SL {{ $url | shquote }}
+  driverkit_header kernel.deb {{ $url | shquote }}
   ar x kernel.deb
   tar -xf data.tar.*
   {{end}}
 else
-  mv /kernel0 kernel0.deb
-  mv /kernel1 kernel1.deb
+  mv {{ .WorkDir | shquote }}/kernel0 kernel0.deb
+  mv {{ .WorkDir | shquote }}/kernel1 kernel1.deb
   ar x kernel0.deb
   tar -xf data.tar.*
   ar x kernel1.deb
   tar -xf data.tar.*
 fi
 
-cd /tmp/kernel-download/usr/src/
+{{ template "kernel-headers-fetched" . }}
+
+# Prepare the kernel
+echo "driverkit-stage: kernel-prepare"
+cd {{ .WorkDir | shquote }}/kernel-download/usr/src/
 ls -altr
-sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
+sourcedir=$(find . -type d -name {{ .KernelHeadersPattern | shquote }} | head -n 1 | xargs readlink -f)
 
 {{ if .BuildModule }}
 # Build the module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNEL_DIR=$sourcedir MODULE_DIR={{ .DriverBuildDir }}
-mv *.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNEL_DIR=$sourcedir MODULE_DIR={{ .DriverBuildDir | shquote }}
+mv *.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
 make KERNELDIR=$sourcedir
 ls -l probe.o
 {{ end }}
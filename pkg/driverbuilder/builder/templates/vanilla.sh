@@ -1,50 +1,64 @@
 #!/bin/bash
 set -xeuo pipefail
 
-rm -Rf {{ .DriverBuildDir }}
-mkdir {{ .DriverBuildDir }}
-rm -Rf /tmp/module-download
-mkdir -p /tmp/module-download
+echo "driverkit-stage: module-extraction"
 
-curl --silent -SL {{ .ModuleDownloadURL }} | tar -xzf - -C /tmp/module-download
-mv /tmp/module-download/*/driver/* {{ .DriverBuildDir }}
+rm -Rf {{ .DriverBuildDir | shquote }}
+mkdir {{ .DriverBuildDir | shquote }}
+rm -Rf {{ .WorkDir | shquote }}/module-download
+mkdir -p {{ .WorkDir | shquote }}/module-download
 
-cp /driverkit/module-Makefile {{ .DriverBuildDir }}/Makefile
-bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
+tar -xzf {{ .WorkDir | shquote }}/kernel-module.tar.gz -C {{ .WorkDir | shquote }}/module-download
+mv {{ .WorkDir | shquote }}/module-download/*/driver/* {{ .DriverBuildDir | shquote }}
+
+cp {{ .SupportDir | shquote }}/module-Makefile {{ .DriverBuildDir | shquote }}/Makefile
+bash {{ .SupportDir | shquote }}/fill-driver-config.sh {{ .DriverBuildDir | shquote }}
 
 # Fetch the kernel
-cd /tmp
-mkdir /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
-rm -Rf /tmp/kernel
-mkdir -p /tmp/kernel
-mv /tmp/kernel-download/*/* /tmp/kernel
+echo "driverkit-stage: header-fetch"
+headersdir={{ .WorkDir | shquote }}/kernel
+{{ template "kernel-headers-fetch" . }}
+cd {{ .WorkDir | shquote }}
+mkdir {{ .WorkDir | shquote }}/kernel-download
+curl --silent -o {{ .WorkDir | shquote }}/kernel.tar.xz -SL {{ .KernelDownloadURL | shquote }}
+driverkit_header {{ .WorkDir | shquote }}/kernel.tar.xz {{ .KernelDownloadURL | shquote }}
+tar -Jxf {{ .WorkDir | shquote }}/kernel.tar.xz -C {{ .WorkDir | shquote }}/kernel-download
+rm {{ .WorkDir | shquote }}/kernel.tar.xz
+rm -Rf {{ .WorkDir | shquote }}/kernel
+mkdir -p {{ .WorkDir | shquote }}/kernel
+mv {{ .WorkDir | shquote }}/kernel-download/*/* {{ .WorkDir | shquote }}/kernel
 
 # Prepare the kernel
-cd /tmp/kernel
-cp /driverkit/kernel.config /tmp/kernel.config
+echo "driverkit-stage: kernel-prepare"
+cd {{ .WorkDir | shquote }}/kernel
+cp {{ .SupportDir | shquote }}/kernel.config {{ .WorkDir | shquote }}/kernel.config
 
 {{ if .KernelLocalVersion}}
-sed -i 's/^CONFIG_LOCALVERSION=.*$/CONFIG_LOCALVERSION="{{ .KernelLocalVersion }}"/' /tmp/kernel.config
+localversion={{ .KernelLocalVersion | shquote }}
+sed -i "s/^CONFIG_LOCALVERSION=.*\$/CONFIG_LOCALVERSION=\"${localversion}\"/" {{ .WorkDir | shquote }}/kernel.config
 {{ end }}
 
-make KCONFIG_CONFIG=/tmp/kernel.config oldconfig
-make KCONFIG_CONFIG=/tmp/kernel.config prepare
-make KCONFIG_CONFIG=/tmp/kernel.config modules_prepare
+make KCONFIG_CONFIG={{ .WorkDir | shquote }}/kernel.config oldconfig
+make KCONFIG_CONFIG={{ .WorkDir | shquote }}/kernel.config prepare
+make KCONFIG_CONFIG={{ .WorkDir | shquote }}/kernel.config modules_prepare
+
+{{ template "kernel-headers-fetched" . }}
 
 {{ if .BuildModule }}
 # Build the kernel module
-cd {{ .DriverBuildDir }}
-make CC=/usr/bin/gcc-{{ .GCCVersion }} KERNELDIR=/tmp/kernel
-mv {{ .ModuleDriverName }}.ko {{ .ModuleFullPath }}
-strip -g {{ .ModuleFullPath }}
+echo "driverkit-stage: module-build"
+cd {{ .DriverBuildDir | shquote }}
+make CC={{ .GCCPath | shquote }} KERNELDIR={{ .WorkDir | shquote }}/kernel
+mv {{ .ModuleDriverName | shquote }}.ko {{ .ModuleFullPath | shquote }}
+strip -g {{ .ModuleFullPath | shquote }}
 # Print results
-modinfo {{ .ModuleFullPath }}
+modinfo {{ .ModuleFullPath | shquote }}
 {{ end }}
 
 {{ if .BuildProbe }}
 # Build the eBPF probe
-cd {{ .DriverBuildDir }}/bpf
-make KERNELDIR=/tmp/kernel
+echo "driverkit-stage: probe-build"
+cd {{ .DriverBuildDir | shquote }}/bpf
+make KERNELDIR={{ .WorkDir | shquote }}/kernel
 ls -l probe.o
 {{ end }}
\ No newline at end of file
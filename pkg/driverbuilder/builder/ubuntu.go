@@ -2,6 +2,7 @@ package builder
 
 import (
 	_ "embed"
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -71,8 +72,8 @@ func (v *ubuntu) TemplateScript() string {
 	return ubuntuTemplate
 }
 
-func (v *ubuntu) URLs(c Config, kr kernelrelease.KernelRelease) ([]string, error) {
-	return ubuntuHeadersURLFromRelease(kr, c.Build.KernelVersion)
+func (v *ubuntu) URLs(ctx context.Context, c Config, kr kernelrelease.KernelRelease) ([]string, error) {
+	return ubuntuHeadersURLFromRelease(ctx, kr, c.Build.KernelVersion)
 }
 
 func (v *ubuntu) MinimumURLs() int {
@@ -102,7 +103,7 @@ func (v *ubuntu) TemplateData(c Config, kr kernelrelease.KernelRelease, urls []s
 	}
 }
 
-func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease, kv string) ([]string, error) {
+func ubuntuHeadersURLFromRelease(ctx context.Context, kr kernelrelease.KernelRelease, kv string) ([]string, error) {
 	// decide which mirrors to use based on the architecture passed in
 	baseURLs := []string{}
 	if kr.Architecture.String() == kernelrelease.ArchitectureAmd64 {
@@ -125,7 +126,10 @@ func ubuntuHeadersURLFromRelease(kr kernelrelease.KernelRelease, kv string) ([]s
 			return nil, err
 		}
 		// try resolving the URLs
-		urls, err := getResolvingURLs(possibleURLs)
+		urls, err := getResolvingURLs(ctx, possibleURLs)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		// there should be 2 urls returned - the _all.deb package and the _{arch}.deb package
 		if err == nil && len(urls) == ubuntuRequiredURLs {
 			return urls, err
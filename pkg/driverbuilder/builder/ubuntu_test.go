@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -255,7 +256,7 @@ func TestUbuntuHeadersURLFromRelease(t *testing.T) {
 		}
 
 		// call function
-		gotURLs, err := ubuntuHeadersURLFromRelease(input.config, input.kv)
+		gotURLs, err := ubuntuHeadersURLFromRelease(context.Background(), input.config, input.kv)
 		// compare errors
 		// there are no official errors, so comparing fmt.Errorf() doesn't really work
 		// compare error message text instead
@@ -0,0 +1,187 @@
+package driverbuilder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/diagnostics"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// buildLogTailLines is how many of the last lines of the build output are printed when a build fails.
+const buildLogTailLines = 50
+
+// buildLog collects the output of a build script.
+//
+// Every line is forwarded to the debug log, written with a timestamp to the log file (if any),
+// kept among the last lines, to be printed at error level when the build fails,
+// and parsed for compiler diagnostics, stage markers and the hashes of the downloaded kernel headers.
+type buildLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	tail   []string
+	next   int
+	parser *diagnostics.Parser
+	// stage is the last stage announced by the build script
+	stage builder.Stage
+	// headerSums are the sha256 of the kernel header packages downloaded by the build script, by url
+	headerSums map[string]string
+	// emit reports the progress of the build
+	emit func(e builder.Event)
+
+	diagnosticsPath   string
+	diagnosticsFormat string
+}
+
+// newBuildLog returns a buildLog for the build, persisting the output to its build log path,
+// or only keeping it in memory when that is empty.
+func newBuildLog(b *builder.Build) (*buildLog, error) {
+	l := &buildLog{
+		tail:              make([]string, 0, buildLogTailLines),
+		parser:            diagnostics.NewParser(),
+		headerSums:        make(map[string]string),
+		diagnosticsPath:   b.DiagnosticsPath,
+		diagnosticsFormat: b.DiagnosticsFormat,
+		emit:              b.Emit,
+	}
+	if len(b.BuildLogPath) > 0 {
+		f, err := os.Create(b.BuildLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the build log: %v", err)
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// forward reads the build output until EOF.
+func (l *buildLog) forward(logPipe io.Reader) {
+	lineReader := bufio.NewReader(logPipe)
+	for {
+		line, err := lineReader.ReadBytes('\n')
+		if len(line) > 0 {
+			logger.Debugf("%s", line)
+			l.add(strings.TrimRight(string(line), "\r\n"))
+		}
+		if err == io.EOF {
+			logger.WithError(err).Debug("log pipe close")
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error("log pipe error")
+			return
+		}
+	}
+}
+
+func (l *buildLog) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		fmt.Fprintf(l.file, "%s %s\n", time.Now().UTC().Format(time.RFC3339Nano), line)
+	}
+	l.parser.Add(line)
+	if stage, ok := builder.ParseStageMarker(line); ok {
+		logger.WithField("stage", stage).Debug("build script entered a new stage")
+		l.stage = stage
+		l.emit(builder.Event{Type: builder.EventStage, Stage: stage})
+	}
+	if sum, url, ok := builder.ParseHeaderMarker(line); ok {
+		l.headerSums[url] = sum
+	}
+	if len(l.tail) < buildLogTailLines {
+		l.tail = append(l.tail, line)
+		return
+	}
+	l.tail[l.next] = line
+	l.next = (l.next + 1) % buildLogTailLines
+}
+
+// lastLines returns the last lines of the build output, oldest first.
+func (l *buildLog) lastLines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := make([]string, 0, len(l.tail))
+	lines = append(lines, l.tail[l.next:]...)
+	return append(lines, l.tail[:l.next]...)
+}
+
+// downloadedHeaderSums returns the sha256 of the kernel header packages the build script downloaded, by url.
+func (l *buildLog) downloadedHeaderSums() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sums := make(map[string]string, len(l.headerSums))
+	for url, sum := range l.headerSums {
+		sums[url] = sum
+	}
+	return sums
+}
+
+// scriptFailed is like failed, for a build script that exited with the given code.
+func (l *buildLog) scriptFailed(exitCode int, err error) error {
+	return l.failed(l.scriptError(exitCode, err))
+}
+
+func (l *buildLog) scriptError(exitCode int, err error) *ScriptError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &ScriptError{Stage: l.stage, ExitCode: exitCode, Err: err}
+}
+
+// failed prints the last lines of the build output at error level, and returns err
+// along with the diagnosis of the output, if anything was found.
+func (l *buildLog) failed(err error) error {
+	lines := l.lastLines()
+	if len(lines) > 0 {
+		logger.Errorf("build failed, last %d lines of output:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if l.file != nil {
+		logger.WithField("path", l.file.Name()).Error("full build output available")
+	}
+
+	l.mu.Lock()
+	report := l.parser.Report()
+	l.mu.Unlock()
+	for _, s := range report.Summary {
+		logger.Error(s)
+	}
+	if len(l.diagnosticsPath) > 0 {
+		if werr := writeDiagnostics(report, l.diagnosticsPath, l.diagnosticsFormat); werr != nil {
+			logger.WithError(werr).Warn("could not write the build diagnostics")
+		} else {
+			logger.WithField("path", l.diagnosticsPath).Error("build diagnostics available")
+		}
+	}
+	if report.Empty() {
+		return err
+	}
+	return &diagnostics.Error{Err: err, Report: report}
+}
+
+func writeDiagnostics(report *diagnostics.Report, path string, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close closes the log file, if any.
+func (l *buildLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,72 @@
+package driverbuilder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestBuildLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "build.log")
+	blog, err := newBuildLog(&builder.Build{BuildLogPath: logPath})
+	assert.NilError(t, err)
+
+	var output strings.Builder
+	for i := 0; i < buildLogTailLines+10; i++ {
+		fmt.Fprintf(&output, "line %d\n", i)
+	}
+	// The last line is not terminated, as when the script gets killed
+	output.WriteString("partial")
+	blog.forward(strings.NewReader(output.String()))
+	assert.NilError(t, blog.Close())
+
+	tail := blog.lastLines()
+	assert.Equal(t, len(tail), buildLogTailLines)
+	assert.Equal(t, tail[0], "line 11")
+	assert.Equal(t, tail[len(tail)-2], fmt.Sprintf("line %d", buildLogTailLines+9))
+	assert.Equal(t, tail[len(tail)-1], "partial")
+
+	content, err := os.ReadFile(logPath)
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Equal(t, len(lines), buildLogTailLines+11)
+	for i, line := range lines[:len(lines)-1] {
+		ts, text, ok := strings.Cut(line, " ")
+		assert.Assert(t, ok)
+		_, err := time.Parse(time.RFC3339Nano, ts)
+		assert.NilError(t, err)
+		assert.Equal(t, text, fmt.Sprintf("line %d", i))
+	}
+}
+
+func TestBuildLogScriptFailure(t *testing.T) {
+	blog, err := newBuildLog(&builder.Build{})
+	assert.NilError(t, err)
+	blog.forward(strings.NewReader(`+ echo 'driverkit-stage: header-fetch'
+driverkit-stage: header-fetch
+++ sha256sum kernel.deb
++ echo 'driverkit-header: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 https://example.com/linux-headers.deb'
+driverkit-header: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 https://example.com/linux-headers.deb
++ echo 'driverkit-stage: module-build'
+driverkit-stage: module-build
++ echo 'driverkit-stage: probe-build'
+make: *** [Makefile:8: all] Error 2
+`))
+	err = blog.scriptFailed(2, nil)
+
+	var scriptErr *ScriptError
+	assert.Assert(t, errors.As(err, &scriptErr))
+	assert.Equal(t, scriptErr.Stage, builder.StageModuleBuild)
+	assert.Equal(t, scriptErr.ExitCode, 2)
+	assert.Equal(t, err.Error(), "build script failed during stage module-build with exit code 2")
+	assert.DeepEqual(t, blog.downloadedHeaderSums(), map[string]string{
+		"https://example.com/linux-headers.deb": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	})
+}
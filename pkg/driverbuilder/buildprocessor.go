@@ -1,10 +1,14 @@
 package driverbuilder
 
 import (
+	"context"
+
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 )
 
 type BuildProcessor interface {
-	Start(b *builder.Build) error
+	// Start runs the build, until done or until ctx is canceled;
+	// on cancellation, whatever the build started (containers, pods, ...) is cleaned up before returning.
+	Start(ctx context.Context, b *builder.Build) error
 	String() string
 }
@@ -0,0 +1,174 @@
+package driverbuilder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// buildCache is a local, content-addressed store of build artifacts.
+//
+// Every entry lives under a directory named after the hash of all the build inputs,
+// so that rebuilding the very same inputs can be skipped altogether.
+type buildCache struct {
+	dir string
+}
+
+// newBuildCache returns a buildCache rooted at dir, or nil when dir is empty (ie. cache disabled).
+func newBuildCache(dir string) *buildCache {
+	if len(dir) == 0 {
+		return nil
+	}
+	return &buildCache{dir: dir}
+}
+
+// buildCacheKey computes the hash of all the inputs that determine the build artifacts:
+// the module source tarball, the kernel header packages, the generated build script
+// (that already embeds the resolved urls and the gcc version), the builder image digest
+// and the driver defines.
+func buildCacheKey(b *builder.Build, script string, imageDigest string, kernelFiles []string) (string, error) {
+	h := sha256.New()
+	fields := map[string]string{
+		"target":           b.TargetType.String(),
+		"kernelrelease":    b.KernelRelease,
+		"kernelversion":    b.KernelVersion,
+		"architecture":     b.Architecture,
+		"kernelconfigdata": b.KernelConfigData,
+		"drivername":       b.ModuleDriverName,
+		"devicename":       b.ModuleDeviceName,
+		"gccversion":       b.GCCVersion,
+		"image":            imageDigest,
+		"module":           fmt.Sprintf("%t", len(b.ModuleOutPutFilePath) > 0),
+		"probe":            fmt.Sprintf("%t", len(b.ProbeFilePath) > 0),
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, fields[k])
+	}
+	fmt.Fprintf(h, "script=%s\n", hashString(script))
+
+	// The module source may be a local tarball or a plain reference (eg. a git ref)
+	if info, err := os.Stat(b.ModuleFilePath); err == nil && info.Mode().IsRegular() {
+		sum, err := hashFile(b.ModuleFilePath)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "modulefile=%s\n", sum)
+	} else {
+		fmt.Fprintf(h, "modulefile=%s\n", b.ModuleFilePath)
+	}
+
+	for _, kernelFile := range kernelFiles {
+		sum, err := hashFile(kernelFile)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "kernelfile=%s\n", sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *buildCache) entryDir(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// restore copies the cached artifacts of the given key to the requested output paths.
+//
+// It returns true only when every requested artifact was found into the cache.
+func (c *buildCache) restore(key string, b *builder.Build) (bool, error) {
+	entry := c.entryDir(key)
+	artifacts := cachedArtifacts(b)
+	for name := range artifacts {
+		if _, err := os.Stat(filepath.Join(entry, name)); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	for name, dst := range artifacts {
+		if err := copyFile(filepath.Join(entry, name), dst); err != nil {
+			return false, err
+		}
+	}
+	logger.WithField("key", key).Info("build inputs unchanged, artifacts restored from cache")
+	return true, nil
+}
+
+// store saves the built artifacts under the given key.
+func (c *buildCache) store(key string, b *builder.Build) error {
+	entry := c.entryDir(key)
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		return err
+	}
+	for name, src := range cachedArtifacts(b) {
+		// Write to a temporary file first, so that a partial copy never results in a cache hit
+		tmp := filepath.Join(entry, name+".tmp")
+		if err := copyFile(src, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(entry, name)); err != nil {
+			return err
+		}
+	}
+	logger.WithField("key", key).Debug("artifacts stored into the build cache")
+	return nil
+}
+
+// cachedArtifacts maps the cache entry file names to the requested output paths.
+func cachedArtifacts(b *builder.Build) map[string]string {
+	artifacts := make(map[string]string)
+	if len(b.ModuleOutPutFilePath) > 0 {
+		artifacts[builder.ModuleFileName] = b.ModuleOutPutFilePath
+	}
+	if len(b.ProbeFilePath) > 0 {
+		artifacts[builder.ProbeFileName] = b.ProbeFilePath
+	}
+	return artifacts
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
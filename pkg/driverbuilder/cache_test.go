@@ -0,0 +1,69 @@
+package driverbuilder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestBuildCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	moduleFile := filepath.Join(dir, "module.tar.gz")
+	assert.NilError(t, os.WriteFile(moduleFile, []byte("source"), 0644))
+
+	b := &builder.Build{
+		TargetType:           builder.TargetTypeUbuntu,
+		KernelRelease:        "4.15.0-20-generic",
+		KernelVersion:        "21",
+		Architecture:         "amd64",
+		ModuleFilePath:       moduleFile,
+		ModuleOutPutFilePath: filepath.Join(dir, "out.ko"),
+		GCCVersion:           "8.0.0",
+	}
+
+	key, err := buildCacheKey(b, "script", "sha256:abc", nil)
+	assert.NilError(t, err)
+	again, err := buildCacheKey(b, "script", "sha256:abc", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, key, again)
+
+	other, err := buildCacheKey(b, "script", "sha256:def", nil)
+	assert.NilError(t, err)
+	assert.Assert(t, key != other, "image digest must be part of the key")
+
+	assert.NilError(t, os.WriteFile(moduleFile, []byte("changed source"), 0644))
+	changed, err := buildCacheKey(b, "script", "sha256:abc", nil)
+	assert.NilError(t, err)
+	assert.Assert(t, key != changed, "module source must be part of the key")
+}
+
+func TestBuildCacheStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.ko")
+	b := &builder.Build{ModuleOutPutFilePath: out}
+	cache := newBuildCache(filepath.Join(dir, "cache"))
+
+	hit, err := cache.restore("key", b)
+	assert.NilError(t, err)
+	assert.Assert(t, !hit)
+
+	assert.NilError(t, os.WriteFile(out, []byte("module"), 0644))
+	assert.NilError(t, cache.store("key", b))
+	assert.NilError(t, os.Remove(out))
+
+	hit, err = cache.restore("key", b)
+	assert.NilError(t, err)
+	assert.Assert(t, hit)
+	data, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Equal(t, "module", string(data))
+
+	// Requesting an artifact that was never stored is a miss
+	b.ProbeFilePath = filepath.Join(dir, "out.o")
+	hit, err = cache.restore("key", b)
+	assert.NilError(t, err)
+	assert.Assert(t, !hit)
+}
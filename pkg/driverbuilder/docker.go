@@ -2,25 +2,30 @@ package driverbuilder
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"io"
 	"io/ioutil"
-	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
-	"github.com/falcosecurity/driverkit/pkg/signals"
 	logger "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/uuid"
 )
@@ -29,16 +34,23 @@ import (
 const DockerBuildProcessorName = "docker"
 
 type DockerBuildProcessor struct {
-	clean   bool
-	timeout int
-	proxy   string
+	timeout  int
+	proxy    string
+	poolSize int
+	// host is the docker API endpoint, taken from the environment when empty
+	host string
 }
 
 // NewDockerBuildProcessor ...
-func NewDockerBuildProcessor(timeout int, proxy string) *DockerBuildProcessor {
+//
+// When poolSize is greater than zero, up to poolSize builder containers per image are kept alive
+// and recycled across builds, instead of starting a fresh container for every build.
+// Pooled containers exit once idle for more than timeout seconds.
+func NewDockerBuildProcessor(timeout int, proxy string, poolSize int) *DockerBuildProcessor {
 	return &DockerBuildProcessor{
-		timeout: timeout,
-		proxy:   proxy,
+		timeout:  timeout,
+		proxy:    proxy,
+		poolSize: poolSize,
 	}
 }
 
@@ -46,28 +58,26 @@ func (bp *DockerBuildProcessor) String() string {
 	return DockerBuildProcessorName
 }
 
-func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) {
+// QemuHostUnsupportedErr is returned for cross builds on hosts qemu-user-static is not available for.
+var QemuHostUnsupportedErr = errors.New("qemu-user-static image is only available for x86_64 hosts: https://github.com/multiarch/qemu-user-static#supported-host-architectures")
+
+// checkArchUseQemu registers the qemu binary formats, when building for another architecture than the host one.
+func checkArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) error {
 	var err error
 	if b.Architecture == runtime.GOARCH {
 		// Nothing to do
-		return
+		return nil
 	}
 
 	if runtime.GOARCH != kernelrelease.ArchitectureAmd64 {
-		log.Fatal("qemu-user-static image is only available for x86_64 hosts: https://github.com/multiarch/qemu-user-static#supported-host-architectures")
+		return QemuHostUnsupportedErr
 	}
 
 	logger.Debug("using qemu for cross build")
 	if _, _, err = cli.ImageInspectWithRaw(ctx, "multiarch/qemu-user-static"); client.IsErrNotFound(err) {
 		logger.WithField("image", "multiarch/qemu-user-static").Debug("pulling qemu static image")
-		pullRes, err := cli.ImagePull(ctx, "multiarch/qemu-user-static", types.ImagePullOptions{})
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer pullRes.Close()
-		_, err = io.Copy(ioutil.Discard, pullRes)
-		if err != nil {
-			log.Fatal(err)
+		if err := pullImage(ctx, cli, "multiarch/qemu-user-static", "", b.PullTimeout); err != nil {
+			return fmt.Errorf("could not pull the qemu static image: %w", err)
 		}
 	}
 	qemuImage, err := cli.ContainerCreate(ctx,
@@ -80,37 +90,49 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 			Privileged: true,
 		}, nil, nil, "")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if err = cli.ContainerStart(ctx, qemuImage.ID, types.ContainerStartOptions{}); err != nil {
-		panic(err)
+		return err
 	}
 
 	statusCh, errCh := cli.ContainerWait(ctx, qemuImage.ID, container.WaitConditionNotRunning)
 	select {
 	case err = <-errCh:
 		if err != nil {
-			panic(err)
+			return err
 		}
 	case <-statusCh:
 	}
 
 	err = cli.ContainerStop(ctx, qemuImage.ID, nil)
 	if err != nil && !client.IsErrNotFound(err) {
-		log.Fatal(err)
+		return err
 	}
+	return nil
 }
 
 // Start the docker processor
-func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
+func (bp *DockerBuildProcessor) Start(ctx context.Context, b *builder.Build) error {
 	logger.Debug("doing a new docker build")
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if hit, err := restoreFromRepository(b); err != nil {
+		return err
+	} else if hit {
+		return nil
+	}
+
+	// Builder images must be looked up against the same daemon
+	b.DockerHost = bp.host
+	cli, err := builder.NewDockerClient(bp.host)
 	if err != nil {
 		return err
 	}
 
-	kr := b.KernelReleaseFromBuildConfig()
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
 
 	// create a builder based on the choosen build type
 	v, err := builder.Factory(b.TargetType)
@@ -120,7 +142,7 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	c := b.ToConfig()
 
 	var localKernelFiles []string
-	if !builder.IsOnlineMode() {
+	if !b.OnlineMode {
 		localKernelFiles, err = v.SearchLocalKernelFilepath(c, kr)
 		if err != nil {
 			return err
@@ -137,30 +159,39 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	}
 
 	// Generate the build script from the builder
-	driverkitScript, err := builder.Script(v, c, kr)
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
 
-	// Prepare driver config template
-	/*bufFillDriverConfig := bytes.NewBuffer(nil)
-	err = renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.ModuleFilePath, DriverName: c.DriverName, DeviceName: c.DeviceName})
+	blog, err := newBuildLog(b)
 	if err != nil {
 		return err
-	}*/
+	}
+	defer blog.Close()
 
-	/*configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	manifest := newManifest(b, bp.String())
+	if err := manifest.setInputs(b, localKernelFiles); err != nil {
+		return err
+	}
+	publishers, err := newPublishers(b)
 	if err != nil {
 		return err
-	}*/
+	}
 
-	builderImage := b.GetBuilderImage()
+	support, err := supportFiles(c)
+	if err != nil {
+		return err
+	}
 
-	// Create the container
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
+	builderImage, err := b.GetBuilderImage()
+	if err != nil {
+		return err
+	}
 
-	mustCheckArchUseQemu(ctx, b, cli)
+	if err := checkArchUseQemu(ctx, b, cli); err != nil {
+		return err
+	}
 
 	var inspect types.ImageInspect
 	if inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage); client.IsErrNotFound(err) ||
@@ -171,21 +202,43 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 			WithField("arch", b.Architecture).
 			Debug("pulling builder image")
 
-		pullRes, err := cli.ImagePull(ctx, builderImage, types.ImagePullOptions{Platform: b.Architecture})
-		if err != nil {
+		b.Emit(builder.Event{Type: builder.EventPullingImage, Image: builderImage})
+		if err := pullImage(ctx, cli, builderImage, b.Architecture, b.PullTimeout); err != nil {
 			return err
 		}
-		defer pullRes.Close()
-		_, err = io.Copy(ioutil.Discard, pullRes)
+	}
+
+	inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage)
+	if err != nil {
+		return err
+	}
+	manifest.setImage(builderImage, imageDigest(inspect))
+
+	cache := newBuildCache(b.CacheDir)
+	var cacheKey string
+	if cache != nil {
+		cacheKey, err = buildCacheKey(b, driverkitScript, inspect.ID, localKernelFiles)
 		if err != nil {
 			return err
 		}
+		if hit, err := cache.restore(cacheKey, b); err != nil {
+			return err
+		} else if hit {
+			if err := manifest.write(b, true); err != nil {
+				return err
+			}
+			return publish(ctx, publishers, manifest)
+		}
 	}
 
 	logger.
 		WithField("image", builderImage).
 		Debug("starting container")
 
+	// The build timeout bounds the container setup and the build script, the cancellation of ctx stops the container
+	buildCtx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
 	containerCfg := &container.Config{
 		Tty:   true,
 		Cmd:   []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
@@ -195,34 +248,76 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	hostCfg := &container.HostConfig{
 		AutoRemove: true,
 	}
-	uid := uuid.NewUUID()
-	name := fmt.Sprintf("driverkit-%s", string(uid))
+	if len(b.KernelHeadersCache) > 0 {
+		m, err := kernelHeadersCacheMount(b.KernelHeadersCache)
+		if err != nil {
+			return err
+		}
+		hostCfg.Mounts = append(hostCfg.Mounts, m)
+	}
+	platform := &v1.Platform{Architecture: b.Architecture, OS: "linux"}
 
-	cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: b.Architecture, OS: "linux"}, name)
-	if err != nil {
-		return err
+	var containerID string
+	workDir := builder.DefaultWorkDir
+	if bp.poolSize > 0 {
+		pc, err := acquirePooledContainer(buildCtx, cli, bp.poolSize, bp.timeout, containerCfg, hostCfg, platform)
+		if err != nil {
+			return err
+		}
+		// A nil container means that the pool is exhausted, fallback at a dedicated one
+		if pc != nil {
+			// Interrupted builds get their pooled container discarded
+			defer pc.release(buildCtx)
+			containerID = pc.id
+			// The build script is relocated into the work directory of the build,
+			// the cache key keeps being computed against the one of the default work directory
+			workDir = pc.workDir
+			b.WorkDir = workDir
+			driverkitScript, err = builder.RenderScript(v, c, kr)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	defer bp.cleanup(cli, cdata.ID)
-	go func() {
-		for {
+	if len(containerID) == 0 {
+		uid := uuid.NewUUID()
+		name := fmt.Sprintf("driverkit-%s", string(uid))
+
+		cdata, err := cli.ContainerCreate(buildCtx, containerCfg, hostCfg, nil, platform, name)
+		if err != nil {
+			return err
+		}
+
+		var once sync.Once
+		stop := func() {
+			once.Do(func() { stopContainer(cli, cdata.ID) })
+		}
+		defer stop()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
 			select {
 			case <-ctx.Done():
-				bp.cleanup(cli, cdata.ID)
-				return
+				logger.Debug("context canceled")
+				stop()
+			case <-done:
 			}
-		}
-	}()
+		}()
 
-	err = cli.ContainerStart(ctx, cdata.ID, types.ContainerStartOptions{})
-	if err != nil {
-		return err
+		err = cli.ContainerStart(buildCtx, cdata.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return err
+		}
+		containerID = cdata.ID
+		b.Emit(builder.Event{Type: builder.EventContainerStarted, Image: builderImage})
 	}
 
 	files := []dockerCopyFile{
-		{"/driverkit/driverkit.sh", driverkitScript},
-		//{"/driverkit/kernel.config", string(configDecoded)},
-		//{"/driverkit/fill-driver-config.sh", bufFillDriverConfig.String()},
+		{path.Join(b.SupportDir(), "driverkit.sh"), driverkitScript},
+	}
+	for _, name := range sortedKeys(support) {
+		files = append(files, dockerCopyFile{path.Join(b.SupportDir(), name), support[name]})
 	}
 
 	var buf bytes.Buffer
@@ -231,21 +326,21 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 	// Copy the needed files to the container
-	err = cli.CopyToContainer(ctx, cdata.ID, "/", &buf, types.CopyToContainerOptions{})
+	err = cli.CopyToContainer(buildCtx, containerID, "/", &buf, types.CopyToContainerOptions{})
 	if err != nil {
 		return err
 	}
 
-	if !builder.IsOnlineMode() {
+	if !b.OnlineMode {
 		//copy kernel file to container
 		for i, kernelFile := range localKernelFiles {
-			err = builder.CopyFileToContainer(ctx, cli, cdata.ID, kernelFile, fmt.Sprintf("/kernel%d", i))
+			err = builder.CopyFileToContainer(buildCtx, cli, containerID, kernelFile, path.Join(workDir, builder.KernelFileName(i)))
 			if err != nil {
 				return err
 			}
 		}
 	}
-	err = builder.CopyFileToContainer(ctx, cli, cdata.ID, b.ModuleFilePath, "/kernel-module.tar.gz")
+	err = builder.CopyFileToContainer(buildCtx, cli, containerID, b.ModuleFilePath, path.Join(workDir, builder.ModuleArchiveFileName))
 	if err != nil {
 		return err
 	}
@@ -259,13 +354,13 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 			fmt.Sprintf("https_proxy=%s", bp.proxy),
 		)
 	}
-	if builder.IsOnlineMode() {
+	if b.OnlineMode {
 		envs = append(envs, "MODE=online")
 	} else {
 		envs = append(envs, "MODE=local")
 	}
 
-	edata, err := cli.ContainerExecCreate(ctx, cdata.ID, types.ExecConfig{
+	edata, err := cli.ContainerExecCreate(buildCtx, containerID, types.ExecConfig{
 		Privileged:   false,
 		Tty:          false,
 		AttachStdin:  false,
@@ -275,7 +370,7 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		Env:          envs,
 		Cmd: []string{
 			"/bin/bash",
-			"/driverkit/driverkit.sh",
+			path.Join(b.SupportDir(), "driverkit.sh"),
 		},
 	})
 
@@ -283,55 +378,141 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	hr, err := cli.ContainerExecAttach(ctx, edata.ID, types.ExecStartCheck{})
+	hr, err := cli.ContainerExecAttach(buildCtx, edata.ID, types.ExecStartCheck{})
 	if err != nil {
 		return err
 	}
 	defer hr.Close()
+	// Stop following the output once the build is interrupted
+	execDone := make(chan struct{})
+	go func() {
+		select {
+		case <-buildCtx.Done():
+			hr.Close()
+		case <-execDone:
+		}
+	}()
+
+	// Without a tty, the exec output is multiplexed
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, hr.Reader)
+		pw.CloseWithError(err)
+	}()
+	blog.forward(pr)
+	close(execDone)
+	if ctx.Err() != nil {
+		return blog.failed(fmt.Errorf("docker build interrupted: %w", ctx.Err()))
+	}
+	if buildCtx.Err() == context.DeadlineExceeded {
+		return blog.failed(fmt.Errorf("docker build timed out after %d seconds", bp.timeout))
+	}
 
-	forwardLogs(hr.Reader)
+	exitCode, err := execExitCode(buildCtx, cli, edata.ID)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return blog.scriptFailed(exitCode, nil)
+	}
 
 	if len(b.ModuleOutPutFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.ModuleOutPutFilePath); err != nil {
-			return err
+		b.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: b.ModuleOutPutFilePath})
+		if err := copyFromContainer(ctx, cli, containerID, b.ModulePath(), b.ModuleOutPutFilePath, b.CopyTimeout); err != nil {
+			return blog.failed(err)
 		}
 		logger.WithField("path", b.ModuleOutPutFilePath).Info("kernel module available")
 	}
 
 	if len(b.ProbeFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.ProbeFilePath); err != nil {
-			return err
+		b.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: b.ProbeFilePath})
+		if err := copyFromContainer(ctx, cli, containerID, b.ProbePath(), b.ProbeFilePath, b.CopyTimeout); err != nil {
+			return blog.failed(err)
 		}
 		logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
 	}
 
-	return nil
-}
+	if err := verifyArtifacts(v, b, kr); err != nil {
+		return err
+	}
 
-func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to string) error {
-	content, stat, err := cli.CopyFromContainer(ctx, ID, from)
-	if err != nil {
+	if cache != nil {
+		if err := cache.store(cacheKey, b); err != nil {
+			logger.WithError(err).Warn("could not store artifacts into the build cache")
+		}
+	}
+
+	manifest.setHeaderSums(blog.downloadedHeaderSums())
+	if err := manifest.write(b, false); err != nil {
 		return err
 	}
-	defer content.Close()
+	return publish(ctx, publishers, manifest)
+}
+
+// kernelHeadersCacheMount returns the mount backing the kernel headers cache.
+// Like for `docker run -v`, a path is bind mounted from the host,
+// while anything else is the name of a docker volume.
+func kernelHeadersCacheMount(src string) (mount.Mount, error) {
+	m := mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: src,
+		Target: builder.KernelHeadersCacheMountPath,
+	}
+	if strings.ContainsRune(src, filepath.Separator) || strings.HasPrefix(src, ".") {
+		dir, err := filepath.Abs(src)
+		if err != nil {
+			return mount.Mount{}, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return mount.Mount{}, err
+		}
+		m.Type = mount.TypeBind
+		m.Source = dir
+	}
+	return m, nil
+}
 
-	srcInfo := archive.CopyInfo{
-		Path:   from,
-		Exists: true,
-		IsDir:  stat.Mode.IsDir(),
+// pullImage pulls the image for the given architecture, within timeout (unbounded if zero).
+func pullImage(ctx context.Context, cli *client.Client, image string, arch string, timeout time.Duration) error {
+	pullCtx, cancel := builder.WithTimeout(ctx, timeout)
+	defer cancel()
+	pullRes, err := cli.ImagePull(pullCtx, image, types.ImagePullOptions{Platform: arch})
+	if err == nil {
+		defer pullRes.Close()
+		_, err = io.Copy(ioutil.Discard, pullRes)
 	}
-	preArchive := content
-	return archive.CopyTo(preArchive, srcInfo, to)
+	if err != nil && pullCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("pull of image %s timed out after %s", image, timeout)
+	}
+	return err
 }
 
-func (bp *DockerBuildProcessor) cleanup(cli *client.Client, ID string) {
-	if !bp.clean {
-		bp.clean = true
-		logger.Debug("context canceled")
-		duration := time.Second
-		if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
-			logger.WithError(err).WithField("container_id", ID).Error("error stopping container")
+// copyFromContainer copies from out of the container into to, within timeout (unbounded if zero).
+func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to string, timeout time.Duration) error {
+	copyCtx, cancel := builder.WithTimeout(ctx, timeout)
+	defer cancel()
+	content, stat, err := cli.CopyFromContainer(copyCtx, ID, from)
+	if err == nil {
+		defer content.Close()
+		srcInfo := archive.CopyInfo{
+			Path:   from,
+			Exists: true,
+			IsDir:  stat.Mode.IsDir(),
 		}
+		err = archive.CopyTo(content, srcInfo, to)
+	}
+	if err != nil && copyCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("copy of %s from the container timed out after %s", from, timeout)
+	}
+	return err
+}
+
+// stopContainer stops the container, which is removed along since the processor starts them with AutoRemove.
+func stopContainer(cli *client.Client, ID string) {
+	duration := time.Second
+	// The build context could be already canceled
+	if err := cli.ContainerStop(context.Background(), ID, &duration); err != nil && !client.IsErrNotFound(err) {
+		logger.WithError(err).WithField("container_id", ID).Error("error stopping container")
 	}
 }
 
@@ -359,19 +540,27 @@ func tarWriterFiles(buf io.Writer, files []dockerCopyFile) error {
 	return nil
 }
 
-func forwardLogs(logPipe io.Reader) {
-	lineReader := bufio.NewReader(logPipe)
-	for {
-		line, err := lineReader.ReadBytes('\n')
-		if len(line) > 0 {
-			logger.Debugf("%s", line)
+// execExitCode returns the exit code of the exec, waiting for it to be reported once its output is over.
+func execExitCode(ctx context.Context, cli *client.Client, execID string) (int, error) {
+	for i := 0; ; i++ {
+		inspect, err := cli.ContainerExecInspect(ctx, execID)
+		if err != nil {
+			return 0, err
 		}
-		if err == io.EOF {
-			logger.WithError(err).Debug("log pipe close")
-			return
+		if !inspect.Running {
+			return inspect.ExitCode, nil
 		}
-		if err != nil {
-			logger.WithError(err).Error("log pipe error")
+		if i == 50 {
+			return 0, errors.New("build script still running after its output was over")
 		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// imageDigest returns the repository digest of the image, or its ID for images never pushed nor pulled.
+func imageDigest(inspect types.ImageInspect) string {
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0]
 	}
+	return inspect.ID
 }
@@ -0,0 +1,278 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	logger "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	dockerPoolLabel      = "org.falcosecurity/driverkit-pool"
+	dockerPoolImageLabel = "org.falcosecurity/driverkit-pool-image"
+	// dockerPoolLeaseDir is created into a pooled container while it is running a build.
+	// Since mkdir is atomic, it works as a lock even among different driverkit processes.
+	dockerPoolLeaseDir = "/tmp/driverkit-lease"
+	// dockerPoolIdleFile is touched every time a pooled container is given back to the pool.
+	dockerPoolIdleFile = "/tmp/driverkit-idle"
+	// dockerPoolWorkDirPrefix prefixes the work directory every build gets into a pooled container.
+	dockerPoolWorkDirPrefix = "/tmp/driverkit-build."
+)
+
+// dockerPoolEntrypoint keeps a pooled container alive until it stays idle for more than the given seconds.
+// Before exiting it takes the lease itself, so that it never goes away in the middle of a build.
+const dockerPoolEntrypoint = `
+touch %[1]s
+while sleep 5; do
+  if [ $(( $(date +%%s) - $(stat -c %%Y %[1]s) )) -ge %[3]d ] && mkdir %[2]s 2>/dev/null; then
+    exit 0
+  fi
+done
+`
+
+// dockerPoolPrepareScript removes the work directories left by the builds interrupted before their release,
+// then creates the work directory of the build leasing the container.
+const dockerPoolPrepareScript = `rm -Rf ` + dockerPoolWorkDirPrefix + `* && mktemp -d ` + dockerPoolWorkDirPrefix + `XXXXXX`
+
+// pooledContainer is a builder container leased from the pool.
+type pooledContainer struct {
+	cli *client.Client
+	id  string
+	// mounts are the paths of the container that do not belong to its filesystem
+	mounts []string
+	// workDir is the work directory of the build leasing the container, the only place it may write into
+	workDir string
+}
+
+// dockerPoolKey identifies the pool a container belongs to:
+// containers are only interchangeable if they run the same image with the same mounts.
+func dockerPoolKey(containerCfg *container.Config, hostCfg *container.HostConfig, platform *v1.Platform) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "image=%s\narch=%s\n", containerCfg.Image, platform.Architecture)
+	mounts := make([]string, 0, len(hostCfg.Mounts))
+	for _, m := range hostCfg.Mounts {
+		mounts = append(mounts, fmt.Sprintf("%s:%s:%s", m.Type, m.Source, m.Target))
+	}
+	sort.Strings(mounts)
+	for _, m := range mounts {
+		fmt.Fprintf(h, "mount=%s\n", m)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// acquirePooledContainer leases an idle container of the matching pool, or starts a new one
+// when the pool has less than size containers.
+// The build must keep everything it writes into the work directory of the returned container.
+//
+// It returns a nil pooledContainer when all the size containers of the pool are busy.
+func acquirePooledContainer(ctx context.Context, cli *client.Client, size int, idleTimeout int, containerCfg *container.Config, hostCfg *container.HostConfig, platform *v1.Platform) (*pooledContainer, error) {
+	key := dockerPoolKey(containerCfg, hostCfg, platform)
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", fmt.Sprintf("%s=%s", dockerPoolLabel, key)),
+			filters.Arg("status", "running"),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := make([]string, 0, len(hostCfg.Mounts))
+	for _, m := range hostCfg.Mounts {
+		mounts = append(mounts, m.Target)
+	}
+
+	for _, c := range containers {
+		pc := &pooledContainer{cli: cli, id: c.ID, mounts: mounts}
+		if ok, err := pc.lease(ctx); err != nil {
+			logger.WithError(err).WithField("container_id", c.ID).Debug("could not lease pooled container")
+			continue
+		} else if !ok {
+			continue
+		}
+		// Make sure nothing was left around by a previous build
+		if err := pc.prepare(ctx); err != nil {
+			logger.WithError(err).WithField("container_id", c.ID).Debug("discarding pooled container")
+			pc.discard()
+			continue
+		}
+		logger.WithField("container_id", c.ID).Debug("reusing pooled container")
+		return pc, nil
+	}
+
+	if len(containers) >= size {
+		logger.WithField("size", size).Debug("container pool exhausted")
+		return nil, nil
+	}
+
+	cfg := *containerCfg
+	// Pooled containers outlive the single build, they exit on their own once idle for too long
+	cfg.Cmd = []string{"/bin/bash", "-c", fmt.Sprintf(dockerPoolEntrypoint, dockerPoolIdleFile, dockerPoolLeaseDir, idleTimeout)}
+	cfg.Labels = map[string]string{
+		dockerPoolLabel:      key,
+		dockerPoolImageLabel: containerCfg.Image,
+	}
+	name := fmt.Sprintf("driverkit-pool-%s", string(uuid.NewUUID()))
+	cdata, err := cli.ContainerCreate(ctx, &cfg, hostCfg, nil, platform, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.ContainerStart(ctx, cdata.ID, types.ContainerStartOptions{}); err != nil {
+		return nil, err
+	}
+	pc := &pooledContainer{cli: cli, id: cdata.ID, mounts: mounts}
+	if ok, err := pc.lease(ctx); err != nil || !ok {
+		pc.discard()
+		return nil, fmt.Errorf("could not lease the new pooled container %s: %v", cdata.ID, err)
+	}
+	if err := pc.prepare(ctx); err != nil {
+		pc.discard()
+		return nil, fmt.Errorf("could not prepare the new pooled container %s: %v", cdata.ID, err)
+	}
+	logger.WithField("container_id", cdata.ID).Debug("started pooled container")
+	return pc, nil
+}
+
+func (pc *pooledContainer) lease(ctx context.Context) (bool, error) {
+	exitCode, _, err := execInContainer(ctx, pc.cli, pc.id, []string{"mkdir", dockerPoolLeaseDir})
+	if err != nil {
+		return false, err
+	}
+	return exitCode == 0, nil
+}
+
+// prepare creates the work directory of the build, once the container is checked to be clean.
+func (pc *pooledContainer) prepare(ctx context.Context) error {
+	exitCode, out, err := execInContainer(ctx, pc.cli, pc.id, []string{"/bin/bash", "-c", dockerPoolPrepareScript})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("preparation of pooled container failed with exit code %d: %s", exitCode, out)
+	}
+	workDir := strings.TrimSpace(out)
+	if !strings.HasPrefix(workDir, dockerPoolWorkDirPrefix) || path.Dir(workDir) != path.Dir(dockerPoolWorkDirPrefix) {
+		return fmt.Errorf("unexpected work directory of pooled container: %q", out)
+	}
+	if err := pc.checkClean(ctx); err != nil {
+		return err
+	}
+	pc.workDir = workDir
+	return nil
+}
+
+// checkClean fails when the filesystem of the container was changed outside of the work directories,
+// e.g. by a build script installing the kernel headers into the system directories.
+func (pc *pooledContainer) checkClean(ctx context.Context) error {
+	changes, err := pc.cli.ContainerDiff(ctx, pc.id)
+	if err != nil {
+		return err
+	}
+	if dirty := dockerPoolDirtyPaths(changes, pc.mounts); len(dirty) > 0 {
+		return fmt.Errorf("pooled container changed outside of the work directory: %s", strings.Join(dirty, ", "))
+	}
+	return nil
+}
+
+// dockerPoolDirtyPaths returns the changed paths that prevent a container from being reused:
+// the ones outside of the work directories, of the lease and idle markers, and of the mounts.
+func dockerPoolDirtyPaths(changes []container.ContainerChangeResponseItem, mounts []string) []string {
+	var dirty []string
+	for _, c := range changes {
+		switch {
+		case c.Path == path.Dir(dockerPoolWorkDirPrefix):
+		case c.Path == dockerPoolLeaseDir || c.Path == dockerPoolIdleFile:
+		case strings.HasPrefix(c.Path, dockerPoolWorkDirPrefix):
+		case isUnderAny(c.Path, mounts):
+		default:
+			dirty = append(dirty, c.Path)
+		}
+	}
+	return dirty
+}
+
+// isUnderAny tells whether p is any of dirs or within any of them.
+func isUnderAny(p string, dirs []string) bool {
+	for _, d := range dirs {
+		if p == d || strings.HasPrefix(p, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// release removes the work directory of the build and gives the container back to the pool.
+//
+// When the build was interrupted the container could still be running the build script,
+// so it is discarded instead. It is discarded as well when the build changed it outside of its work directory.
+func (pc *pooledContainer) release(ctx context.Context) {
+	if ctx.Err() != nil {
+		pc.discard()
+		return
+	}
+	exitCode, out, err := execInContainer(context.Background(), pc.cli, pc.id, []string{"rm", "-Rf", pc.workDir})
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("removal of the work directory failed with exit code %d: %s", exitCode, out)
+	}
+	if err == nil {
+		err = pc.checkClean(context.Background())
+	}
+	if err != nil {
+		logger.WithError(err).WithField("container_id", pc.id).Warn("discarding pooled container")
+		pc.discard()
+		return
+	}
+	exitCode, _, err = execInContainer(context.Background(), pc.cli, pc.id, []string{"/bin/bash", "-c", fmt.Sprintf("touch %s && rmdir %s", dockerPoolIdleFile, dockerPoolLeaseDir)})
+	if err != nil || exitCode != 0 {
+		pc.discard()
+	}
+}
+
+func (pc *pooledContainer) discard() {
+	duration := time.Second
+	if err := pc.cli.ContainerStop(context.Background(), pc.id, &duration); err != nil && !client.IsErrNotFound(err) {
+		logger.WithError(err).WithField("container_id", pc.id).Error("error stopping pooled container")
+	}
+}
+
+// execInContainer runs cmd into the given container,
+// returning its exit code and its combined output.
+func execInContainer(ctx context.Context, cli *client.Client, ID string, cmd []string) (int, string, error) {
+	edata, err := cli.ContainerExecCreate(ctx, ID, types.ExecConfig{
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return -1, "", err
+	}
+	hr, err := cli.ContainerExecAttach(ctx, edata.ID, types.ExecStartCheck{})
+	if err != nil {
+		return -1, "", err
+	}
+	defer hr.Close()
+
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, hr.Reader); err != nil {
+		return -1, "", err
+	}
+	inspect, err := cli.ContainerExecInspect(ctx, edata.ID)
+	if err != nil {
+		return -1, "", err
+	}
+	return inspect.ExitCode, out.String(), nil
+}
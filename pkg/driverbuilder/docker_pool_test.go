@@ -0,0 +1,80 @@
+package driverbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+func TestDockerPoolKey(t *testing.T) {
+	cfg := &container.Config{Image: "falcosecurity/driverkit-builder:latest"}
+	platform := &v1.Platform{Architecture: "amd64", OS: "linux"}
+	mounts := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/cache", Target: "/driverkit-headers"},
+		{Type: mount.TypeBind, Source: "/other", Target: "/other"},
+	}
+	key := dockerPoolKey(cfg, &container.HostConfig{Mounts: mounts}, platform)
+
+	reversed := []mount.Mount{mounts[1], mounts[0]}
+	assert.Equal(t, key, dockerPoolKey(cfg, &container.HostConfig{Mounts: reversed}, platform))
+	assert.Assert(t, key != dockerPoolKey(cfg, &container.HostConfig{Mounts: mounts[:1]}, platform), "mounts must be part of the key")
+	assert.Assert(t, key != dockerPoolKey(cfg, &container.HostConfig{Mounts: mounts}, &v1.Platform{Architecture: "arm64", OS: "linux"}), "architecture must be part of the key")
+	other := &container.Config{Image: "falcosecurity/driverkit-builder:other"}
+	assert.Assert(t, key != dockerPoolKey(other, &container.HostConfig{Mounts: mounts}, platform), "image must be part of the key")
+}
+
+func TestDockerPoolDirtyPaths(t *testing.T) {
+	changes := []container.ContainerChangeResponseItem{
+		{Kind: 0, Path: "/tmp"},
+		{Kind: 1, Path: dockerPoolIdleFile},
+		{Kind: 1, Path: dockerPoolLeaseDir},
+		{Kind: 1, Path: dockerPoolWorkDirPrefix + "abc123"},
+		{Kind: 1, Path: dockerPoolWorkDirPrefix + "abc123/driver"},
+		{Kind: 1, Path: "/driverkit-headers"},
+		{Kind: 1, Path: "/driverkit-headers/tree"},
+	}
+	assert.Equal(t, 0, len(dockerPoolDirtyPaths(changes, []string{"/driverkit-headers"})))
+
+	// What a build installs into the system directories makes the container unusable by the next ones
+	changes = append(changes,
+		container.ContainerChangeResponseItem{Kind: 0, Path: "/usr/src"},
+		container.ContainerChangeResponseItem{Kind: 1, Path: "/usr/src/linux-headers-5.10.0-18-amd64"},
+		container.ContainerChangeResponseItem{Kind: 1, Path: "/tmp/kernel"},
+		container.ContainerChangeResponseItem{Kind: 1, Path: "/driverkit-headers-old"},
+	)
+	assert.DeepEqual(t, []string{"/usr/src", "/usr/src/linux-headers-5.10.0-18-amd64", "/tmp/kernel", "/driverkit-headers-old"},
+		dockerPoolDirtyPaths(changes, []string{"/driverkit-headers"}))
+}
+
+func TestPooledContainerCheckClean(t *testing.T) {
+	changes := []container.ContainerChangeResponseItem{
+		{Kind: 0, Path: "/tmp"},
+		{Kind: 1, Path: dockerPoolLeaseDir},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/pooled/changes") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(changes)
+	}))
+	defer srv.Close()
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.41"))
+	assert.NilError(t, err)
+
+	pc := &pooledContainer{cli: cli, id: "pooled"}
+	assert.NilError(t, pc.checkClean(context.Background()))
+
+	changes = append(changes, container.ContainerChangeResponseItem{Kind: 0, Path: "/lib"})
+	assert.ErrorContains(t, pc.checkClean(context.Background()), "/lib")
+}
@@ -1,26 +1,26 @@
 package driverbuilder
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/falcosecurity/driverkit/pkg/signals"
 	"os"
-	"time"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 
 	logger "github.com/sirupsen/logrus"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
-	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	restclient "k8s.io/client-go/rest"
-	"k8s.io/kubectl/pkg/cmd/exec"
 	"k8s.io/utils/pointer"
 )
 
@@ -30,27 +30,44 @@ const falcoBuilderUIDLabel = "org.falcosecurity/driverkit-uid"
 
 type KubernetesBuildProcessor struct {
 	coreV1Client    v1.CoreV1Interface
+	batchV1Client   batchv1client.BatchV1Interface
 	clientConfig    *restclient.Config
 	runAsUser       int64
 	namespace       string
 	imagePullSecret string
 	timeout         int
 	proxy           string
+	jobOptions      KubernetesJobOptions
+}
+
+// KubernetesJobOptions tunes how the build Jobs are scheduled onto the cluster.
+type KubernetesJobOptions struct {
+	Resources    corev1.ResourceRequirements
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	// ServiceAccount runs the build pods, the namespace default one if empty
+	ServiceAccount string
+	// Labels are added to every object created for the build
+	Labels map[string]string
+	// ResultStore, when set, also persists the artifacts into the cluster
+	ResultStore ResultStore
 }
 
 // NewKubernetesBuildProcessor constructs a KubernetesBuildProcessor
 // starting from a kubernetes.Clientset. bufferSize represents the length of the
 // channel we use to do the builds. A bigger bufferSize will mean that we can save more Builds
 // for processing, however setting this to a big value will have impacts
-func NewKubernetesBuildProcessor(corev1Client v1.CoreV1Interface, clientConfig *restclient.Config, runAsUser int64, namespace string, imagePullSecret string, timeout int, proxy string) *KubernetesBuildProcessor {
+func NewKubernetesBuildProcessor(kc kubernetes.Interface, clientConfig *restclient.Config, runAsUser int64, namespace string, imagePullSecret string, timeout int, proxy string, jobOptions KubernetesJobOptions) *KubernetesBuildProcessor {
 	return &KubernetesBuildProcessor{
-		coreV1Client:    corev1Client,
+		coreV1Client:    kc.CoreV1(),
+		batchV1Client:   kc.BatchV1(),
 		clientConfig:    clientConfig,
 		runAsUser:       runAsUser,
 		namespace:       namespace,
 		imagePullSecret: imagePullSecret,
 		timeout:         timeout,
 		proxy:           proxy,
+		jobOptions:      jobOptions,
 	}
 }
 
@@ -58,21 +75,30 @@ func (bp *KubernetesBuildProcessor) String() string {
 	return KubernetesBuildProcessorName
 }
 
-func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
+func (bp *KubernetesBuildProcessor) Start(ctx context.Context, b *builder.Build) error {
 	logger.Debug("doing a new kubernetes build")
-	return bp.buildModule(b)
+	return bp.buildModule(ctx, b)
 }
 
-func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
+func (bp *KubernetesBuildProcessor) buildModule(ctx context.Context, b *builder.Build) error {
+	if hit, err := restoreFromRepository(b); err != nil {
+		return err
+	} else if hit {
+		return nil
+	}
+
 	deadline := int64(bp.timeout)
 	namespace := bp.namespace
 	uid := uuid.NewUUID()
 	name := fmt.Sprintf("driverkit-%s", string(uid))
 
-	podClient := bp.coreV1Client.Pods(namespace)
+	jobClient := bp.batchV1Client.Jobs(namespace)
 	configClient := bp.coreV1Client.ConfigMaps(namespace)
 
-	kr := b.KernelReleaseFromBuildConfig()
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
 
 	// create a builder based on the chosen build type
 	v, err := builder.Factory(b.TargetType)
@@ -80,19 +106,101 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		return err
 	}
 
+	if len(b.KernelHeadersCache) > 0 {
+		logger.Warn("the kernel headers cache is only supported by the docker processor, ignoring it")
+		b.KernelHeadersCache = ""
+	}
+
 	c := b.ToConfig()
 
+	var localKernelFiles []string
+	if !b.OnlineMode {
+		localKernelFiles, err = v.SearchLocalKernelFilepath(c, kr)
+		if err != nil {
+			return err
+		}
+		minimumKernelFiles := 1
+		if vv, ok := v.(builder.MinimumURLsBuilder); ok {
+			minimumKernelFiles = vv.MinimumURLs()
+		}
+		if len(localKernelFiles) != minimumKernelFiles {
+			return fmt.Errorf("not enough headers packages found; expected %d, found %d: %v", minimumKernelFiles, len(localKernelFiles), localKernelFiles)
+		}
+
+		logger.Infof("found local kernel file: %v", localKernelFiles)
+	}
+
 	// generate the build script from the builder
-	res, err := builder.Script(v, c, kr)
+	res, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
 
-	if builder.ModuleFullPath != "" {
+	builderImage, err := b.GetBuilderImage()
+	if err != nil {
+		return err
+	}
+
+	manifest := newManifest(b, bp.String())
+	if err := manifest.setInputs(b, localKernelFiles); err != nil {
+		return err
+	}
+	publishers, err := newPublishers(b)
+	if err != nil {
+		return err
+	}
+	// The digest is reported once the build pod runs
+	manifest.setImage(builderImage, "")
+
+	// The image is not pulled client side, its digest comes from the registry:
+	// keying by the reference would keep serving the artifacts of whatever a mutable tag pointed to
+	cache := newBuildCache(b.CacheDir)
+	var cacheKey string
+	if cache != nil {
+		if imageDigest, err := resolveImageDigest(ctx, builderImage); err != nil {
+			logger.WithError(err).WithField("image", builderImage).Warn("could not resolve the builder image digest, skipping the build cache")
+			cache = nil
+		} else if cacheKey, err = buildCacheKey(b, res, imageDigest, localKernelFiles); err != nil {
+			return err
+		}
+	}
+	if cache != nil {
+		if hit, err := cache.restore(cacheKey, b); err != nil {
+			return err
+		} else if hit {
+			if err := manifest.write(b, true); err != nil {
+				return err
+			}
+			return publish(ctx, publishers, manifest)
+		}
+	}
+
+	// Inputs small enough travel into the ConfigMap, the others are streamed into the pod once running
+	inputs, err := podInputFiles(b, localKernelFiles)
+	if err != nil {
+		return err
+	}
+	binaryData, uploads, err := splitPodInputs(inputs)
+	if err != nil {
+		return err
+	}
+	if len(uploads) > 0 {
+		res = fmt.Sprintf("%s\n%s", waitForUploadScript, res)
+	}
+	for _, name := range sortedKeys(binaryData) {
+		res = fmt.Sprintf("cp /driverkit/%s %s\n%s", name, path.Join(builder.DefaultWorkDir, name), res)
+	}
+
+	// The build pod saves the artifacts into the cluster right after building them
+	if store := bp.jobOptions.ResultStore; store != nil {
+		res = fmt.Sprintf("%s\n%s", res, store.Script(b))
+	}
+
+	if len(b.ModuleOutPutFilePath) > 0 {
 		res = fmt.Sprintf("%s\n%s", "touch "+moduleLockFile, res)
 		res = fmt.Sprintf("%s\n%s", res, "rm "+moduleLockFile)
 	}
-	if builder.ProbeFullPath != "" {
+	if len(b.ProbeFilePath) > 0 {
 		res = fmt.Sprintf("%s\n%s", "touch "+probeLockFile, res)
 		res = fmt.Sprintf("%s\n%s", res, "rm "+probeLockFile)
 	}
@@ -106,47 +214,29 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		"/driverkit/driverkit.sh",
 	}
 
+	labels := make(map[string]string, len(bp.jobOptions.Labels)+1)
+	for k, v := range bp.jobOptions.Labels {
+		labels[k] = v
+	}
+	labels[falcoBuilderUIDLabel] = string(uid)
 	commonMeta := metav1.ObjectMeta{
 		Name:      name,
 		Namespace: namespace,
-		Labels: map[string]string{
-			falcoBuilderUIDLabel: string(uid),
-		},
+		Labels:    labels,
 	}
 
-	// Prepare driver config template
-	bufFillDriverConfig := bytes.NewBuffer(nil)
-	err = renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.Build.ModuleFilePath, DriverName: c.DriverName, DeviceName: c.DeviceName})
-	if err != nil {
-		return err
-	}
-
-	// Prepare makefile template
-	objList, err := LoadMakefileObjList(c)
-	if err != nil {
-		return err
-	}
-	bufMakefile := bytes.NewBuffer(nil)
-	err = renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: builder.DriverDirectory, MakeObjList: objList})
-	if err != nil {
-		return err
-	}
-
-	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	data, err := supportFiles(c)
 	if err != nil {
 		return err
 	}
+	data["driverkit.sh"] = res
+	data["downloader.sh"] = waitForLockAndDescribe
+	data["unlock.sh"] = deleteLock
 
 	cm := &corev1.ConfigMap{
 		ObjectMeta: commonMeta,
-		Data: map[string]string{
-			"driverkit.sh":          res,
-			"kernel.config":         string(configDecoded),
-			"module-Makefile":       bufMakefile.String(),
-			"fill-driver-config.sh": bufFillDriverConfig.String(),
-			"downloader.sh":         waitForLockAndCat,
-			"unlock.sh":             deleteLock,
-		},
+		Data:       data,
+		BinaryData: binaryData,
 	}
 	// Construct environment variable array of corev1.EnvVar
 	var envs []corev1.EnvVar
@@ -163,53 +253,58 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 			},
 		)
 	}
-
-	builderImage := b.GetBuilderImage()
+	if b.OnlineMode {
+		envs = append(envs, corev1.EnvVar{Name: "MODE", Value: "online"})
+	} else {
+		envs = append(envs, corev1.EnvVar{Name: "MODE", Value: "local"})
+	}
 
 	secuContext := corev1.PodSecurityContext{
 		RunAsUser: &bp.runAsUser,
 	}
-	pod := &corev1.Pod{
+	job := &batchv1.Job{
 		ObjectMeta: commonMeta,
-		Spec: corev1.PodSpec{
+		Spec: batchv1.JobSpec{
+			// A failed build is not retried, its logs are what the user needs
+			BackoffLimit:          pointer.Int32Ptr(0),
 			ActiveDeadlineSeconds: pointer.Int64Ptr(deadline),
-			RestartPolicy:         corev1.RestartPolicyNever,
-			SecurityContext:       &secuContext,
-			ImagePullSecrets:      []corev1.LocalObjectReference{{Name: bp.imagePullSecret}},
-			Containers: []corev1.Container{
-				{
-					Name:            name,
-					Image:           builderImage,
-					Command:         buildCmd,
-					Env:             envs,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("1000m"),
-							corev1.ResourceMemory: resource.MustParse("2000Mi"),
-						},
-						Limits: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("4"),
-							corev1.ResourceMemory: resource.MustParse("4G"),
-						},
-					},
-					VolumeMounts: []corev1.VolumeMount{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					SecurityContext:    &secuContext,
+					ImagePullSecrets:   []corev1.LocalObjectReference{{Name: bp.imagePullSecret}},
+					ServiceAccountName: bp.jobOptions.ServiceAccount,
+					NodeSelector:       jobNodeSelector(bp.jobOptions.NodeSelector, b.Architecture),
+					Tolerations:        bp.jobOptions.Tolerations,
+					Containers: []corev1.Container{
 						{
-							Name:      "driverkit",
-							MountPath: "/driverkit",
-							ReadOnly:  true,
+							Name:            name,
+							Image:           builderImage,
+							Command:         buildCmd,
+							Env:             envs,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Resources:       bp.jobOptions.Resources,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "driverkit",
+									MountPath: "/driverkit",
+									ReadOnly:  true,
+								},
+							},
 						},
 					},
-				},
-			},
-			Volumes: []corev1.Volume{
-				{
-					Name: "driverkit",
-					VolumeSource: corev1.VolumeSource{
-						ConfigMap: &corev1.ConfigMapVolumeSource{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: cm.Name,
+					Volumes: []corev1.Volume{
+						{
+							Name: "driverkit",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: cm.Name,
+									},
+								},
 							},
 						},
 					},
@@ -218,22 +313,79 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		},
 	}
 
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
+	if store := bp.jobOptions.ResultStore; store != nil {
+		store.PrepareJob(job)
+	}
+
+	blog, err := newBuildLog(b)
+	if err != nil {
+		return err
+	}
+	defer blog.Close()
+
 	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
 	if err != nil {
 		return err
 	}
-	defer configClient.Delete(ctx, cm.Name, metav1.DeleteOptions{})
-	_, err = podClient.Create(ctx, pod, metav1.CreateOptions{})
+	// The build context could be already canceled when cleaning up
+	defer configClient.Delete(context.Background(), cm.Name, metav1.DeleteOptions{})
+	_, err = jobClient.Create(ctx, job, metav1.CreateOptions{})
 	if err != nil {
 		return err
 	}
-	defer podClient.Delete(ctx, pod.Name, metav1.DeleteOptions{})
-	return bp.copyModuleAndProbeFromPodWithUID(ctx, b, namespace, string(uid))
+	// Pods are owned by the Job, have them deleted too
+	var once sync.Once
+	deleteJob := func() {
+		once.Do(func() {
+			propagation := metav1.DeletePropagationBackground
+			if err := jobClient.Delete(context.Background(), job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+				logger.WithError(err).WithField("job", job.Name).Warn("could not delete the build job")
+			}
+		})
+	}
+	defer deleteJob()
+	// Deleting the job right away terminates the transfers from its pod, which do not follow ctx
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			logger.WithField("job", job.Name).Debug("context canceled, deleting the build job")
+			deleteJob()
+		case <-done:
+		}
+	}()
+	if err = bp.copyModuleAndProbeFromPodWithUID(ctx, b, namespace, string(uid), uploads, blog, manifest); err != nil {
+		if ctx.Err() != nil {
+			return blog.failed(fmt.Errorf("kubernetes build interrupted: %w", ctx.Err()))
+		}
+		return blog.failed(err)
+	}
+	if err = bp.waitForJobCompletion(ctx, namespace, job.Name); err != nil {
+		return blog.failed(err)
+	}
+	if err = verifyArtifacts(v, b, kr); err != nil {
+		return err
+	}
+	if store := bp.jobOptions.ResultStore; store != nil {
+		if err := store.Store(ctx, b); err != nil {
+			return err
+		}
+	}
+
+	if cache != nil {
+		if err := cache.store(cacheKey, b); err != nil {
+			logger.WithError(err).Warn("could not store artifacts into the build cache")
+		}
+	}
+	manifest.setHeaderSums(blog.downloadedHeaderSums())
+	if err := manifest.write(b, false); err != nil {
+		return err
+	}
+	return publish(ctx, publishers, manifest)
 }
 
-func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, build *builder.Build, namespace string, falcoBuilderUID string) error {
+func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context.Context, build *builder.Build, namespace string, falcoBuilderUID string, uploads map[string]string, blog *buildLog, manifest *Manifest) (err error) {
 	namespacedClient := bp.coreV1Client.Pods(namespace)
 	watch, err := namespacedClient.Watch(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("%s=%s", falcoBuilderUIDLabel, falcoBuilderUID),
@@ -241,16 +393,29 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	if err != nil {
 		return err
 	}
-	// Give it ten minutes to complete, if it doesn't give an error
-	// TODO(fntlnz): maybe pass this from the outside?
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer watch.Stop()
+	// The build itself is bounded by the deadline of the Job, the copies by the copy timeout
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	var logsDone chan struct{}
+	defer func() {
+		if logsDone == nil {
+			return
+		}
+		// The pod logs end with the build script, after the unlock: stop following them on failures
+		if err != nil {
+			cancel()
+		}
+		<-logsDone
+	}()
 	for {
 		select {
 		case <-ctx.Done():
 			return errors.New("module copy from pod interrupted before the copy was complete")
-		default:
-			event := <-watch.ResultChan()
+		case event, open := <-watch.ResultChan():
+			if !open {
+				return errors.New("pod watch closed before the copy was complete")
+			}
 			p, ok := event.Object.(*corev1.Pod)
 			if !ok {
 				logger.Error("unexpected type when watching pods")
@@ -259,19 +424,46 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 			if p.Status.Phase == corev1.PodPending {
 				continue
 			}
+			if p.Status.Phase == corev1.PodFailed {
+				if logsDone == nil {
+					bp.forwardPodLogs(ctx, p, false, blog)
+				}
+				err := fmt.Errorf("build pod %s failed: %s", p.Name, podFailureReason(p))
+				if exitCode, ok := podExitCode(p); ok {
+					return blog.scriptError(exitCode, err)
+				}
+				return err
+			}
 			if p.Status.Phase == corev1.PodRunning {
+				if digest := podImageDigest(p); len(digest) > 0 {
+					manifest.Image.Digest = digest
+				}
+				build.Emit(builder.Event{Type: builder.EventContainerStarted, Image: manifest.Image.Name})
+				logsDone = make(chan struct{})
+				go func() {
+					bp.forwardPodLogs(ctx, p, true, blog)
+					close(logsDone)
+				}()
+				if len(uploads) > 0 {
+					logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start uploading build inputs to pod")
+					if err := uploadFilesToPod(uploads, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name); err != nil {
+						return err
+					}
+				}
 				logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start downloading module and probe from pod")
-				if builder.ModuleFullPath != "" {
-					err = copySingleFileFromPod(build.ModuleOutPutFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ModuleFullPath, moduleLockFile)
+				if len(build.ModuleOutPutFilePath) > 0 {
+					build.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: build.ModuleOutPutFilePath})
+					err = copySingleFileFromPod(ctx, build.CopyTimeout, build.ModuleOutPutFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, build.ModulePath(), moduleLockFile)
 					if err != nil {
-						return err
+						return bp.copyError(ctx, p, blog, err)
 					}
 					logger.Info("Kernel Module extraction successful")
 				}
-				if builder.ProbeFullPath != "" {
-					err = copySingleFileFromPod(build.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ProbeFullPath, probeLockFile)
+				if len(build.ProbeFilePath) > 0 {
+					build.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: build.ProbeFilePath})
+					err = copySingleFileFromPod(ctx, build.CopyTimeout, build.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, build.ProbePath(), probeLockFile)
 					if err != nil {
-						return err
+						return bp.copyError(ctx, p, blog, err)
 					}
 					logger.Info("Probe Module extraction successful")
 				}
@@ -286,77 +478,203 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	}
 }
 
-func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
-	options := &exec.ExecOptions{
-		PodClient: podClient,
-		Config:    clientConfig,
-		StreamOptions: exec.StreamOptions{
-			IOStreams: genericclioptions.IOStreams{
-				Out:    bytes.NewBuffer([]byte{}),
-				ErrOut: bytes.NewBuffer([]byte{}),
-			},
-			Stdin:     false,
-			Namespace: pod.Namespace,
-			PodName:   pod.Name,
-		},
-		Command: []string{
-			"/bin/bash",
-			"/driverkit/unlock.sh",
-		},
-		Executor: &exec.DefaultRemoteExecutor{},
-	}
-	if err := options.Validate(); err != nil {
+// copyError tells apart a failed transfer from a build script that exited before producing the artifact.
+func (bp *KubernetesBuildProcessor) copyError(ctx context.Context, p *corev1.Pod, blog *buildLog, err error) error {
+	current, getErr := bp.coreV1Client.Pods(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+	if getErr != nil {
 		return err
 	}
-	if err := options.Run(); err != nil {
+	if exitCode, ok := podExitCode(current); ok {
+		return blog.scriptError(exitCode, err)
+	}
+	return err
+}
+
+// forwardPodLogs forwards the output of the build pod to the build log,
+// following it until the build script exits when follow is true.
+func (bp *KubernetesBuildProcessor) forwardPodLogs(ctx context.Context, p *corev1.Pod, follow bool, blog *buildLog) {
+	stream, err := bp.coreV1Client.Pods(p.Namespace).GetLogs(p.Name, &corev1.PodLogOptions{Follow: follow}).Stream(ctx)
+	if err != nil {
+		logger.WithError(err).WithField("pod", p.Name).Warn("could not get the build pod logs")
+		return
+	}
+	defer stream.Close()
+	blog.forward(stream)
+}
+
+// waitForJobCompletion watches the build Job until it either completes or fails.
+func (bp *KubernetesBuildProcessor) waitForJobCompletion(ctx context.Context, namespace string, name string) error {
+	watch, err := bp.batchV1Client.Jobs(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("metadata.name=%s", name),
+	})
+	if err != nil {
 		return err
 	}
+	defer watch.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.New("build job interrupted before completion")
+		case event, open := <-watch.ResultChan():
+			if !open {
+				return errors.New("job watch closed before the build job completed")
+			}
+			j, ok := event.Object.(*batchv1.Job)
+			if !ok {
+				logger.Error("unexpected type when watching jobs")
+				continue
+			}
+			if done, err := jobFinished(j); done {
+				if err == nil {
+					logger.WithField("job", j.Name).Debug("build job completed")
+				}
+				return err
+			}
+		}
+	}
+}
 
-	return nil
+// jobFinished tells whether the Job reached a final condition, and an error if that is a failure.
+func jobFinished(j *batchv1.Job) (bool, error) {
+	for _, c := range j.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return true, fmt.Errorf("build job %s failed: %s: %s", j.Name, c.Reason, c.Message)
+		}
+	}
+	return false, nil
 }
 
-func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
-	if len(namespace) == 0 {
-		return errors.New("need a namespace to copy from pod")
+func podFailureReason(p *corev1.Pod) string {
+	for _, cs := range p.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil {
+			return fmt.Sprintf("container %s exited with code %d: %s", cs.Name, t.ExitCode, t.Reason)
+		}
 	}
+	return p.Status.Reason
+}
 
-	if len(podName) == 0 {
-		return errors.New("need a podName to copy from pod")
+// podImageDigest returns the digest of the image the build container runs, as reported by the kubelet.
+func podImageDigest(p *corev1.Pod) string {
+	for _, cs := range p.Status.ContainerStatuses {
+		if len(cs.ImageID) > 0 {
+			return strings.TrimPrefix(cs.ImageID, "docker-pullable://")
+		}
 	}
+	return ""
+}
 
-	out, err := os.Create(dstFile)
-	if err != nil {
-		return err
+// podExitCode returns the exit code of the build container, when it terminated with an error.
+func podExitCode(p *corev1.Pod) (int, bool) {
+	for _, cs := range p.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			return int(t.ExitCode), true
+		}
 	}
-	defer out.Close()
+	return 0, false
+}
 
-	options := &exec.ExecOptions{
-		PodClient: podClient,
-		Config:    clientConfig,
-		StreamOptions: exec.StreamOptions{
-			IOStreams: genericclioptions.IOStreams{
-				Out:    out,
-				ErrOut: bytes.NewBuffer([]byte{}), //TODO(fntlnz): necessary to deal with errors here?
-			},
-			Stdin:     false,
-			Namespace: namespace,
-			PodName:   podName,
-		},
+// jobNodeSelector returns the node selector of the build pods.
+// Unless told otherwise, builds land on nodes of the target architecture,
+// so that no emulation is involved.
+func jobNodeSelector(nodeSelector map[string]string, arch string) map[string]string {
+	selector := make(map[string]string, len(nodeSelector)+1)
+	for k, v := range nodeSelector {
+		selector[k] = v
+	}
+	if _, ok := selector[corev1.LabelArchStable]; !ok && len(arch) > 0 {
+		selector[corev1.LabelArchStable] = arch
+	}
+	return selector
+}
 
-		Command: []string{
-			"/bin/bash",
-			"/driverkit/downloader.sh",
-			fileNameToCopy,
-			lockFilename,
-		},
-		Executor: &exec.DefaultRemoteExecutor{},
+// ParseToleration parses a toleration in the same form of the kubectl taint command,
+// ie. key[=value]:effect, where both the value and the effect are optional.
+// A toleration without value tolerates any value of the key.
+func ParseToleration(s string) (corev1.Toleration, error) {
+	t := corev1.Toleration{Operator: corev1.TolerationOpExists}
+	spec := s
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		t.Effect = corev1.TaintEffect(spec[i+1:])
+		spec = spec[:i]
+		switch t.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return corev1.Toleration{}, fmt.Errorf("invalid toleration %q: unknown effect %q", s, t.Effect)
+		}
 	}
-	if err := options.Validate(); err != nil {
-		return err
+	if i := strings.Index(spec, "="); i >= 0 {
+		t.Operator = corev1.TolerationOpEqual
+		t.Value = spec[i+1:]
+		spec = spec[:i]
 	}
-	if err := options.Run(); err != nil {
-		return err
+	if len(spec) == 0 {
+		return corev1.Toleration{}, fmt.Errorf("invalid toleration %q: missing key", s)
+	}
+	t.Key = spec
+	return t, nil
+}
+
+// podInputFiles maps the file names the build script expects into its work directory
+// to the local files backing them: the module sources archive and, when offline, the kernel header packages.
+func podInputFiles(b *builder.Build, localKernelFiles []string) (map[string]string, error) {
+	inputs := make(map[string]string)
+	// The module sources may also be a plain reference, that templates download on their own
+	if info, err := os.Stat(b.ModuleFilePath); err == nil && info.Mode().IsRegular() {
+		inputs[builder.ModuleArchiveFileName] = b.ModuleFilePath
+	}
+	for i, kernelFile := range localKernelFiles {
+		if _, err := os.Stat(kernelFile); err != nil {
+			return nil, err
+		}
+		inputs[builder.KernelFileName(i)] = kernelFile
 	}
+	return inputs, nil
+}
+
+// splitPodInputs puts the smallest inputs into the ConfigMap binary data, up to configMapInputsMaxBytes,
+// and leaves the others to be streamed into the pod.
+func splitPodInputs(inputs map[string]string) (map[string][]byte, map[string]string, error) {
+	binaryData := make(map[string][]byte)
+	uploads := make(map[string]string)
+
+	names := sortedKeys(inputs)
+	sizes := make(map[string]int64, len(names))
+	for _, name := range names {
+		info, err := os.Stat(inputs[name])
+		if err != nil {
+			return nil, nil, err
+		}
+		sizes[name] = info.Size()
+	}
+	sort.SliceStable(names, func(i, j int) bool { return sizes[names[i]] < sizes[names[j]] })
+
+	var total int64
+	for _, name := range names {
+		if total+sizes[name] > configMapInputsMaxBytes {
+			uploads[name] = inputs[name]
+			continue
+		}
+		data, err := os.ReadFile(inputs[name])
+		if err != nil {
+			return nil, nil, err
+		}
+		binaryData[name] = data
+		total += sizes[name]
+	}
+	return binaryData, uploads, nil
+}
 
-	return nil
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,150 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// NodeKernel is a distinct kernel that runs on one or more nodes of a cluster.
+type NodeKernel struct {
+	Target        builder.Type
+	KernelRelease string
+	// KernelVersion is the build number of the kernel, the #<n> of uname -v
+	KernelVersion string
+	Architecture  string
+	// Nodes lists the names of the nodes running this kernel
+	Nodes []string
+}
+
+// osImageTargets maps the prefixes of the node OS images, as reported by the kubelet, to their target.
+// Longer prefixes come first, so that eg. Amazon Linux 2022 does not match Amazon Linux 2.
+var osImageTargets = []struct {
+	prefix string
+	target builder.Type
+}{
+	{"amazon linux 2022", builder.TargetTypeAmazonLinux2022},
+	{"amazon linux 2", builder.TargetTypeAmazonLinux2},
+	{"amazon linux", builder.TargetTypeAmazonLinux},
+	{"ubuntu", builder.TargetTypeUbuntu},
+	{"debian", builder.TargetTypeDebian},
+	{"centos", builder.TargetTypeCentos},
+	{"fedora", builder.TargetTypeFedora},
+	{"red hat enterprise linux", builder.TargetTypeRedhat},
+	{"rocky linux", builder.TargetTypeRocky},
+	{"almalinux", builder.TargetTypeAlma},
+	{"opensuse", builder.TargetTypeOpenSUSE},
+	{"suse linux enterprise", builder.TargetTypeOpenSUSE},
+	{"vmware photon os", builder.TargetTypePhoton},
+	{"arch linux", builder.TargetTypeArchlinux},
+	{"flatcar container linux", builder.TargetTypeFlatcar},
+	{"bottlerocket", builder.TargetTypeBottlerocket},
+}
+
+var osImageVersionRegexp = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+var kernelBuildRegexp = regexp.MustCompile(`#(\d+)`)
+
+// targetFromOSImage returns the target of a node OS image, eg. "Ubuntu 20.04.4 LTS".
+func targetFromOSImage(osImage string) (builder.Type, bool) {
+	lower := strings.ToLower(osImage)
+	for _, t := range osImageTargets {
+		if strings.HasPrefix(lower, t.prefix) {
+			return t.target, true
+		}
+	}
+	return "", false
+}
+
+// nodeKernelRelease returns the kernel release driverkit expects for the node.
+// That is the running kernel, but for flatcar whose builds are keyed by the OS release.
+func nodeKernelRelease(target builder.Type, info corev1.NodeSystemInfo) (string, error) {
+	if target == builder.TargetTypeFlatcar {
+		version := osImageVersionRegexp.FindString(info.OSImage)
+		if len(version) == 0 {
+			return "", fmt.Errorf("no flatcar release found into OS image %q", info.OSImage)
+		}
+		return version, nil
+	}
+	// The kernel release comes first, when the build string is reported along with it
+	if fields := strings.Fields(info.KernelVersion); len(fields) > 0 {
+		return fields[0], nil
+	}
+	return "", fmt.Errorf("no kernel release reported")
+}
+
+// nodeKernelVersion returns the kernel build number of the node, eg. 20 out of "5.15.0-1019-aws #20-Ubuntu SMP".
+func nodeKernelVersion(info corev1.NodeSystemInfo) (string, error) {
+	m := kernelBuildRegexp.FindStringSubmatch(info.KernelVersion)
+	if m == nil {
+		return "", fmt.Errorf("no kernel version (the #<n> of uname -v) found into node kernel %q", info.KernelVersion)
+	}
+	return m[1], nil
+}
+
+// ListNodeKernels lists the nodes of the cluster and groups them by distinct kernel.
+//
+// It also returns the nodes whose kernel cannot be built by driverkit, along with the reason.
+func ListNodeKernels(ctx context.Context, nodesClient v1.NodesGetter) ([]NodeKernel, map[string]error, error) {
+	nodes, err := nodesClient.Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	kernels, skipped := nodeKernels(nodes.Items)
+	return kernels, skipped, nil
+}
+
+func nodeKernels(nodes []corev1.Node) ([]NodeKernel, map[string]error) {
+	skipped := make(map[string]error)
+	byKey := make(map[string]*NodeKernel)
+	for _, n := range nodes {
+		info := n.Status.NodeInfo
+		target, ok := targetFromOSImage(info.OSImage)
+		if !ok {
+			skipped[n.Name] = fmt.Errorf("unsupported OS image %q", info.OSImage)
+			continue
+		}
+		kr, err := nodeKernelRelease(target, info)
+		if err != nil {
+			skipped[n.Name] = err
+			continue
+		}
+		kv, err := nodeKernelVersion(info)
+		if err != nil {
+			skipped[n.Name] = err
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s/%s", target, kr, kv, info.Architecture)
+		nk, ok := byKey[key]
+		if !ok {
+			nk = &NodeKernel{
+				Target:        target,
+				KernelRelease: kr,
+				KernelVersion: kv,
+				Architecture:  info.Architecture,
+			}
+			byKey[key] = nk
+		}
+		nk.Nodes = append(nk.Nodes, n.Name)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kernels := make([]NodeKernel, 0, len(keys))
+	for _, k := range keys {
+		nk := byKey[k]
+		sort.Strings(nk.Nodes)
+		kernels = append(kernels, *nk)
+	}
+	return kernels, skipped
+}
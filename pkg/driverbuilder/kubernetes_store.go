@@ -0,0 +1,265 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	resultTargetLabel        = "org.falcosecurity/driverkit-target"
+	resultKernelReleaseLabel = "org.falcosecurity/driverkit-kernelrelease"
+	resultArchLabel          = "org.falcosecurity/driverkit-arch"
+	// resultKernelReleaseAnnotation keeps the exact kernel release,
+	// since label values cannot hold every character a kernel release may have.
+	resultKernelReleaseAnnotation = resultKernelReleaseLabel
+
+	// ResultStoreMountPath is where the results volume is mounted into the build pods.
+	ResultStoreMountPath = "/driverkit-results"
+
+	// maxResultObjectSize is the size limit of the data of a ConfigMap or of a Secret, as enforced by the API server.
+	maxResultObjectSize = 1 << 20
+)
+
+// ResultStore persists the artifacts of kubernetes builds into the cluster,
+// so that in-cluster consumers can fetch them without a client machine.
+type ResultStore interface {
+	// Script returns the commands the build pod runs once the artifacts are built.
+	Script(b *builder.Build) string
+	// PrepareJob is called before submitting the build job, eg. to mount volumes into it.
+	PrepareJob(job *batchv1.Job)
+	// Store is called once the artifacts have been downloaded to the output paths.
+	Store(ctx context.Context, b *builder.Build) error
+}
+
+// NewResultStore returns the ResultStore described by spec, which is one of:
+//   - pvc://<claim>: the artifacts are copied by the build pod into the given PersistentVolumeClaim,
+//     under <target>/<kernelrelease>/<arch>
+//   - configmap or secret: the artifacts are stored as binary data of a ConfigMap (Secret),
+//     into the build namespace, as long as they fit into its 1MiB limit
+//   - http(s)://<url>: the artifacts are uploaded with a PUT to <url>/<target>/<kernelrelease>/<arch>/<artifact>
+func NewResultStore(spec string, kc kubernetes.Interface, namespace string) (ResultStore, error) {
+	switch {
+	case strings.HasPrefix(spec, "pvc://"):
+		claim := strings.TrimPrefix(spec, "pvc://")
+		if len(claim) == 0 {
+			return nil, fmt.Errorf("missing claim name into result store %q", spec)
+		}
+		return &pvcResultStore{claim: claim}, nil
+	case spec == "configmap":
+		return &objectResultStore{kc: kc, namespace: namespace}, nil
+	case spec == "secret":
+		return &objectResultStore{kc: kc, namespace: namespace, secret: true}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return &httpResultStore{url: strings.TrimSuffix(spec, "/"), client: http.DefaultClient}, nil
+	}
+	return nil, fmt.Errorf("unknown result store %q, expected one of pvc://<claim>, configmap, secret, http(s)://<url>", spec)
+}
+
+// resultArtifacts maps the stored artifact names to the paths they were downloaded to.
+func resultArtifacts(b *builder.Build) map[string]string {
+	artifacts := make(map[string]string)
+	if len(b.ModuleOutPutFilePath) > 0 {
+		artifacts[builder.ModuleFileName] = b.ModuleOutPutFilePath
+	}
+	if len(b.ProbeFilePath) > 0 {
+		artifacts[builder.ProbeFileName] = b.ProbeFilePath
+	}
+	return artifacts
+}
+
+func resultPath(b *builder.Build) string {
+	return path.Join(b.TargetType.String(), b.KernelRelease, b.Architecture)
+}
+
+var (
+	invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+	invalidNameChars       = regexp.MustCompile(`[^a-z0-9.-]`)
+)
+
+// labelValue makes s a valid label value: at most 63 characters among alphanumerics, '-', '_' and '.',
+// starting and ending with an alphanumeric one.
+func labelValue(s string) string {
+	s = invalidLabelValueChars.ReplaceAllString(s, "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "._-")
+}
+
+func resultLabels(b *builder.Build) map[string]string {
+	return map[string]string{
+		resultTargetLabel:        labelValue(b.TargetType.String()),
+		resultKernelReleaseLabel: labelValue(b.KernelRelease),
+		resultArchLabel:          labelValue(b.Architecture),
+	}
+}
+
+// resultObjectName returns the name of the ConfigMap (Secret) holding the artifacts of the build.
+func resultObjectName(b *builder.Build) string {
+	name := fmt.Sprintf("driverkit-%s-%s-%s", b.TargetType, b.KernelRelease, b.Architecture)
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.Trim(name, ".-")
+}
+
+type pvcResultStore struct {
+	claim string
+}
+
+func (s *pvcResultStore) PrepareJob(job *batchv1.Job) {
+	spec := &job.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "driverkit-results",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: s.claim},
+		},
+	})
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      "driverkit-results",
+			MountPath: ResultStoreMountPath,
+		})
+	}
+}
+
+func (s *pvcResultStore) Script(b *builder.Build) string {
+	dir := path.Join(ResultStoreMountPath, resultPath(b))
+	script := fmt.Sprintf("mkdir -p %s\n", dir)
+	if len(b.ModuleOutPutFilePath) > 0 {
+		script += fmt.Sprintf("cp %s %s\n", b.ModulePath(), path.Join(dir, builder.ModuleFileName))
+	}
+	if len(b.ProbeFilePath) > 0 {
+		script += fmt.Sprintf("cp %s %s\n", b.ProbePath(), path.Join(dir, builder.ProbeFileName))
+	}
+	return script
+}
+
+func (s *pvcResultStore) Store(_ context.Context, b *builder.Build) error {
+	logger.WithField("claim", s.claim).WithField("path", resultPath(b)).Info("artifacts stored into the cluster")
+	return nil
+}
+
+// objectResultStore keeps the artifacts as binary data of a ConfigMap or of a Secret.
+type objectResultStore struct {
+	kc        kubernetes.Interface
+	namespace string
+	secret    bool
+}
+
+func (s *objectResultStore) Script(_ *builder.Build) string {
+	return ""
+}
+
+func (s *objectResultStore) PrepareJob(_ *batchv1.Job) {}
+
+func (s *objectResultStore) Store(ctx context.Context, b *builder.Build) error {
+	kind := "configmap"
+	if s.secret {
+		kind = "secret"
+	}
+	data := make(map[string][]byte)
+	size := 0
+	for name, p := range resultArtifacts(b) {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		data[name] = content
+		size += len(name) + len(content)
+	}
+	if size > maxResultObjectSize {
+		return fmt.Errorf("artifacts too large for the %s result store: %d bytes, while a %s holds at most %d bytes; use the pvc:// or the http(s):// result store instead", kind, size, kind, maxResultObjectSize)
+	}
+	meta := metav1.ObjectMeta{
+		Name:      resultObjectName(b),
+		Namespace: s.namespace,
+		Labels:    resultLabels(b),
+		Annotations: map[string]string{
+			resultKernelReleaseAnnotation: b.KernelRelease,
+		},
+	}
+
+	var err error
+	if s.secret {
+		err = s.storeSecret(ctx, &corev1.Secret{ObjectMeta: meta, Data: data})
+	} else {
+		err = s.storeConfigMap(ctx, &corev1.ConfigMap{ObjectMeta: meta, BinaryData: data})
+	}
+	if err != nil {
+		return err
+	}
+	logger.WithField(kind, meta.Name).WithField("namespace", s.namespace).Info("artifacts stored into the cluster")
+	return nil
+}
+
+// Rebuilds replace the artifacts of a previous build for the same kernel
+func (s *objectResultStore) storeConfigMap(ctx context.Context, cm *corev1.ConfigMap) error {
+	client := s.kc.CoreV1().ConfigMaps(s.namespace)
+	_, err := client.Create(ctx, cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+func (s *objectResultStore) storeSecret(ctx context.Context, secret *corev1.Secret) error {
+	client := s.kc.CoreV1().Secrets(s.namespace)
+	_, err := client.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// httpResultStore uploads the artifacts to an artifact server reachable from the cluster.
+type httpResultStore struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpResultStore) Script(_ *builder.Build) string {
+	return ""
+}
+
+func (s *httpResultStore) PrepareJob(_ *batchv1.Job) {}
+
+func (s *httpResultStore) Store(ctx context.Context, b *builder.Build) error {
+	for name, p := range resultArtifacts(b) {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		url := fmt.Sprintf("%s/%s/%s", s.url, resultPath(b), name)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("upload of %s failed: %s", url, resp.Status)
+		}
+		logger.WithField("url", url).Info("artifact stored into the cluster")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package driverbuilder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newStoreTestBuild(t *testing.T) *builder.Build {
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:           builder.TargetTypeUbuntu,
+		KernelRelease:        "5.10.133+",
+		Architecture:         "amd64",
+		ModuleOutPutFilePath: filepath.Join(dir, "falco.ko"),
+	}
+	assert.NilError(t, os.WriteFile(b.ModuleOutPutFilePath, []byte("module"), 0644))
+	return b
+}
+
+func TestConfigMapResultStore(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.NewSimpleClientset()
+	b := newStoreTestBuild(t)
+	store, err := NewResultStore("configmap", kc, "builds")
+	assert.NilError(t, err)
+
+	assert.NilError(t, store.Store(ctx, b))
+	// Storing again replaces the previous artifacts
+	assert.NilError(t, os.WriteFile(b.ModuleOutPutFilePath, []byte("rebuilt module"), 0644))
+	assert.NilError(t, store.Store(ctx, b))
+
+	cms, err := kc.CoreV1().ConfigMaps("builds").List(ctx, metav1.ListOptions{
+		LabelSelector: resultTargetLabel + "=ubuntu," + resultKernelReleaseLabel + "=5.10.133," + resultArchLabel + "=amd64",
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(cms.Items))
+	cm := cms.Items[0]
+	assert.Equal(t, "driverkit-ubuntu-5.10.133--amd64", cm.Name)
+	assert.Equal(t, "5.10.133+", cm.Annotations[resultKernelReleaseAnnotation])
+	assert.Equal(t, "rebuilt module", string(cm.BinaryData[builder.ModuleFileName]))
+}
+
+func TestSecretResultStore(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.NewSimpleClientset()
+	b := newStoreTestBuild(t)
+	store, err := NewResultStore("secret", kc, "builds")
+	assert.NilError(t, err)
+	assert.NilError(t, store.Store(ctx, b))
+
+	secret, err := kc.CoreV1().Secrets("builds").Get(ctx, resultObjectName(b), metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, "module", string(secret.Data[builder.ModuleFileName]))
+}
+
+func TestObjectResultStoreSizeLimit(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.NewSimpleClientset()
+	b := newStoreTestBuild(t)
+	assert.NilError(t, os.WriteFile(b.ModuleOutPutFilePath, make([]byte, maxResultObjectSize+1), 0644))
+	for _, spec := range []string{"configmap", "secret"} {
+		store, err := NewResultStore(spec, kc, "builds")
+		assert.NilError(t, err)
+		assert.ErrorContains(t, store.Store(ctx, b), "use the pvc:// or the http(s):// result store instead")
+	}
+	cms, err := kc.CoreV1().ConfigMaps("builds").List(ctx, metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(cms.Items))
+}
+
+func TestPVCResultStore(t *testing.T) {
+	b := newStoreTestBuild(t)
+	store, err := NewResultStore("pvc://drivers", nil, "builds")
+	assert.NilError(t, err)
+
+	job := &batchv1.Job{}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "builder"}}
+	store.PrepareJob(job)
+	assert.Equal(t, "drivers", job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, ResultStoreMountPath, job.Spec.Template.Spec.Containers[0].VolumeMounts[0].MountPath)
+	assert.Equal(t, "mkdir -p /driverkit-results/ubuntu/5.10.133+/amd64\ncp /tmp/driver/module.ko /driverkit-results/ubuntu/5.10.133+/amd64/module.ko\n", store.Script(b))
+}
+
+func TestHTTPResultStore(t *testing.T) {
+	uploads := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploads[r.Method+" "+r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	b := newStoreTestBuild(t)
+	store, err := NewResultStore(srv.URL+"/drivers/", nil, "")
+	assert.NilError(t, err)
+	assert.NilError(t, store.Store(context.Background(), b))
+	assert.DeepEqual(t, map[string]string{"PUT /drivers/ubuntu/5.10.133+/amd64/module.ko": "module"}, uploads)
+}
+
+func TestUnknownResultStore(t *testing.T) {
+	_, err := NewResultStore("s3://bucket", nil, "")
+	assert.ErrorContains(t, err, "unknown result store")
+}
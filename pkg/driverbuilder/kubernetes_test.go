@@ -0,0 +1,127 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSplitPodInputs(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small")
+	large := filepath.Join(dir, "large")
+	assert.NilError(t, os.WriteFile(small, []byte("module"), 0644))
+	assert.NilError(t, os.WriteFile(large, make([]byte, configMapInputsMaxBytes), 0644))
+
+	binaryData, uploads, err := splitPodInputs(map[string]string{
+		"kernel-module.tar.gz": small,
+		"kernel0":              large,
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string][]byte{"kernel-module.tar.gz": []byte("module")}, binaryData)
+	assert.DeepEqual(t, map[string]string{"kernel0": large}, uploads)
+}
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected corev1.Toleration
+	}{
+		{"dedicated=builders:NoSchedule", corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "builders", Effect: corev1.TaintEffectNoSchedule}},
+		{"dedicated:NoExecute", corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}},
+		{"dedicated=builders", corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "builders"}},
+		{"dedicated", corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+	}
+	for _, tt := range tests {
+		got, err := ParseToleration(tt.in)
+		assert.NilError(t, err, tt.in)
+		assert.DeepEqual(t, tt.expected, got)
+	}
+
+	_, err := ParseToleration("dedicated:Sometimes")
+	assert.ErrorContains(t, err, "unknown effect")
+	_, err = ParseToleration("=builders")
+	assert.ErrorContains(t, err, "missing key")
+}
+
+func TestJobNodeSelector(t *testing.T) {
+	assert.DeepEqual(t, map[string]string{corev1.LabelArchStable: "arm64"}, jobNodeSelector(nil, "arm64"))
+
+	// An explicit architecture selector wins over the build one
+	given := map[string]string{corev1.LabelArchStable: "amd64", "pool": "builders"}
+	assert.DeepEqual(t, given, jobNodeSelector(given, "arm64"))
+}
+
+func TestNodeKernels(t *testing.T) {
+	node := func(name, osImage, kernel, arch string) corev1.Node {
+		n := corev1.Node{}
+		n.Name = name
+		n.Status.NodeInfo = corev1.NodeSystemInfo{OSImage: osImage, KernelVersion: kernel, Architecture: arch}
+		return n
+	}
+	kernels, skipped := nodeKernels([]corev1.Node{
+		node("b", "Ubuntu 20.04.4 LTS", "5.15.0-1019-aws #20~20.04.1-Ubuntu SMP", "amd64"),
+		node("a", "Ubuntu 20.04.4 LTS", "5.15.0-1019-aws #20~20.04.1-Ubuntu SMP", "amd64"),
+		node("c", "Ubuntu 20.04.4 LTS", "5.15.0-1019-aws #20~20.04.1-Ubuntu SMP", "arm64"),
+		node("f", "Ubuntu 20.04.4 LTS", "5.15.0-1019-aws #21~20.04.1-Ubuntu SMP", "arm64"),
+		node("d", "Flatcar Container Linux by Kinvolk 3139.2.0 (Oklo)", "5.15.54-flatcar #1 SMP", "amd64"),
+		node("e", "Container-Optimized OS from Google", "5.10.133+ #1 SMP", "amd64"),
+		node("g", "Ubuntu 20.04.4 LTS", "5.15.0-1019-aws", "amd64"),
+	})
+	assert.DeepEqual(t, []NodeKernel{
+		{Target: builder.TargetTypeFlatcar, KernelRelease: "3139.2.0", KernelVersion: "1", Architecture: "amd64", Nodes: []string{"d"}},
+		{Target: builder.TargetTypeUbuntu, KernelRelease: "5.15.0-1019-aws", KernelVersion: "20", Architecture: "amd64", Nodes: []string{"a", "b"}},
+		{Target: builder.TargetTypeUbuntu, KernelRelease: "5.15.0-1019-aws", KernelVersion: "20", Architecture: "arm64", Nodes: []string{"c"}},
+		{Target: builder.TargetTypeUbuntu, KernelRelease: "5.15.0-1019-aws", KernelVersion: "21", Architecture: "arm64", Nodes: []string{"f"}},
+	}, kernels)
+	assert.Equal(t, 2, len(skipped))
+	assert.ErrorContains(t, skipped["e"], "unsupported OS image")
+	assert.ErrorContains(t, skipped["g"], "no kernel version")
+}
+
+func TestExtractVerifiedFile(t *testing.T) {
+	content := []byte("kernel module")
+	sum := sha256.Sum256(content)
+	info, err := parsePodFileInfo(fmt.Sprintf("%s %d\n", hex.EncodeToString(sum[:]), len(content)))
+	assert.NilError(t, err)
+
+	archive := func() io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "module.ko", Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		assert.NilError(t, err)
+		assert.NilError(t, tw.Close())
+		return &buf
+	}
+
+	dst := filepath.Join(t.TempDir(), "falco.ko")
+	assert.NilError(t, extractVerifiedFile(archive(), dst, info))
+	got, err := os.ReadFile(dst)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, content, got)
+
+	corrupted := info
+	corrupted.sha256 = strings.Repeat("0", sha256.Size*2)
+	assert.ErrorContains(t, extractVerifiedFile(archive(), dst+".corrupted", corrupted), "checksum mismatch")
+	_, err = os.Stat(dst + ".corrupted")
+	assert.Assert(t, os.IsNotExist(err), "a corrupted transfer must not leave the artifact around")
+
+	truncated := info
+	truncated.size++
+	assert.ErrorContains(t, extractVerifiedFile(archive(), dst, truncated), "size mismatch")
+
+	_, err = parsePodFileInfo("+ sha256sum /tmp/driver/module.ko")
+	assert.ErrorContains(t, err, "unexpected file info")
+}
@@ -0,0 +1,275 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/kubectl/pkg/cmd/exec"
+)
+
+// podTransferAttempts is how many times a transfer from the pod is tried before giving up.
+const podTransferAttempts = 3
+
+// podFileInfo is what the pod reports about an artifact before transferring it.
+type podFileInfo struct {
+	sha256 string
+	size   int64
+}
+
+// execInPod runs cmd into the pod, wiring its standard input and output to the given ones.
+// On failure, the returned error carries the standard error of the command.
+func execInPod(podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, cmd []string, in io.Reader, out io.Writer) error {
+	stderr := bytes.NewBuffer(nil)
+	if out == nil {
+		out = io.Discard
+	}
+	options := &exec.ExecOptions{
+		PodClient: podClient,
+		Config:    clientConfig,
+		StreamOptions: exec.StreamOptions{
+			IOStreams: genericclioptions.IOStreams{
+				In:     in,
+				Out:    out,
+				ErrOut: stderr,
+			},
+			Stdin:     in != nil,
+			Namespace: namespace,
+			PodName:   podName,
+		},
+		Command:  cmd,
+		Executor: &exec.DefaultRemoteExecutor{},
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	if err := options.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// execInPodOutput is execInPod returning the standard output of cmd, or the error of ctx as soon as ctx is done.
+// The exec does not follow any context: once ctx is done, it is left behind, to end along with the pod.
+func execInPodOutput(ctx context.Context, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, cmd []string) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var out bytes.Buffer
+		err := execInPod(podClient, clientConfig, namespace, podName, cmd, nil, &out)
+		done <- result{out.String(), err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.out, r.err
+	}
+}
+
+func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
+	return execInPod(podClient, clientConfig, pod.Namespace, pod.Name, []string{"/bin/bash", "/driverkit/unlock.sh"}, nil, nil)
+}
+
+// copySingleFileFromPod transfers fileNameToCopy out of the pod, once lockFilename is gone, into dstFile.
+//
+// The file travels into a tar stream, like kubectl cp does, and it is checked against
+// the size and the SHA-256 reported by the pod; failed transfers are retried.
+// Each transfer, not accounting for the wait of the lock, is bounded by timeout (unbounded if zero).
+func copySingleFileFromPod(ctx context.Context, timeout time.Duration, dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
+	if len(namespace) == 0 {
+		return errors.New("need a namespace to copy from pod")
+	}
+
+	if len(podName) == 0 {
+		return errors.New("need a podName to copy from pod")
+	}
+
+	var err error
+	for attempt := 1; attempt <= podTransferAttempts; attempt++ {
+		if err = copySingleFileFromPodOnce(ctx, timeout, dstFile, podClient, clientConfig, namespace, podName, fileNameToCopy, lockFilename); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.WithError(err).
+			WithField("file", fileNameToCopy).
+			WithField("attempt", attempt).
+			Warn("transfer from pod failed")
+		if attempt < podTransferAttempts {
+			backoff := time.NewTimer(time.Duration(attempt) * time.Second)
+			select {
+			case <-ctx.Done():
+				backoff.Stop()
+				return ctx.Err()
+			case <-backoff.C:
+			}
+		}
+	}
+	return fmt.Errorf("could not copy %s from pod %s: %v", fileNameToCopy, podName, err)
+}
+
+func copySingleFileFromPodOnce(ctx context.Context, timeout time.Duration, dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
+	// The downloader waits for the lock to be released, for as long as the build takes
+	infoOut, err := execInPodOutput(ctx, podClient, clientConfig, namespace, podName, []string{
+		"/bin/bash",
+		"/driverkit/downloader.sh",
+		fileNameToCopy,
+		lockFilename,
+	})
+	if err != nil {
+		return err
+	}
+	info, err := parsePodFileInfo(infoOut)
+	if err != nil {
+		return err
+	}
+
+	copyCtx, cancel := builder.WithTimeout(ctx, timeout)
+	defer cancel()
+	pr, pw := io.Pipe()
+	// The exec does not follow any context, break the stream instead
+	go func() {
+		<-copyCtx.Done()
+		if copyCtx.Err() == context.DeadlineExceeded {
+			pr.CloseWithError(fmt.Errorf("transfer timed out after %s", timeout))
+		} else {
+			pr.CloseWithError(copyCtx.Err())
+		}
+	}()
+	go func() {
+		pw.CloseWithError(execInPod(podClient, clientConfig, namespace, podName, []string{
+			"tar",
+			"-cf",
+			"-",
+			"-C",
+			path.Dir(fileNameToCopy),
+			path.Base(fileNameToCopy),
+		}, nil, pw))
+	}()
+	err = extractVerifiedFile(pr, dstFile, info)
+	// Unblock the exec, in case the extraction stopped early
+	pr.CloseWithError(err)
+	return err
+}
+
+// parsePodFileInfo parses the "<sha256> <size>" line printed by the downloader script.
+func parsePodFileInfo(s string) (podFileInfo, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+		return podFileInfo{}, fmt.Errorf("unexpected file info from pod: %q", s)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return podFileInfo{}, fmt.Errorf("unexpected file size from pod: %q", s)
+	}
+	return podFileInfo{sha256: fields[0], size: size}, nil
+}
+
+// extractVerifiedFile reads the single file of the tar stream into dstFile,
+// only when both its size and its SHA-256 match the expected ones.
+func extractVerifiedFile(r io.Reader, dstFile string, info podFileInfo) error {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("could not read the transfer archive: %v", err)
+	}
+	if hdr.Size != info.size {
+		return fmt.Errorf("size mismatch: expected %d bytes, archive has %d", info.size, hdr.Size)
+	}
+
+	// Write to a temporary file first, so that a broken transfer never leaves a corrupted artifact
+	tmp := dstFile + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), tr)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != info.size {
+		return fmt.Errorf("truncated transfer: expected %d bytes, got %d", info.size, n)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != info.sha256 {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", info.sha256, sum)
+	}
+	return os.Rename(tmp, dstFile)
+}
+
+// uploadFilesToPod streams the given files, as a tar archive, into the work directory of the pod
+// and then releases the build script waiting for them.
+func uploadFilesToPod(files map[string]string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarLocalFiles(pw, files))
+	}()
+
+	err := execInPod(podClient, clientConfig, namespace, podName, []string{
+		"/bin/bash",
+		"-c",
+		fmt.Sprintf("tar -xf - -C %s && touch %s", builder.DefaultWorkDir, uploadDoneFile),
+	}, pr, nil)
+	if err != nil {
+		return fmt.Errorf("upload to pod %s failed: %v", podName, err)
+	}
+	return nil
+}
+
+func tarLocalFiles(w io.Writer, files map[string]string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range sortedKeys(files) {
+		f, err := os.Open(files[name])
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		hdr := &tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: info.Size(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			f.Close()
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,233 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// LocalBuildProcessorName is a constant containing the local name.
+const LocalBuildProcessorName = "local"
+
+// LocalBuildProcessor runs the build script straight on the host, without any container,
+// using the compiler and the tools already installed there.
+type LocalBuildProcessor struct {
+	timeout int
+	proxy   string
+}
+
+// NewLocalBuildProcessor ...
+func NewLocalBuildProcessor(timeout int, proxy string) *LocalBuildProcessor {
+	return &LocalBuildProcessor{
+		timeout: timeout,
+		proxy:   proxy,
+	}
+}
+
+func (bp *LocalBuildProcessor) String() string {
+	return LocalBuildProcessorName
+}
+
+// Start the local processor
+func (bp *LocalBuildProcessor) Start(ctx context.Context, b *builder.Build) error {
+	logger.Debug("doing a new local build")
+
+	if hit, err := restoreFromRepository(b); err != nil {
+		return err
+	} else if hit {
+		return nil
+	}
+
+	// Every build gets its own work directory, the build scripts keep the sources, the kernel headers and the artifacts into it
+	workDir, err := os.MkdirTemp("", "driverkit-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	b.WorkDir = workDir
+	b.HostToolchain = true
+
+	if len(b.KernelHeadersCache) > 0 {
+		logger.Warn("kernel headers cache is not supported by the local processor, ignoring it")
+		b.KernelHeadersCache = ""
+	}
+
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return err
+	}
+
+	// create a builder based on the choosen build type
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	c := b.ToConfig()
+
+	var localKernelFiles []string
+	if !b.OnlineMode {
+		localKernelFiles, err = v.SearchLocalKernelFilepath(c, kr)
+		if err != nil {
+			return err
+		}
+		minimumKernelFiles := 1
+		if vv, ok := v.(builder.MinimumURLsBuilder); ok {
+			minimumKernelFiles = vv.MinimumURLs()
+		}
+		if len(localKernelFiles) != minimumKernelFiles {
+			return fmt.Errorf("not enough headers packages found; expected %d, found %d: %v", minimumKernelFiles, len(localKernelFiles), localKernelFiles)
+		}
+
+		logger.Infof("found local kernel file: %v", localKernelFiles)
+	}
+
+	// Generate the build script from the builder
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
+	if err != nil {
+		return err
+	}
+
+	blog, err := newBuildLog(b)
+	if err != nil {
+		return err
+	}
+	defer blog.Close()
+
+	manifest := newManifest(b, bp.String())
+	if err := manifest.setInputs(b, localKernelFiles); err != nil {
+		return err
+	}
+	publishers, err := newPublishers(b)
+	if err != nil {
+		return err
+	}
+
+	cache := newBuildCache(b.CacheDir)
+	var cacheKey string
+	if cache != nil {
+		// There is no builder image, the host toolchain is accounted for by the gcc version only
+		cacheKey, err = buildCacheKey(b, driverkitScript, LocalBuildProcessorName, localKernelFiles)
+		if err != nil {
+			return err
+		}
+		if hit, err := cache.restore(cacheKey, b); err != nil {
+			return err
+		} else if hit {
+			if err := manifest.write(b, true); err != nil {
+				return err
+			}
+			return publish(ctx, publishers, manifest)
+		}
+	}
+
+	for i, kernelFile := range localKernelFiles {
+		if err := copyFile(kernelFile, filepath.Join(workDir, builder.KernelFileName(i))); err != nil {
+			return err
+		}
+	}
+	if err := copyFile(b.ModuleFilePath, filepath.Join(workDir, builder.ModuleArchiveFileName)); err != nil {
+		return err
+	}
+	support, err := supportFiles(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(b.SupportDir(), 0755); err != nil {
+		return err
+	}
+	for name, content := range support {
+		if err := os.WriteFile(filepath.Join(b.SupportDir(), name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	scriptPath := filepath.Join(workDir, "driverkit.sh")
+	if err := os.WriteFile(scriptPath, []byte(driverkitScript), 0755); err != nil {
+		return err
+	}
+
+	buildCtx, cancel := context.WithTimeout(ctx, time.Duration(bp.timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(buildCtx, "/bin/bash", scriptPath)
+	cmd.Dir = workDir
+	cmd.Env = os.Environ()
+	// Add http_proxy and https_proxy environment variable
+	if bp.proxy != "" {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("http_proxy=%s", bp.proxy),
+			fmt.Sprintf("https_proxy=%s", bp.proxy),
+		)
+	}
+	if b.OnlineMode {
+		cmd.Env = append(cmd.Env, "MODE=online")
+	} else {
+		cmd.Env = append(cmd.Env, "MODE=local")
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	logsDone := make(chan struct{})
+	go func() {
+		blog.forward(pr)
+		close(logsDone)
+	}()
+
+	err = cmd.Run()
+	pw.Close()
+	<-logsDone
+	if ctx.Err() != nil {
+		return blog.failed(fmt.Errorf("local build interrupted: %w", ctx.Err()))
+	}
+	if buildCtx.Err() == context.DeadlineExceeded {
+		return blog.failed(fmt.Errorf("local build timed out after %d seconds", bp.timeout))
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return blog.scriptFailed(exitErr.ExitCode(), nil)
+	}
+	if err != nil {
+		return blog.failed(fmt.Errorf("local build failed: %v", err))
+	}
+
+	if len(b.ModuleOutPutFilePath) > 0 {
+		b.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: b.ModuleOutPutFilePath})
+		if err := copyFile(b.ModulePath(), b.ModuleOutPutFilePath); err != nil {
+			return err
+		}
+		logger.WithField("path", b.ModuleOutPutFilePath).Info("kernel module available")
+	}
+
+	if len(b.ProbeFilePath) > 0 {
+		b.Emit(builder.Event{Type: builder.EventCopyingArtifact, Artifact: b.ProbeFilePath})
+		if err := copyFile(b.ProbePath(), b.ProbeFilePath); err != nil {
+			return err
+		}
+		logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
+	}
+
+	if err := verifyArtifacts(v, b, kr); err != nil {
+		return err
+	}
+
+	if cache != nil {
+		if err := cache.store(cacheKey, b); err != nil {
+			logger.WithError(err).Warn("could not store artifacts into the build cache")
+		}
+	}
+
+	manifest.setHeaderSums(blog.downloadedHeaderSums())
+	if err := manifest.write(b, false); err != nil {
+		return err
+	}
+	// The build timeout does not account for the upload
+	return publish(ctx, publishers, manifest)
+}
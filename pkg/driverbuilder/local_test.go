@@ -0,0 +1,150 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+const localTestKernelRelease = "5.10.0-18-amd64"
+
+// localTestMake stands for make: it checks that the kernel headers resolve within their extracted tree,
+// records the kernel directory it was given and compiles a module carrying the expected vermagic
+var localTestMake = `#!/bin/sh
+set -e
+for arg in "$@"; do
+  case "$arg" in
+    CC=*) cc="${arg#CC=}" ;;
+    KERNELDIR=*) kerneldir="${arg#KERNELDIR=}" ;;
+  esac
+done
+echo "$kerneldir" > "$STUB_MAKE_LOG"
+[ -f "$(sed -n 's/^include //p' "$kerneldir/Makefile")" ]
+[ -f "$kerneldir/scripts/Makefile.build" ]
+[ -f Makefile ] && [ -f driver_config.h ]
+printf 'const char modinfo[] __attribute__((section(".modinfo"), used)) = "vermagic=%s SMP mod_unload ";\n' | "$cc" -c -x c - -o falco.ko
+`
+
+func writeTestArchive(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	assert.NilError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedKeys(files) {
+		assert.NilError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}))
+		_, err = tw.Write([]byte(files[name]))
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, gz.Close())
+	assert.NilError(t, f.Close())
+}
+
+// writeTestDeb packs the tree prepared by fill into a debian package
+func writeTestDeb(t *testing.T, path string, fill func(root string)) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	assert.NilError(t, os.MkdirAll(root, 0755))
+	fill(root)
+	for _, args := range [][]string{
+		{"tar", "-cJf", filepath.Join(dir, "data.tar.xz"), "-C", root, "."},
+		{"ar", "rc", path, filepath.Join(dir, "data.tar.xz")},
+	} {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		assert.NilError(t, err, string(out))
+	}
+}
+
+func TestLocalBuildProcessor(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the test kernel headers are amd64 ones")
+	}
+	for _, tool := range []string{"gcc", "ar", "xz", "curl", "strip", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	dir := t.TempDir()
+	stubs := filepath.Join(dir, "stubs")
+	assert.NilError(t, os.MkdirAll(stubs, 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(stubs, "make"), []byte(fmt.Sprintf(localTestMake, localTestKernelRelease)), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(stubs, "modinfo"), []byte("#!/bin/sh\n"), 0755))
+	t.Setenv("PATH", stubs+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("STUB_MAKE_LOG", filepath.Join(dir, "make.log"))
+	tmp := filepath.Join(dir, "tmp")
+	assert.NilError(t, os.MkdirAll(tmp, 0755))
+	t.Setenv("TMPDIR", tmp)
+
+	// The headers of debian include the common ones through absolute paths
+	debs := filepath.Join(dir, "debs")
+	assert.NilError(t, os.MkdirAll(debs, 0755))
+	writeTestDeb(t, filepath.Join(debs, "headers-amd64.deb"), func(root string) {
+		src := filepath.Join(root, "usr/src/linux-headers-"+localTestKernelRelease)
+		assert.NilError(t, os.MkdirAll(src, 0755))
+		assert.NilError(t, os.WriteFile(filepath.Join(src, "Makefile"), []byte("include /usr/src/linux-headers-5.10.0-18-common/Makefile\n"), 0644))
+		assert.NilError(t, os.Symlink("../../lib/linux-kbuild-5.10/scripts", filepath.Join(src, "scripts")))
+		modules := filepath.Join(root, "lib/modules", localTestKernelRelease)
+		assert.NilError(t, os.MkdirAll(modules, 0755))
+		assert.NilError(t, os.Symlink("/usr/src/linux-headers-"+localTestKernelRelease, filepath.Join(modules, "build")))
+	})
+	writeTestDeb(t, filepath.Join(debs, "headers-common.deb"), func(root string) {
+		src := filepath.Join(root, "usr/src/linux-headers-5.10.0-18-common")
+		assert.NilError(t, os.MkdirAll(src, 0755))
+		assert.NilError(t, os.WriteFile(filepath.Join(src, "Makefile"), []byte("all:\n"), 0644))
+	})
+	writeTestDeb(t, filepath.Join(debs, "kbuild.deb"), func(root string) {
+		scripts := filepath.Join(root, "usr/lib/linux-kbuild-5.10/scripts")
+		assert.NilError(t, os.MkdirAll(scripts, 0755))
+		assert.NilError(t, os.WriteFile(filepath.Join(scripts, "Makefile.build"), []byte("\n"), 0644))
+	})
+	srv := httptest.NewServer(http.FileServer(http.Dir(debs)))
+	defer srv.Close()
+	baseURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	moduleFile := filepath.Join(dir, "module.tar.gz")
+	writeTestArchive(t, moduleFile, map[string]string{
+		"libs-1.0/driver/Makefile.in": "@DRIVER_NAME@-y += main.o\n",
+		"libs-1.0/driver/main.c":      "\n",
+	})
+
+	b := &builder.Build{
+		TargetType:           builder.TargetTypeDebian,
+		KernelRelease:        localTestKernelRelease,
+		KernelVersion:        "1",
+		Architecture:         "amd64",
+		ModuleDriverName:     "falco",
+		ModuleDeviceName:     "falco",
+		ModuleFilePath:       moduleFile,
+		ModuleOutPutFilePath: filepath.Join(dir, "falco.ko"),
+		OnlineMode:           true,
+		KernelUrls: []string{
+			baseURL + "/headers-amd64.deb",
+			baseURL + "/headers-common.deb",
+			baseURL + "/kbuild.deb",
+		},
+	}
+	assert.NilError(t, NewLocalBuildProcessor(120, "").Start(context.Background(), b))
+
+	_, err := os.Stat(b.ModuleOutPutFilePath)
+	assert.NilError(t, err)
+	kernelDir, err := os.ReadFile(filepath.Join(dir, "make.log"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(kernelDir), filepath.Join(tmp, "driverkit-")), "headers extracted into %s", kernelDir)
+	// The work directory goes away along with the build
+	left, err := os.ReadDir(tmp)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(left))
+}
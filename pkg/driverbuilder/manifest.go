@@ -0,0 +1,158 @@
+package driverbuilder
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/version"
+	logger "github.com/sirupsen/logrus"
+)
+
+// ManifestSuffix is appended to the output paths to name the build manifests.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes how the artifacts of a build were produced.
+// It is written next to every artifact, so that downstream tooling can trace its origin.
+type Manifest struct {
+	DriverkitVersion string `json:"driverkitVersion"`
+	Processor        string `json:"processor"`
+
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelRelease"`
+	KernelVersion string `json:"kernelVersion"`
+	Architecture  string `json:"architecture"`
+
+	Image         *ManifestImage  `json:"image,omitempty"`
+	GCCVersion    string          `json:"gccVersion"`
+	ModuleSource  ManifestInput   `json:"moduleSource"`
+	KernelHeaders []ManifestInput `json:"kernelHeaders"`
+
+	Artifacts []ManifestArtifact `json:"artifacts"`
+	// Cached is true when the artifacts were restored from the build cache
+	Cached    bool      `json:"cached"`
+	StartedAt time.Time `json:"startedAt"`
+	// DurationSeconds is how long the build took
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// ManifestImage is the builder image the build ran into.
+type ManifestImage struct {
+	Name string `json:"name"`
+	// Digest is empty when it could not be found out
+	Digest string `json:"digest,omitempty"`
+}
+
+// ManifestInput is an input of the build.
+type ManifestInput struct {
+	Name string `json:"name"`
+	// Location is the url or the local path the input comes from
+	Location string `json:"location"`
+	// SHA256 is empty for the inputs downloaded by the build script itself, until it reports their hash
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ManifestArtifact is an output of the build.
+type ManifestArtifact struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// newManifest starts the manifest of a build, when the build starts.
+func newManifest(b *builder.Build, processor string) *Manifest {
+	return &Manifest{
+		DriverkitVersion: version.String(),
+		Processor:        processor,
+		Target:           b.TargetType.String(),
+		KernelRelease:    b.KernelRelease,
+		KernelVersion:    b.KernelVersion,
+		Architecture:     b.Architecture,
+		StartedAt:        time.Now().UTC(),
+	}
+}
+
+// setImage records the builder image, and its digest if known.
+func (m *Manifest) setImage(name string, digest string) {
+	m.Image = &ManifestImage{Name: name, Digest: digest}
+}
+
+// setInputs records the module source and the kernel header packages,
+// either the local files shipped to the build or the urls the build script downloads.
+func (m *Manifest) setInputs(b *builder.Build, localKernelFiles []string) error {
+	var err error
+	m.ModuleSource = ManifestInput{Name: filepath.Base(b.ModuleFilePath), Location: b.ModuleFilePath}
+	if m.ModuleSource.SHA256, err = hashFile(b.ModuleFilePath); err != nil {
+		return err
+	}
+
+	m.KernelHeaders = []ManifestInput{}
+	for _, f := range localKernelFiles {
+		sum, err := hashFile(f)
+		if err != nil {
+			return err
+		}
+		m.KernelHeaders = append(m.KernelHeaders, ManifestInput{Name: filepath.Base(f), Location: f, SHA256: sum})
+	}
+	for _, u := range b.ResolvedKernelURLs {
+		m.KernelHeaders = append(m.KernelHeaders, ManifestInput{Name: path.Base(u), Location: u})
+	}
+	return nil
+}
+
+// setHeaderSums records the sha256 the build script reported for the kernel header packages it downloaded.
+func (m *Manifest) setHeaderSums(sums map[string]string) {
+	for i, h := range m.KernelHeaders {
+		if sum, ok := sums[h.Location]; ok && len(h.SHA256) == 0 {
+			m.KernelHeaders[i].SHA256 = sum
+		}
+	}
+}
+
+// write completes the manifest with the artifacts, and writes it next to each of them.
+func (m *Manifest) write(b *builder.Build, cached bool) error {
+	m.GCCVersion = b.GCCVersion
+	m.Cached = cached
+	m.DurationSeconds = time.Since(m.StartedAt).Seconds()
+
+	artifacts := []struct {
+		kind string
+		path string
+	}{
+		{"module", b.ModuleOutPutFilePath},
+		{"probe", b.ProbeFilePath},
+	}
+	m.Artifacts = []ManifestArtifact{}
+	for _, a := range artifacts {
+		if len(a.path) == 0 {
+			continue
+		}
+		info, err := os.Stat(a.path)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(a.path)
+		if err != nil {
+			return err
+		}
+		m.Artifacts = append(m.Artifacts, ManifestArtifact{Kind: a.kind, Path: a.path, SHA256: sum, Size: info.Size()})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	for _, a := range m.Artifacts {
+		manifestPath := a.Path + ManifestSuffix
+		if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+			return err
+		}
+		logger.WithField("path", manifestPath).Info("build manifest available")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package driverbuilder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		p := filepath.Join(dir, name)
+		assert.NilError(t, os.WriteFile(p, []byte(content), 0644))
+		return p
+	}
+	b := &builder.Build{
+		TargetType:           builder.Type("ubuntu"),
+		KernelRelease:        "5.15.0-1019-aws",
+		KernelVersion:        "20",
+		Architecture:         "amd64",
+		ModuleFilePath:       write("libs.tar.gz", "module source"),
+		ModuleOutPutFilePath: write("falco.ko", "module"),
+		ResolvedKernelURLs:   []string{"https://example.com/pool/linux-headers-5.15.0-1019-aws_amd64.deb"},
+	}
+	headers := write("kernel0", "headers")
+
+	m := newManifest(b, DockerBuildProcessorName)
+	assert.NilError(t, m.setInputs(b, []string{headers}))
+	m.setImage("falcosecurity/driverkit-builder:latest", "sha256:abcd")
+	m.setHeaderSums(map[string]string{b.ResolvedKernelURLs[0]: hashString("downloaded headers")})
+	b.GCCVersion = "11"
+	assert.NilError(t, m.write(b, false))
+
+	data, err := os.ReadFile(b.ModuleOutPutFilePath + ManifestSuffix)
+	assert.NilError(t, err)
+	var got Manifest
+	assert.NilError(t, json.Unmarshal(data, &got))
+
+	assert.Equal(t, got.Target, "ubuntu")
+	assert.Equal(t, got.KernelRelease, "5.15.0-1019-aws")
+	assert.Equal(t, got.GCCVersion, "11")
+	assert.Equal(t, got.Image.Digest, "sha256:abcd")
+	assert.Equal(t, got.ModuleSource.SHA256, hashString("module source"))
+	assert.DeepEqual(t, got.KernelHeaders, []ManifestInput{
+		{Name: "kernel0", Location: headers, SHA256: hashString("headers")},
+		{Name: "linux-headers-5.15.0-1019-aws_amd64.deb", Location: b.ResolvedKernelURLs[0], SHA256: hashString("downloaded headers")},
+	})
+	assert.DeepEqual(t, got.Artifacts, []ManifestArtifact{
+		{Kind: "module", Path: b.ModuleOutPutFilePath, SHA256: hashString("module"), Size: 6},
+	})
+	assert.Assert(t, !got.Cached)
+}
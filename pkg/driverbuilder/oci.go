@@ -0,0 +1,437 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	// OCIConfigMediaType is the media type of the config of the driver artifacts, which is the build manifest.
+	OCIConfigMediaType = "application/vnd.falcosecurity.driverkit.manifest.v1+json"
+	// OCIModuleMediaType is the media type of the kernel module layer.
+	OCIModuleMediaType = "application/vnd.falcosecurity.driverkit.module.v1"
+	// OCIProbeMediaType is the media type of the eBPF probe layer.
+	OCIProbeMediaType = "application/vnd.falcosecurity.driverkit.probe.v1"
+
+	// OCITargetAnnotation, OCIKernelReleaseAnnotation, OCIKernelVersionAnnotation and OCIArchAnnotation
+	// describe the kernel the artifact is for, so that consumers can resolve artifacts by annotation.
+	OCITargetAnnotation        = "org.falcosecurity.driverkit.target"
+	OCIKernelReleaseAnnotation = "org.falcosecurity.driverkit.kernelrelease"
+	OCIKernelVersionAnnotation = "org.falcosecurity.driverkit.kernelversion"
+	OCIArchAnnotation          = "org.falcosecurity.driverkit.arch"
+)
+
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// OCITag returns the tag naming the driver artifacts of the build, eg. ubuntu_5.15.0-1019-aws_20_x86_64.
+func OCITag(b *builder.Build) string {
+	data := b.OutputNameData()
+	tag := fmt.Sprintf("%s_%s_%s_%s", data.Target, data.KernelRelease, data.KernelVersion, data.Arch)
+	tag = invalidTagChars.ReplaceAllString(tag, "_")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return strings.TrimLeft(tag, ".-")
+}
+
+// ociPublisher pushes the artifacts of a build as an OCI artifact: the build manifest is the config,
+// and the kernel module and the eBPF probe are the layers.
+type ociPublisher struct {
+	// registry is the base url of the registry api, eg. https://ghcr.io
+	registry   string
+	repository string
+	tag        string
+	client     *http.Client
+
+	// credentials are the basic auth ones of the registry, if any
+	credentials string
+	// token is the bearer token of the current authorization
+	token string
+	// actions are the ones the token is asked for, eg. pull,push
+	actions string
+	// accept lists the media types of the manifests the requests accept, if any
+	accept string
+}
+
+// newOCIPublisher returns the OCI publisher of the build, or nil when pushing is disabled.
+//
+// The reference names the repository to push to, eg. ghcr.io/org/drivers; its tag, if any,
+// may contain the fields of builder.OutputNameData, and is derived from the build otherwise.
+// Registries on the loopback interface are reached over plain http, like docker does.
+// Credentials come from the docker configuration file, as written by docker login.
+func newOCIPublisher(b *builder.Build) (*ociPublisher, error) {
+	if len(b.PushOCIRef) == 0 {
+		return nil, nil
+	}
+	rendered, err := b.RenderOutputPath(b.PushOCIRef)
+	if err != nil {
+		return nil, err
+	}
+	named, err := reference.ParseNormalizedNamed(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oci reference %q: %v", b.PushOCIRef, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, fmt.Errorf("invalid oci reference %q: cannot push by digest", b.PushOCIRef)
+	}
+	tag := OCITag(b)
+	if tagged, ok := named.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	return newOCIRegistry(named, tag, "pull,push"), nil
+}
+
+// newOCIRegistry returns a client of the repository of named, asking for tokens granting the given actions.
+func newOCIRegistry(named reference.Named, tag string, actions string) *ociPublisher {
+	domain := reference.Domain(named)
+	host := domain
+	if domain == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if isLoopbackRegistry(domain) {
+		scheme = "http"
+	}
+
+	return &ociPublisher{
+		registry:    scheme + "://" + host,
+		repository:  reference.Path(named),
+		tag:         tag,
+		client:      http.DefaultClient,
+		credentials: dockerCredentials(domain),
+		actions:     actions,
+	}
+}
+
+// resolveImageDigest returns the digest of the manifest the image reference points to, as reported by its registry,
+// so that what a mutable tag (eg. latest) points to can be told apart. Image lists are not resolved per platform.
+func resolveImageDigest(ctx context.Context, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %v", image, err)
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		return digested.Digest().String(), nil
+	}
+	tagged := reference.TagNameOnly(named).(reference.Tagged)
+	r := newOCIRegistry(named, tagged.Tag(), "pull")
+	r.accept = strings.Join([]string{
+		ocispec.MediaTypeImageIndex,
+		ocispec.MediaTypeImageManifest,
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", ")
+	resp, err := r.do(ctx, http.MethodHead, r.url("manifests", r.tag), "", nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not resolve %s: %s", image, resp.Status)
+	}
+	d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: invalid digest: %v", image, err)
+	}
+	return d.String(), nil
+}
+
+func isLoopbackRegistry(domain string) bool {
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// dockerCredentials returns the base64 encoded user:password that docker login stored for the registry.
+func dockerCredentials(domain string) string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if len(dir) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		logger.WithError(err).Warn("could not read the docker configuration file")
+		return ""
+	}
+	keys := []string{domain, "https://" + domain, "http://" + domain}
+	if domain == "docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/")
+	}
+	for _, k := range keys {
+		if a, ok := config.Auths[k]; ok && len(a.Auth) > 0 {
+			return a.Auth
+		}
+	}
+	return ""
+}
+
+type ociBlob struct {
+	descriptor ocispec.Descriptor
+	path       string
+	data       []byte
+}
+
+func (o *ociBlob) reader() (io.ReadCloser, error) {
+	if o.data != nil {
+		return io.NopCloser(bytes.NewReader(o.data)), nil
+	}
+	return os.Open(o.path)
+}
+
+// publish pushes the layers and the config blobs, then the image manifest referencing them.
+// Blobs already into the repository are not pushed again.
+func (p *ociPublisher) publish(ctx context.Context, m *Manifest) error {
+	config, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	blobs := []ociBlob{{
+		descriptor: ocispec.Descriptor{
+			MediaType: OCIConfigMediaType,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		data: config,
+	}}
+	for _, a := range m.Artifacts {
+		mediaType := OCIModuleMediaType
+		if a.Kind == "probe" {
+			mediaType = OCIProbeMediaType
+		}
+		blobs = append(blobs, ociBlob{
+			descriptor: ocispec.Descriptor{
+				MediaType: mediaType,
+				Digest:    digest.NewDigestFromEncoded(digest.SHA256, a.SHA256),
+				Size:      a.Size,
+				Annotations: map[string]string{
+					ocispec.AnnotationTitle: filepath.Base(a.Path),
+				},
+			},
+			path: a.Path,
+		})
+	}
+
+	layers := make([]ocispec.Descriptor, 0, len(blobs)-1)
+	for i, blob := range blobs {
+		if err := p.pushBlob(ctx, &blob); err != nil {
+			return fmt.Errorf("could not push to %s: %v", p.repository, err)
+		}
+		if i > 0 {
+			layers = append(layers, blob.descriptor)
+		}
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    blobs[0].descriptor,
+		Layers:    layers,
+		Annotations: map[string]string{
+			OCITargetAnnotation:        m.Target,
+			OCIKernelReleaseAnnotation: m.KernelRelease,
+			OCIKernelVersionAnnotation: m.KernelVersion,
+			OCIArchAnnotation:          m.Architecture,
+			ocispec.AnnotationCreated:  m.StartedAt.Format(time.RFC3339),
+		},
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	resp, err := p.do(ctx, http.MethodPut, p.url("manifests", p.tag), ocispec.MediaTypeImageManifest, func() (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("could not push the manifest of %s:%s: %s", p.repository, p.tag, resp.Status)
+	}
+	logger.
+		WithField("reference", fmt.Sprintf("%s:%s", p.repository, p.tag)).
+		WithField("digest", digest.FromBytes(data)).
+		Info("artifacts pushed")
+	return nil
+}
+
+func (p *ociPublisher) url(kind string, ref string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", p.registry, p.repository, kind, ref)
+}
+
+// pushBlob uploads the blob in a single request, unless the repository already has it.
+func (p *ociPublisher) pushBlob(ctx context.Context, blob *ociBlob) error {
+	d := blob.descriptor.Digest.String()
+	resp, err := p.do(ctx, http.MethodHead, p.url("blobs", d), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		logger.WithField("digest", d).Debug("blob already pushed, skipping it")
+		return nil
+	}
+
+	resp, err = p.do(ctx, http.MethodPost, p.url("blobs", "uploads/"), "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("could not start the upload of %s: %s", d, resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location: %v", err)
+	}
+	query := location.Query()
+	query.Set("digest", d)
+	location.RawQuery = query.Encode()
+
+	resp, err = p.do(ctx, http.MethodPut, location.String(), "application/octet-stream", func() (io.ReadCloser, int64, error) {
+		r, err := blob.reader()
+		return r, blob.descriptor.Size, err
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("could not upload %s: %s", d, resp.Status)
+	}
+	return nil
+}
+
+// do sends the request, authorizing it as the registry asks for on the first unauthorized response.
+func (p *ociPublisher) do(ctx context.Context, method string, u string, contentType string, body func() (io.ReadCloser, int64, error)) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			r, size, err := body()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = r
+			req.ContentLength = size
+		}
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if len(p.accept) > 0 {
+			req.Header.Set("Accept", p.accept)
+		}
+		if len(p.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+p.token)
+		} else if len(p.credentials) > 0 {
+			req.Header.Set("Authorization", "Basic "+p.credentials)
+		}
+		return p.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	if err := p.authorize(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize answers a bearer challenge of the registry with a token, see
+// https://docs.docker.com/registry/spec/auth/token/
+func (p *ociPublisher) authorize(ctx context.Context, challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		if len(p.credentials) == 0 {
+			return fmt.Errorf("the registry requires credentials, run docker login")
+		}
+		return fmt.Errorf("the registry refused the credentials")
+	}
+	params := map[string]string{}
+	for _, m := range challengeParams.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || len(realm.Host) == 0 {
+		return fmt.Errorf("invalid registry challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", p.repository, p.actions))
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if len(p.credentials) > 0 {
+		req.Header.Set("Authorization", "Basic "+p.credentials)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get a registry token: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	p.token = token.Token
+	if len(p.token) == 0 {
+		p.token = token.AccessToken
+	}
+	if len(p.token) == 0 {
+		return fmt.Errorf("the registry did not grant a token")
+	}
+	return nil
+}
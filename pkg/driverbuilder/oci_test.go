@@ -0,0 +1,172 @@
+package driverbuilder
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+// fakeRegistry stands in for registry:2 behind a token server, keeping blobs and manifests into memory.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if req.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pushtoken"})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer pushtoken" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	const repo = "/v2/falco/drivers/"
+	path := strings.TrimPrefix(req.URL.Path, repo)
+	switch {
+	case req.Method == http.MethodHead && strings.HasPrefix(path, "blobs/"):
+		if _, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case req.Method == http.MethodPost && path == "blobs/uploads/":
+		w.Header().Set("Location", repo+"blobs/uploads/session?state=abc")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && path == "blobs/uploads/session":
+		data, _ := io.ReadAll(req.Body)
+		d := req.URL.Query().Get("digest")
+		if digest.FromBytes(data).String() != d || req.URL.Query().Get("state") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[d] = data
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "manifests/"):
+		data, _ := io.ReadAll(req.Body)
+		r.manifests[strings.TrimPrefix(path, "manifests/")] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOCIPublish(t *testing.T) {
+	registry := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	srv := httptest.NewServer(registry)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	assert.NilError(t, os.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(`{"auths":{"`+host+`":{"auth":"`+auth+`"}}}`), 0644))
+
+	dir := t.TempDir()
+	b := &builder.Build{
+		TargetType:           builder.TargetTypeUbuntu,
+		KernelRelease:        "5.10.133+",
+		KernelVersion:        "1",
+		Architecture:         "arm64",
+		ModuleFilePath:       filepath.Join(dir, "libs.tar.gz"),
+		ModuleOutPutFilePath: filepath.Join(dir, "falco.ko"),
+		ProbeFilePath:        filepath.Join(dir, "falco.o"),
+		PushOCIRef:           host + "/falco/drivers",
+	}
+	assert.NilError(t, os.WriteFile(b.ModuleFilePath, []byte("module source"), 0644))
+	assert.NilError(t, os.WriteFile(b.ModuleOutPutFilePath, []byte("module"), 0644))
+	assert.NilError(t, os.WriteFile(b.ProbeFilePath, []byte("probe"), 0644))
+
+	p, err := newOCIPublisher(b)
+	assert.NilError(t, err)
+	assert.Equal(t, p.tag, "ubuntu_5.10.133__1_aarch64")
+	m := newManifest(b, DockerBuildProcessorName)
+	assert.NilError(t, m.setInputs(b, nil))
+	assert.NilError(t, m.write(b, false))
+	assert.NilError(t, p.publish(context.Background(), m))
+	assert.Equal(t, registry.uploads, 3)
+
+	var manifest ocispec.Manifest
+	assert.NilError(t, json.Unmarshal(registry.manifests[p.tag], &manifest))
+	assert.Equal(t, manifest.Config.MediaType, OCIConfigMediaType)
+	assert.Equal(t, manifest.Annotations[OCIKernelReleaseAnnotation], "5.10.133+")
+	assert.Equal(t, manifest.Annotations[OCIArchAnnotation], "arm64")
+	assert.Equal(t, len(manifest.Layers), 2)
+	assert.Equal(t, manifest.Layers[0].MediaType, OCIModuleMediaType)
+	assert.Equal(t, manifest.Layers[0].Annotations[ocispec.AnnotationTitle], "falco.ko")
+	assert.Equal(t, string(registry.blobs[manifest.Layers[1].Digest.String()]), "probe")
+
+	// Only the config changes from a build to the other, layers already pushed are skipped
+	b.PushOCIRef = host + "/falco/drivers:{{ .Target }}-{{ .Arch }}"
+	p, err = newOCIPublisher(b)
+	assert.NilError(t, err)
+	m.StartedAt = m.StartedAt.Add(1)
+	assert.NilError(t, p.publish(context.Background(), m))
+	assert.Equal(t, registry.uploads, 4)
+	_, ok := registry.manifests["ubuntu-aarch64"]
+	assert.Assert(t, ok)
+
+	b.PushOCIRef = host + "/falco/drivers:{{ .KernelRelease }}"
+	_, err = newOCIPublisher(b)
+	assert.ErrorContains(t, err, "invalid oci reference")
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	const imageDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			// Anonymous pulls only
+			if req.URL.Query().Get("scope") != "repository:falcosecurity/driverkit:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "pulltoken"})
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer pulltoken" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method != http.MethodHead || req.URL.Path != "/v2/falcosecurity/driverkit/manifests/latest" || !strings.Contains(req.Header.Get("Accept"), ocispec.MediaTypeImageIndex) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", imageDigest)
+	}))
+	defer srv.Close()
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	d, err := resolveImageDigest(context.Background(), host+"/falcosecurity/driverkit")
+	assert.NilError(t, err)
+	assert.Equal(t, d, imageDigest)
+	_, err = resolveImageDigest(context.Background(), host+"/falcosecurity/driverkit:missing")
+	assert.ErrorContains(t, err, "404")
+	// Pinned images are not looked up
+	d, err = resolveImageDigest(context.Background(), "example.invalid/driverkit@"+imageDigest)
+	assert.NilError(t, err)
+	assert.Equal(t, d, imageDigest)
+}
@@ -0,0 +1,82 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// PodmanBuildProcessorName is a constant containing the podman name.
+const PodmanBuildProcessorName = "podman"
+
+// PodmanBuildProcessor builds through the docker compatible API served by podman,
+// so that it also works on hosts that only allow rootless podman.
+type PodmanBuildProcessor struct {
+	*DockerBuildProcessor
+}
+
+// NewPodmanBuildProcessor constructs a PodmanBuildProcessor talking to the given podman API socket.
+// When socket is empty, it is discovered with DiscoverPodmanSocket.
+func NewPodmanBuildProcessor(timeout int, proxy string, poolSize int, socket string) (*PodmanBuildProcessor, error) {
+	if len(socket) == 0 {
+		var err error
+		if socket, err = DiscoverPodmanSocket(); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.Contains(socket, "://") {
+		socket = "unix://" + socket
+	}
+	logger.WithField("socket", socket).Debug("using podman API socket")
+
+	dbp := NewDockerBuildProcessor(timeout, proxy, poolSize)
+	dbp.host = socket
+	return &PodmanBuildProcessor{DockerBuildProcessor: dbp}, nil
+}
+
+func (bp *PodmanBuildProcessor) String() string {
+	return PodmanBuildProcessorName
+}
+
+// Start the podman processor
+func (bp *PodmanBuildProcessor) Start(ctx context.Context, b *builder.Build) error {
+	logger.Debug("doing a new podman build")
+	return bp.DockerBuildProcessor.Start(ctx, b)
+}
+
+// DiscoverPodmanSocket returns the podman API socket address.
+//
+// It honors the CONTAINER_HOST environment variable, as podman does,
+// otherwise it looks for the rootless socket (under XDG_RUNTIME_DIR) when not running as root,
+// and for the system wide one when running as root.
+func DiscoverPodmanSocket() (string, error) {
+	return discoverPodmanSocket(os.Geteuid(), os.Getenv)
+}
+
+func discoverPodmanSocket(euid int, getenv func(string) string) (string, error) {
+	if host := getenv("CONTAINER_HOST"); len(host) > 0 {
+		return host, nil
+	}
+
+	socket := "/run/podman/podman.sock"
+	if euid != 0 {
+		runtimeDir := getenv("XDG_RUNTIME_DIR")
+		if len(runtimeDir) == 0 {
+			runtimeDir = fmt.Sprintf("/run/user/%d", euid)
+		}
+		socket = filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+
+	if _, err := os.Stat(socket); err != nil {
+		if euid != 0 {
+			return "", fmt.Errorf("podman socket not found at %s, enable it with 'systemctl --user enable --now podman.socket': %w", socket, err)
+		}
+		return "", fmt.Errorf("podman socket not found at %s, enable it with 'systemctl enable --now podman.socket': %w", socket, err)
+	}
+	return "unix://" + socket, nil
+}
@@ -0,0 +1,32 @@
+package driverbuilder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestDiscoverPodmanSocket(t *testing.T) {
+	runtimeDir := t.TempDir()
+	env := map[string]string{"XDG_RUNTIME_DIR": runtimeDir}
+	getenv := func(k string) string { return env[k] }
+
+	// Rootless socket not enabled yet
+	_, err := discoverPodmanSocket(1000, getenv)
+	assert.ErrorContains(t, err, "systemctl --user enable --now podman.socket")
+
+	socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(socket), 0755))
+	assert.NilError(t, os.WriteFile(socket, nil, 0600))
+	host, err := discoverPodmanSocket(1000, getenv)
+	assert.NilError(t, err)
+	assert.Equal(t, "unix://"+socket, host)
+
+	// CONTAINER_HOST always wins
+	env["CONTAINER_HOST"] = "tcp://127.0.0.1:8888"
+	host, err = discoverPodmanSocket(1000, getenv)
+	assert.NilError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:8888", host)
+}
@@ -0,0 +1,321 @@
+package driverbuilder
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPublishRegion is the region signing the requests to the object store, when none is given.
+	DefaultPublishRegion = "us-east-1"
+
+	// publishHashMeta is the object metadata holding the sha256 of the published artifacts
+	publishHashMeta = "X-Amz-Meta-Sha256"
+)
+
+// publisher distributes the artifacts of a build once they are available.
+type publisher interface {
+	publish(ctx context.Context, m *Manifest) error
+}
+
+// newPublishers returns the publishers configured into the build.
+func newPublishers(b *builder.Build) ([]publisher, error) {
+	var publishers []publisher
+	if repo := newRepositoryPublisher(b); repo != nil {
+		publishers = append(publishers, repo)
+	}
+	s3, err := newS3Publisher(b)
+	if err != nil {
+		return nil, err
+	}
+	if s3 != nil {
+		publishers = append(publishers, s3)
+	}
+	oci, err := newOCIPublisher(b)
+	if err != nil {
+		return nil, err
+	}
+	if oci != nil {
+		publishers = append(publishers, oci)
+	}
+	return publishers, nil
+}
+
+// publish hands the artifacts listed by the manifest to every publisher.
+func publish(ctx context.Context, publishers []publisher, m *Manifest) error {
+	for _, p := range publishers {
+		if err := p.publish(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Publisher uploads the artifacts of a build, along with their manifests,
+// to a bucket of an S3-compatible object store (eg. AWS S3, MinIO).
+//
+// Keys are laid out as <prefix>/<artifact name>, where the prefix may contain
+// the fields of builder.OutputNameData, eg. {{ .Target }}/{{ .Arch }}.
+type s3Publisher struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	prefix   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// newS3Publisher returns the S3 publisher of the build, or nil when publishing to S3 is disabled.
+//
+// The publish url has the s3://<bucket>[/<prefix>] form. Objects are addressed path style,
+// at the given endpoint or at the AWS S3 one of the region by default.
+// Credentials come from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func newS3Publisher(b *builder.Build) (*s3Publisher, error) {
+	if len(b.PublishURL) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(b.PublishURL, "s3://") {
+		return nil, fmt.Errorf("unknown publish url %q, expected s3://<bucket>[/<prefix>]", b.PublishURL)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(b.PublishURL, "s3://"), "/")
+	if len(bucket) == 0 {
+		return nil, fmt.Errorf("missing bucket into publish url %q", b.PublishURL)
+	}
+	prefix, err := b.RenderOutputPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	region := b.PublishRegion
+	if len(region) == 0 {
+		region = DefaultPublishRegion
+	}
+	endpoint := b.PublishEndpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid publish endpoint %q", endpoint)
+	}
+
+	return &s3Publisher{
+		endpoint:     u,
+		region:       region,
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       http.DefaultClient,
+		now:          time.Now,
+	}, nil
+}
+
+// key returns the object key of the given file.
+func (p *s3Publisher) key(file string) string {
+	return path.Join(p.prefix, filepath.Base(file))
+}
+
+// publish uploads the artifacts listed by the manifest, then the manifest next to each of them.
+//
+// Artifacts already published with the same hash are skipped, and so are their manifests,
+// which keep describing the build that produced them in the first place.
+func (p *s3Publisher) publish(ctx context.Context, m *Manifest) error {
+	for _, a := range m.Artifacts {
+		published, err := p.upload(ctx, a.Path, a.SHA256)
+		if err != nil {
+			return fmt.Errorf("could not publish the %s: %v", a.Kind, err)
+		}
+		if !published {
+			continue
+		}
+		manifestPath := a.Path + ManifestSuffix
+		sum, err := hashFile(manifestPath)
+		if err != nil {
+			return err
+		}
+		if _, err := p.upload(ctx, manifestPath, sum); err != nil {
+			return fmt.Errorf("could not publish the %s manifest: %v", a.Kind, err)
+		}
+	}
+	return nil
+}
+
+// upload puts the file into the bucket, unless an object with the same hash is already there.
+func (p *s3Publisher) upload(ctx context.Context, file string, sum string) (bool, error) {
+	key := p.key(file)
+	log := logger.WithField("bucket", p.bucket).WithField("key", key)
+
+	remote, err := p.remoteHash(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if remote == sum {
+		log.Info("artifact already published, skipping it")
+		return false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	req, err := p.request(ctx, http.MethodPut, key, sum)
+	if err != nil {
+		return false, err
+	}
+	// Setting the body this way keeps the request from being sent chunked
+	req.Body = f
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(publishHashMeta, sum)
+	p.sign(req, sum)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("upload of %s failed: %s", key, resp.Status)
+	}
+	log.Info("artifact published")
+	return true, nil
+}
+
+// remoteHash returns the hash of the published object, empty if there is no such object.
+func (p *s3Publisher) remoteHash(ctx context.Context, key string) (string, error) {
+	req, err := p.request(ctx, http.MethodHead, key, hashString(""))
+	if err != nil {
+		return "", err
+	}
+	p.sign(req, hashString(""))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", fmt.Errorf("lookup of %s failed: %s", key, resp.Status)
+	}
+	return resp.Header.Get(publishHashMeta), nil
+}
+
+func (p *s3Publisher) request(ctx context.Context, method string, key string, payloadHash string) (*http.Request, error) {
+	u := *p.endpoint
+	u.Path = "/" + p.bucket + "/" + key
+	// Kernel releases may have characters, eg. '+', that url paths keep as is while signatures need them encoded
+	u.RawPath = s3EscapePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(p.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+	return req, nil
+}
+
+// sign adds the AWS signature version 4 to the request, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (p *s3Publisher) sign(req *http.Request, payloadHash string) {
+	if len(p.accessKey) == 0 {
+		// Anonymous requests, eg. to a public bucket
+		return
+	}
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, p.region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashString(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), date)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath encodes every byte of each path segment but the unreserved characters of RFC 3986,
+// as the canonical URI of the AWS signature version 4 requires.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
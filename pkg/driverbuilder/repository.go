@@ -0,0 +1,89 @@
+package driverbuilder
+
+import (
+	"context"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/repository"
+	logger "github.com/sirupsen/logrus"
+)
+
+// repositoryPublisher adds the artifacts of the builds to the artifact repository.
+type repositoryPublisher struct {
+	dir string
+	key repository.Key
+}
+
+func newRepositoryPublisher(b *builder.Build) *repositoryPublisher {
+	if len(b.RepositoryDir) == 0 {
+		return nil
+	}
+	return &repositoryPublisher{dir: b.RepositoryDir, key: repository.KeyFromBuild(b)}
+}
+
+func (p *repositoryPublisher) publish(_ context.Context, m *Manifest) error {
+	repo, err := repository.Open(p.dir)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+	for _, a := range m.Artifacts {
+		if _, err := repo.Add(p.key, a.Kind, a.Path, a.SHA256); err != nil {
+			return err
+		}
+	}
+	logger.WithField("repository", p.dir).Info("artifacts added to the repository")
+	return nil
+}
+
+// restoreFromRepository copies the requested artifacts, and their manifests, out of the artifact repository.
+//
+// It returns true only when the repository holds every requested artifact, so that the build can be skipped.
+func restoreFromRepository(b *builder.Build) (bool, error) {
+	if len(b.RepositoryDir) == 0 {
+		return false, nil
+	}
+	repo, err := repository.Open(b.RepositoryDir)
+	if err != nil {
+		return false, err
+	}
+	defer repo.Close()
+
+	key := repository.KeyFromBuild(b)
+	requested := map[string]string{}
+	if len(b.ModuleOutPutFilePath) > 0 {
+		requested[repository.KindModule] = b.ModuleOutPutFilePath
+	}
+	if len(b.ProbeFilePath) > 0 {
+		requested[repository.KindProbe] = b.ProbeFilePath
+	}
+	entries := map[string]*repository.Entry{}
+	for kind := range requested {
+		e, err := repo.Get(key, kind)
+		if err != nil {
+			return false, err
+		}
+		if e == nil {
+			return false, nil
+		}
+		if _, err := os.Stat(repo.Path(*e)); err != nil {
+			return false, nil
+		}
+		entries[kind] = e
+	}
+
+	for kind, dst := range requested {
+		e := entries[kind]
+		if err := copyFile(repo.Path(*e), dst); err != nil {
+			return false, err
+		}
+		if manifest := repo.ManifestPath(*e); len(manifest) > 0 {
+			if err := copyFile(manifest, dst+ManifestSuffix); err != nil {
+				return false, err
+			}
+		}
+		logger.WithField("path", dst).Infof("%s already into the repository, build skipped", kind)
+	}
+	return true, nil
+}
@@ -0,0 +1,96 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Run starts the build onto the processor, between the pre and post build hooks of the build,
+// and reports when the build started and whether it is done or failed to the event handler of the build.
+//
+// A failing pre build hook fails the build, while a failing post build hook is only logged.
+// Both hooks are bounded by the hook timeout of the build.
+func Run(ctx context.Context, bp BuildProcessor, b *builder.Build) error {
+	b.Emit(builder.Event{Type: builder.EventStarted})
+	err := runHook(ctx, "pre-build", b.PreBuildHook, hookEnv(b), b.HookTimeout)
+	if err == nil {
+		err = bp.Start(ctx, b)
+	}
+	if err != nil {
+		b.Emit(builder.Event{Type: builder.EventFailed, Error: err.Error()})
+	} else {
+		b.Emit(builder.Event{Type: builder.EventDone})
+	}
+	env := append(hookEnv(b), "DRIVERKIT_RESULT="+string(builder.EventDone))
+	if err != nil {
+		env = append(hookEnv(b), "DRIVERKIT_RESULT="+string(builder.EventFailed), "DRIVERKIT_ERROR="+err.Error())
+	}
+	// The post build hook runs even when the build was interrupted
+	if herr := runHook(context.Background(), "post-build", b.PostBuildHook, env, b.HookTimeout); herr != nil {
+		logger.WithError(herr).Warn("ignoring the post-build hook failure")
+	}
+	return err
+}
+
+// runHook runs command with sh, along with the given environment variables, killing it after timeout (if not zero).
+//
+// The hook runs into its own process group, killed as a whole, so that no command it started
+// keeps its output open once it is killed.
+func runHook(ctx context.Context, name string, command string, env []string, timeout time.Duration) error {
+	if len(command) == 0 {
+		return nil
+	}
+	logger.WithField("hook", name).Debug("running hook")
+	hookCtx, cancel := builder.WithTimeout(ctx, timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-hookCtx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if out.Len() > 0 {
+		logger.WithField("hook", name).Debugf("%s", out.Bytes())
+	}
+	if ctx.Err() == nil && hookCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %s", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// hookEnv describes the build to the hooks.
+func hookEnv(b *builder.Build) []string {
+	return []string{
+		"DRIVERKIT_TARGET=" + b.TargetType.String(),
+		"DRIVERKIT_KERNELRELEASE=" + b.KernelRelease,
+		"DRIVERKIT_KERNELVERSION=" + b.KernelVersion,
+		"DRIVERKIT_ARCH=" + b.Architecture,
+		"DRIVERKIT_MODULE=" + b.ModuleOutPutFilePath,
+		"DRIVERKIT_PROBE=" + b.ProbeFilePath,
+	}
+}
@@ -0,0 +1,91 @@
+package driverbuilder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+// stagesProcessor prints the stage markers of a build script, then fails with err.
+type stagesProcessor struct {
+	started bool
+	err     error
+}
+
+func (p *stagesProcessor) String() string {
+	return "stages"
+}
+
+func (p *stagesProcessor) Start(_ context.Context, b *builder.Build) error {
+	p.started = true
+	blog, err := newBuildLog(b)
+	if err != nil {
+		return err
+	}
+	defer blog.Close()
+	blog.add(builder.StageMarker + string(builder.StageModuleBuild))
+	blog.add("make: ok")
+	return p.err
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	var events []builder.Event
+	b := &builder.Build{
+		TargetType:    builder.Type("ubuntu"),
+		KernelRelease: "5.15.0-1019-aws",
+		Architecture:  "amd64",
+		PreBuildHook:  fmt.Sprintf(`echo "$DRIVERKIT_TARGET $DRIVERKIT_KERNELRELEASE" > %s`, filepath.Join(dir, "pre")),
+		PostBuildHook: fmt.Sprintf(`echo "$DRIVERKIT_RESULT $DRIVERKIT_ERROR" > %s`, filepath.Join(dir, "post")),
+		Events: builder.EventHandlerFunc(func(e builder.Event) {
+			events = append(events, e)
+		}),
+	}
+
+	p := &stagesProcessor{err: fmt.Errorf("build script failed")}
+	err := Run(context.Background(), p, b)
+	assert.Error(t, err, "build script failed")
+	var types []builder.EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+		assert.Equal(t, e.KernelRelease, "5.15.0-1019-aws")
+		assert.Assert(t, e.Elapsed >= 0)
+	}
+	assert.DeepEqual(t, types, []builder.EventType{builder.EventStarted, builder.EventStage, builder.EventFailed})
+	assert.Equal(t, events[1].Stage, builder.StageModuleBuild)
+	assert.Equal(t, events[2].Error, "build script failed")
+	data, _ := os.ReadFile(filepath.Join(dir, "pre"))
+	assert.Equal(t, string(data), "ubuntu 5.15.0-1019-aws\n")
+	data, _ = os.ReadFile(filepath.Join(dir, "post"))
+	assert.Equal(t, string(data), "failed build script failed\n")
+
+	// A failing pre build hook fails the build before it starts
+	events = nil
+	b.PreBuildHook = "exit 3"
+	p = &stagesProcessor{}
+	err = Run(context.Background(), p, b)
+	assert.ErrorContains(t, err, "pre-build hook failed")
+	assert.Assert(t, !p.started)
+	assert.Equal(t, events[len(events)-1].Type, builder.EventFailed)
+
+	// Hooks are killed once they time out
+	b.PreBuildHook = "sleep 10; echo late"
+	b.HookTimeout = 100 * time.Millisecond
+	start := time.Now()
+	err = Run(context.Background(), p, b)
+	assert.Error(t, err, "pre-build hook timed out after 100ms")
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+	assert.Assert(t, !p.started)
+
+	b.PreBuildHook = ""
+	assert.NilError(t, Run(context.Background(), p, b))
+	assert.Equal(t, events[len(events)-1].Type, builder.EventDone)
+	data, _ = os.ReadFile(filepath.Join(dir, "post"))
+	assert.Equal(t, string(data), "done \n")
+}
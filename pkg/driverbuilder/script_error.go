@@ -0,0 +1,32 @@
+package driverbuilder
+
+import (
+	"fmt"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// ScriptError is returned when the build script exits with a non-zero status.
+type ScriptError struct {
+	// Stage is the last stage the script entered, empty if it failed before the first one
+	Stage    builder.Stage
+	ExitCode int
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *ScriptError) Error() string {
+	stage := string(e.Stage)
+	if len(stage) == 0 {
+		stage = "unknown"
+	}
+	msg := fmt.Sprintf("build script failed during stage %s with exit code %d", stage, e.ExitCode)
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
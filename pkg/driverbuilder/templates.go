@@ -1,13 +1,19 @@
 package driverbuilder
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"text/template"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
 )
 
 var waitForLockScript = `
@@ -27,12 +33,26 @@ var deleteLock = `
 rm -f /tmp/download.lock
 `
 
+const uploadDoneFile = "/tmp/driverkit-upload.done"
+
+// waitForUploadScript holds the build until all the inputs have been streamed into the pod.
+var waitForUploadScript = `
+while [ ! -f ` + uploadDoneFile + ` ]; do
+  echo "Inputs not uploaded yet - waiting for 1 second"
+  sleep 1
+done
+`
+
+// configMapInputsMaxBytes caps the build inputs shipped through the ConfigMap,
+// that as any other kubernetes object cannot be larger than 1MiB.
+const configMapInputsMaxBytes = 512 * 1024
+
 const moduleLockFile = "/tmp/module.lock"
 const probeLockFile = "/tmp/probe.lock"
 
-// waitForLockAndCat MUST only output the file, any other output will break
-// the download file itself because it goes trough stdout
-var waitForLockAndCat = `
+// waitForLockAndDescribe waits for the artifact to be built, then it prints its SHA-256 and size,
+// so that the transfer can be verified. It MUST only output that line, anything else breaks the check.
+var waitForLockAndDescribe = `
 while true; do
   if [ -f "$2" ]; then
 	sleep 10 1>&/dev/null
@@ -40,7 +60,11 @@ while true; do
   fi
   break
 done
-cat "$1"
+if [ ! -f "$1" ]; then
+  echo "$1 not found" 1>&2
+  exit 1
+fi
+echo "$(sha256sum "$1" | cut -d ' ' -f 1) $(stat -c %s "$1")"
 `
 
 type makefileData struct {
@@ -70,7 +94,12 @@ func renderMakefile(w io.Writer, md makefileData) error {
 	return t.Execute(w, md)
 }
 
+// LoadMakefileObjList returns the driver object list from the Makefile.in of the module sources.
+// When the module sources are a local archive, it is read from there, so that no network is needed.
 func LoadMakefileObjList(c builder.Config) (string, error) {
+	if info, err := os.Stat(c.ModuleFilePath); err == nil && info.Mode().IsRegular() {
+		return makefileObjListFromArchive(c.ModuleFilePath)
+	}
 	makefileUrl := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/driver/Makefile.in", c.RepoOrg, c.RepoName, c.ModuleFilePath)
 	resp, err := http.Get(makefileUrl)
 	if err != nil {
@@ -81,7 +110,42 @@ func LoadMakefileObjList(c builder.Config) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(string(parsedMakefile), "\n")
+	return parseMakefileObjList(string(parsedMakefile))
+}
+
+func makefileObjListFromArchive(archivePath string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("driver/Makefile.in not found into %s", archivePath)
+		}
+		if err != nil {
+			return "", err
+		}
+		if hdr.Name != "driver/Makefile.in" && !strings.HasSuffix(hdr.Name, "/driver/Makefile.in") {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return "", err
+		}
+		return parseMakefileObjList(string(content))
+	}
+}
+
+func parseMakefileObjList(makefile string) (string, error) {
+	lines := strings.Split(makefile, "\n")
 	for _, l := range lines {
 		if strings.HasPrefix(l, "@DRIVER_NAME@-y +=") {
 			return strings.Split(l, "@DRIVER_NAME@-y += ")[1], nil
@@ -162,3 +226,40 @@ func renderFillDriverConfig(w io.Writer, dd driverConfigData) error {
 	}
 	return parsed.Execute(w, dd)
 }
+
+// supportFiles renders, by file name, the files the build scripts read from the support directory of the build.
+func supportFiles(c builder.Config) (map[string]string, error) {
+	// Prepare driver config template
+	bufFillDriverConfig := bytes.NewBuffer(nil)
+	err := renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.ModuleFilePath, DriverName: c.DriverName, DeviceName: c.DeviceName})
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare makefile template
+	bufMakefile := bytes.NewBuffer(nil)
+	objList, err := LoadMakefileObjList(c)
+	if err != nil {
+		// Templates building from a local archive ship their own Makefile
+		if info, statErr := os.Stat(c.ModuleFilePath); statErr != nil || !info.Mode().IsRegular() {
+			return nil, err
+		}
+		logger.WithError(err).Debug("module Makefile not generated")
+	} else {
+		err = renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: c.DriverDir(), MakeObjList: objList})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(c.KernelConfigData)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"kernel.config":         string(configDecoded),
+		"module-Makefile":       bufMakefile.String(),
+		"fill-driver-config.sh": bufFillDriverConfig.String(),
+	}, nil
+}
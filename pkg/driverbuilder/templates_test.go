@@ -0,0 +1,31 @@
+package driverbuilder
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestLoadMakefileObjListFromArchive(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "module.tar.gz")
+	f, err := os.Create(archive)
+	assert.NilError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	content := "obj-m += @DRIVER_NAME@.o\n@DRIVER_NAME@-y += main.o dynamic_params_table.o\n"
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "libs-1.0/driver/Makefile.in", Mode: 0644, Size: int64(len(content))}))
+	_, err = tw.Write([]byte(content))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, gz.Close())
+	assert.NilError(t, f.Close())
+
+	objList, err := LoadMakefileObjList(builder.Config{Build: &builder.Build{ModuleFilePath: archive}})
+	assert.NilError(t, err)
+	assert.Equal(t, "main.o dynamic_params_table.o", objList)
+}
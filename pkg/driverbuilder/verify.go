@@ -0,0 +1,49 @@
+package driverbuilder
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"github.com/falcosecurity/driverkit/pkg/modinfo"
+	logger "github.com/sirupsen/logrus"
+)
+
+// verifyArtifacts checks that the artifacts copied back from the build are for the requested kernel.
+// Mismatching artifacts are removed, so that they cannot be loaded by mistake.
+func verifyArtifacts(v builder.Builder, b *builder.Build, kr kernelrelease.KernelRelease) error {
+	if len(b.ModuleOutPutFilePath) > 0 {
+		expected := b.KernelRelease
+		if vv, ok := v.(builder.VermagicBuilder); ok {
+			expected = vv.VermagicKernelRelease(kr)
+		}
+		err := verifyArtifact(b.ModuleOutPutFilePath, func(info *modinfo.Info) error {
+			return info.VerifyModule(expected, kernelrelease.Architecture(b.Architecture))
+		})
+		if err != nil {
+			return fmt.Errorf("kernel module verification failed: %v", err)
+		}
+	}
+	if len(b.ProbeFilePath) > 0 {
+		if err := verifyArtifact(b.ProbeFilePath, (*modinfo.Info).VerifyProbe); err != nil {
+			return fmt.Errorf("eBPF probe verification failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func verifyArtifact(path string, verify func(*modinfo.Info) error) error {
+	info, err := modinfo.Read(path)
+	if err == nil {
+		err = verify(info)
+	}
+	if err != nil {
+		if rmErr := os.Remove(path); rmErr != nil {
+			logger.WithError(rmErr).WithField("path", path).Warn("could not remove the mismatching artifact")
+		}
+		return err
+	}
+	logger.WithField("path", path).Debug("artifact verified")
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package driverkit is the library API of driverkit.
+//
+// It builds the drivers described by a BuildRequest with a pluggable Processor, and keeps no global state:
+// every option, online mode included, belongs to the request.
+package driverkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+)
+
+// Processor runs the builds, eg. driverbuilder.NewDockerBuildProcessor.
+type Processor = driverbuilder.BuildProcessor
+
+// Result holds the drivers a build produced.
+type Result struct {
+	// Module is the path of the kernel module, empty if it was not built
+	Module string
+	// Probe is the path of the eBPF probe, empty if it was not built
+	Probe string
+	// Manifest describes the build, nil if the processor did not write one
+	Manifest *driverbuilder.Manifest
+}
+
+// Client builds drivers with a processor.
+type Client struct {
+	processor Processor
+}
+
+// NewClient returns a client building with the given processor.
+func NewClient(processor Processor) *Client {
+	return &Client{processor: processor}
+}
+
+// Build validates the request and builds its drivers.
+//
+// Canceling ctx interrupts the build, and cleans up whatever the processor started for it.
+func (c *Client) Build(ctx context.Context, req *BuildRequest) (*Result, error) {
+	if c.processor == nil {
+		return nil, fmt.Errorf("missing processor")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := req.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := driverbuilder.Run(ctx, c.processor, b); err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	for _, p := range []struct {
+		path string
+		out  *string
+	}{{b.ModuleOutPutFilePath, &res.Module}, {b.ProbeFilePath, &res.Probe}} {
+		if len(p.path) == 0 {
+			continue
+		}
+		if _, err := os.Stat(p.path); err != nil {
+			continue
+		}
+		*p.out = p.path
+		if res.Manifest == nil {
+			res.Manifest = readManifest(p.path + driverbuilder.ManifestSuffix)
+		}
+	}
+	if len(res.Module) == 0 && len(res.Probe) == 0 {
+		return nil, fmt.Errorf("the build did not produce any driver")
+	}
+	return res, nil
+}
+
+func readManifest(path string) *driverbuilder.Manifest {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var m driverbuilder.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return &m
+}
@@ -0,0 +1,61 @@
+package driverkit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+// fakeProcessor writes the module source as the built module.
+type fakeProcessor struct {
+	build *builder.Build
+}
+
+func (p *fakeProcessor) String() string {
+	return "fake"
+}
+
+func (p *fakeProcessor) Start(_ context.Context, b *builder.Build) error {
+	p.build = b
+	data, err := os.ReadFile(b.ModuleFilePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.ModuleOutPutFilePath, data, 0644)
+}
+
+func TestClientBuild(t *testing.T) {
+	dir := t.TempDir()
+	moduleFile := filepath.Join(dir, "module.tar.gz")
+	assert.NilError(t, os.WriteFile(moduleFile, []byte("module"), 0644))
+
+	req := &BuildRequest{
+		Target:         "ubuntu",
+		KernelRelease:  "5.15.0-1019-aws",
+		KernelVersion:  "20",
+		Architecture:   "amd64",
+		ModuleFilePath: moduleFile,
+		OnlineMode:     true,
+		Output:         Output{Module: filepath.Join(dir, "{{ .KernelRelease }}", "falco.ko")},
+	}
+	p := &fakeProcessor{}
+	res, err := NewClient(p).Build(context.Background(), req)
+	assert.NilError(t, err)
+	assert.Equal(t, res.Module, filepath.Join(dir, "5.15.0-1019-aws", "falco.ko"))
+	assert.Equal(t, res.Probe, "")
+	assert.Assert(t, p.build.OnlineMode)
+	assert.DeepEqual(t, p.build.BuilderRepos, []string{DefaultBuilderRepo})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewClient(p).Build(ctx, req)
+	assert.Equal(t, err, context.Canceled)
+
+	req.Target = "unknown"
+	_, err = NewClient(p).Build(context.Background(), req)
+	assert.ErrorContains(t, err, "unknown")
+}
@@ -0,0 +1,269 @@
+package driverkit
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+)
+
+// DefaultBuilderRepo is always looked up for builder images, with the lowest priority.
+const DefaultBuilderRepo = "docker.io/falcosecurity/driverkit"
+
+// noKernelConfigData is the base64 encoded "no-data", used when no kernel config is given.
+const noKernelConfigData = "bm8tZGF0YQ=="
+
+// Output tells where to write the built drivers.
+//
+// Paths may contain the fields of builder.OutputNameData, eg. {{ .KernelRelease }}.
+// With a directory, both drivers are laid out into it, named after the Name preset or template.
+type Output struct {
+	Module string `json:"module,omitempty"`
+	Probe  string `json:"probe,omitempty"`
+	Dir    string `json:"dir,omitempty"`
+	Name   string `json:"name,omitempty"`
+	// Presets are output name presets, in addition to builder.OutputNamePresets
+	Presets map[string]string `json:"presets,omitempty"`
+}
+
+// Publish tells where to publish the built drivers, once built.
+type Publish struct {
+	// URL is an s3://<bucket>[/<prefix>] url
+	URL      string `json:"url,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	// OCIRef is the repository, and optionally the tag, to push the drivers to as an OCI artifact
+	OCIRef string `json:"ociRef,omitempty"`
+}
+
+// Timeouts bound the steps of the build, besides the build script itself bounded by the processor.
+// Zero means no timeout, other than the deadline of the context of the build.
+type Timeouts struct {
+	// Resolve bounds the lookup of the kernel headers and of the builder images
+	Resolve time.Duration `json:"resolve,omitempty"`
+	// Pull bounds the pull of the builder image
+	Pull time.Duration `json:"pull,omitempty"`
+	// Copy bounds the copy of each driver out of the builder
+	Copy time.Duration `json:"copy,omitempty"`
+	// Hook bounds each of the build hooks
+	Hook time.Duration `json:"hook,omitempty"`
+}
+
+// Hooks are the shell commands run around the build, described by the DRIVERKIT_* environment variables.
+type Hooks struct {
+	// Pre runs before the build, failing the build when it fails
+	Pre string `json:"pre,omitempty"`
+	// Post runs once the build is done or failed, telling which with DRIVERKIT_RESULT
+	Post string `json:"post,omitempty"`
+}
+
+// BuildRequest describes the drivers to build.
+type BuildRequest struct {
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelRelease"`
+	KernelVersion string `json:"kernelVersion"`
+	Architecture  string `json:"architecture"`
+	// KernelConfigData is the base64 encoded kernel config, required by some targets (eg. vanilla)
+	KernelConfigData string `json:"kernelConfigData,omitempty"`
+
+	// ModuleFilePath is the tar.gz of the kernel module source code
+	ModuleFilePath   string `json:"moduleFilePath"`
+	ModuleDriverName string `json:"moduleDriverName,omitempty"`
+	ModuleDeviceName string `json:"moduleDeviceName,omitempty"`
+
+	BuilderImage string `json:"builderImage,omitempty"`
+	// BuilderRepos are looked up for builder images in descending priority order, before DefaultBuilderRepo
+	BuilderRepos []string `json:"builderRepos,omitempty"`
+	GCCVersion   string   `json:"gccVersion,omitempty"`
+	KernelUrls   []string `json:"kernelUrls,omitempty"`
+	RepoOrg      string   `json:"repoOrg,omitempty"`
+	RepoName     string   `json:"repoName,omitempty"`
+
+	// OnlineMode fetches the builder images and the kernel headers from the internet,
+	// instead of looking them up locally (see LocalKernelDir)
+	OnlineMode     bool   `json:"onlineMode"`
+	LocalKernelDir string `json:"localKernelDir,omitempty"`
+
+	Output   Output   `json:"output"`
+	Publish  Publish  `json:"publish,omitempty"`
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	Hooks    Hooks    `json:"hooks,omitempty"`
+
+	// Events receives the progress of the build (disabled if nil)
+	Events builder.EventHandler `json:"-"`
+
+	CacheDir           string `json:"cacheDir,omitempty"`
+	KernelHeadersCache string `json:"kernelHeadersCache,omitempty"`
+	RepositoryDir      string `json:"repositoryDir,omitempty"`
+	BuildLog           string `json:"buildLog,omitempty"`
+	Diagnostics        string `json:"diagnostics,omitempty"`
+	// DiagnosticsFormat is either json (default) or sarif
+	DiagnosticsFormat string `json:"diagnosticsFormat,omitempty"`
+}
+
+// Validate checks that the request describes a build.
+func (r *BuildRequest) Validate() error {
+	switch {
+	case len(r.Target) == 0:
+		return fmt.Errorf("missing target")
+	case len(r.KernelRelease) == 0:
+		return fmt.Errorf("missing kernel release")
+	case len(r.Architecture) == 0:
+		return fmt.Errorf("missing architecture")
+	case len(r.ModuleFilePath) == 0:
+		return fmt.Errorf("missing module source")
+	}
+	if _, err := builder.Factory(builder.Type(r.Target)); err != nil {
+		return err
+	}
+	if _, ok := kernelrelease.SupportedArchs[kernelrelease.Architecture(r.Architecture)]; !ok {
+		return fmt.Errorf("unsupported architecture %q, expected one of %s", r.Architecture, kernelrelease.SupportedArchs.String())
+	}
+	if len(r.Output.Dir) > 0 && (len(r.Output.Module) > 0 || len(r.Output.Probe) > 0) {
+		return fmt.Errorf("the output directory cannot be used along with the output paths")
+	}
+	if len(r.Output.Dir) == 0 && len(r.Output.Module) == 0 && len(r.Output.Probe) == 0 {
+		return fmt.Errorf("missing output")
+	}
+	if err := r.ValidateOutput(); err != nil {
+		return err
+	}
+
+	for _, u := range r.KernelUrls {
+		// The urls end up into the build script
+		if uu, err := url.Parse(u); err != nil || (uu.Scheme != "http" && uu.Scheme != "https") || len(uu.Host) == 0 {
+			return fmt.Errorf("invalid kernel url %q, expected an http(s) url", u)
+		}
+	}
+	if len(r.GCCVersion) > 0 {
+		if _, err := semver.ParseTolerant(r.GCCVersion); err != nil {
+			return fmt.Errorf("invalid gcc version %q: %w", r.GCCVersion, err)
+		}
+	}
+
+	kr, err := kernelrelease.Parse(r.KernelRelease)
+	if err != nil {
+		return err
+	}
+	kr.Architecture = kernelrelease.Architecture(r.Architecture)
+	if !kr.SupportsModule() && !kr.SupportsProbe() {
+		return fmt.Errorf("both module and probe are not supported by given options")
+	}
+	return nil
+}
+
+// ValidateOutput checks that the output paths and the output name render.
+func (r *BuildRequest) ValidateOutput() error {
+	_, err := r.outputs()
+	return err
+}
+
+// outputs returns a build holding the module and probe output paths, with their template fields filled.
+func (r *BuildRequest) outputs() (*builder.Build, error) {
+	b := &builder.Build{
+		TargetType:       builder.Type(r.Target),
+		KernelRelease:    r.KernelRelease,
+		KernelVersion:    r.KernelVersion,
+		Architecture:     r.Architecture,
+		ModuleDriverName: r.ModuleDriverName,
+	}
+	if len(r.Output.Dir) > 0 {
+		nameTemplate, err := builder.OutputNameTemplate(r.Output.Name, r.Output.Presets)
+		if err != nil {
+			return nil, err
+		}
+		return b, b.SetOutputDir(r.Output.Dir, nameTemplate)
+	}
+	var err error
+	if b.ModuleOutPutFilePath, err = b.RenderOutputPath(r.Output.Module); err != nil {
+		return nil, err
+	}
+	if b.ProbeFilePath, err = b.RenderOutputPath(r.Output.Probe); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Build turns the request into the build handed to the build processors.
+//
+// It creates the directories of the output paths, and drops the drivers the kernel does not support.
+func (r *BuildRequest) Build() (*builder.Build, error) {
+	outputs, err := r.outputs()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range []string{outputs.ModuleOutPutFilePath, outputs.ProbeFilePath} {
+		if len(p) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, fmt.Errorf("could not create the output directory: %v", err)
+		}
+	}
+
+	kernelConfigData := r.KernelConfigData
+	if len(kernelConfigData) == 0 {
+		kernelConfigData = noKernelConfigData
+	}
+
+	b := &builder.Build{
+		TargetType:           builder.Type(r.Target),
+		ModuleFilePath:       r.ModuleFilePath,
+		KernelVersion:        r.KernelVersion,
+		KernelRelease:        r.KernelRelease,
+		Architecture:         r.Architecture,
+		KernelConfigData:     kernelConfigData,
+		ModuleOutPutFilePath: outputs.ModuleOutPutFilePath,
+		ProbeFilePath:        outputs.ProbeFilePath,
+		ModuleDriverName:     r.ModuleDriverName,
+		ModuleDeviceName:     r.ModuleDeviceName,
+		GCCVersion:           r.GCCVersion,
+		BuilderImage:         r.BuilderImage,
+		KernelUrls:           r.KernelUrls,
+		RepoOrg:              r.RepoOrg,
+		RepoName:             r.RepoName,
+		OnlineMode:           r.OnlineMode,
+		LocalKernelDir:       r.LocalKernelDir,
+		CacheDir:             r.CacheDir,
+		KernelHeadersCache:   r.KernelHeadersCache,
+		BuildLogPath:         r.BuildLog,
+		DiagnosticsPath:      r.Diagnostics,
+		DiagnosticsFormat:    r.DiagnosticsFormat,
+		PublishURL:           r.Publish.URL,
+		PublishEndpoint:      r.Publish.Endpoint,
+		PublishRegion:        r.Publish.Region,
+		PushOCIRef:           r.Publish.OCIRef,
+		RepositoryDir:        r.RepositoryDir,
+		ResolveTimeout:       r.Timeouts.Resolve,
+		PullTimeout:          r.Timeouts.Pull,
+		CopyTimeout:          r.Timeouts.Copy,
+		PreBuildHook:         r.Hooks.Pre,
+		PostBuildHook:        r.Hooks.Post,
+		HookTimeout:          r.Timeouts.Hook,
+		Events:               r.Events,
+	}
+
+	// The default repo has the lowest priority
+	b.BuilderRepos = append(append([]string{}, r.BuilderRepos...), DefaultBuilderRepo)
+
+	// attempt the build in case it comes from an invalid config
+	kr, err := b.KernelReleaseFromBuildConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(b.ModuleOutPutFilePath) > 0 && !kr.SupportsModule() {
+		b.ModuleOutPutFilePath = ""
+		logger.Warningf("Skipping build attempt of module for unsupported kernel version %s", kr.String())
+	}
+	if len(b.ProbeFilePath) > 0 && !kr.SupportsProbe() {
+		b.ProbeFilePath = ""
+		logger.Warningf("Skipping build attempt of probe for unsupported kernel version %s", kr.String())
+	}
+	return b, nil
+}
@@ -2,7 +2,6 @@ package kernelrelease
 
 import (
 	"fmt"
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
@@ -90,10 +89,39 @@ type KernelRelease struct {
 	Architecture     Architecture
 }
 
+// ParseError is returned for kernel releases not in the expected format.
+type ParseError struct {
+	KernelRelease string
+	Err           error
+}
+
+func (e *ParseError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("invalid kernel release %q", e.KernelRelease)
+	}
+	return fmt.Sprintf("invalid kernel release %q: %v", e.KernelRelease, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // FromString extracts a KernelRelease object from string.
+//
+// It is lenient: the parts that cannot be parsed are left empty, use Parse to reject them.
 func FromString(kernelVersionStr string) KernelRelease {
+	kv, _ := Parse(kernelVersionStr)
+	return kv
+}
+
+// Parse extracts a KernelRelease object from string, returning a *ParseError when not in the expected format.
+func Parse(kernelVersionStr string) (KernelRelease, error) {
 	kv := KernelRelease{}
 	match := kernelVersionPattern.FindStringSubmatch(kernelVersionStr)
+	if match == nil {
+		return kv, &ParseError{KernelRelease: kernelVersionStr}
+	}
+	var parseErr error
 	for i, name := range kernelVersionPattern.SubexpNames() {
 		if i > 0 && i <= len(match) {
 			var err error
@@ -116,12 +144,12 @@ func FromString(kernelVersionStr string) KernelRelease {
 				kv.FullExtraversion = match[i]
 			}
 
-			if err != nil {
-				log.Fatal(err)
+			if err != nil && parseErr == nil {
+				parseErr = &ParseError{KernelRelease: kernelVersionStr, Err: err}
 			}
 		}
 	}
-	return kv
+	return kv, parseErr
 }
 
 func (k *KernelRelease) SupportsModule() bool {
@@ -1,6 +1,7 @@
 package kernelrelease
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/blang/semver"
@@ -217,6 +218,19 @@ func TestFromString(t *testing.T) {
 	}
 }
 
+func TestParse(t *testing.T) {
+	kr, err := Parse("5.5.2-arch1-1")
+	assert.NilError(t, err)
+	assert.Equal(t, kr.Fullversion, "5.5.2")
+
+	for _, s := range []string{"", "latest", "99999999999999999999.1.0"} {
+		_, err := Parse(s)
+		var parseErr *ParseError
+		assert.Assert(t, errors.As(err, &parseErr), s)
+		assert.Equal(t, parseErr.KernelRelease, s)
+	}
+}
+
 func TestSupportsModule(t *testing.T) {
 	unsupported := []KernelRelease{
 		{
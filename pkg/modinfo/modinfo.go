@@ -0,0 +1,216 @@
+// Package modinfo reads the metadata of the built drivers straight from their ELF files.
+package modinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// moduleSignatureMagic terminates the signed kernel modules.
+const moduleSignatureMagic = "~Module signature appended~\n"
+
+// moduleSignatureInfoSize is the size of the struct module_signature preceding the magic.
+const moduleSignatureInfoSize = 12
+
+// Info is the metadata of a kernel module (or of an eBPF probe, that has no .modinfo section).
+type Info struct {
+	Machine elf.Machine
+	Class   elf.Class
+	// Fields are the key=value pairs of the .modinfo section; a key may appear more than once (eg. parm).
+	Fields   map[string][]string
+	Sections []Section
+	// License is the content of the license section of eBPF probes.
+	License string
+	// Signature is nil for unsigned modules.
+	Signature *Signature
+}
+
+// Section is an ELF section.
+type Section struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size uint64 `json:"size"`
+}
+
+// Signature describes the signature appended to a kernel module.
+type Signature struct {
+	// Type is the type of the signer identifier, eg. PKCS#7
+	Type string `json:"type"`
+	// Length is the size of the signature, in bytes
+	Length uint32 `json:"length"`
+}
+
+// Read parses the ELF file at path.
+func Read(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info, err := Parse(f, stat.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return info, nil
+}
+
+// Parse parses an ELF file of the given size.
+func Parse(r io.ReaderAt, size int64) (*Info, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &Info{
+		Machine: f.Machine,
+		Class:   f.Class,
+		Fields:  make(map[string][]string),
+	}
+	for _, s := range f.Sections {
+		if len(s.Name) == 0 {
+			continue
+		}
+		info.Sections = append(info.Sections, Section{Name: s.Name, Type: s.Type.String(), Size: s.Size})
+	}
+	if license := f.Section("license"); license != nil {
+		data, err := license.Data()
+		if err != nil {
+			return nil, fmt.Errorf("could not read the license section: %v", err)
+		}
+		info.License = string(bytes.TrimRight(data, "\x00"))
+	}
+	info.Signature, err = parseSignature(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	section := f.Section(".modinfo")
+	if section == nil {
+		return info, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the .modinfo section: %v", err)
+	}
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		key, value, ok := strings.Cut(string(entry), "=")
+		if !ok {
+			continue
+		}
+		info.Fields[key] = append(info.Fields[key], value)
+	}
+	return info, nil
+}
+
+// parseSignature reads the struct module_signature appended, along with the magic, to the signed modules.
+func parseSignature(r io.ReaderAt, size int64) (*Signature, error) {
+	trailerSize := int64(moduleSignatureInfoSize + len(moduleSignatureMagic))
+	if size < trailerSize {
+		return nil, nil
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-trailerSize); err != nil {
+		return nil, fmt.Errorf("could not read the module signature: %v", err)
+	}
+	if string(trailer[moduleSignatureInfoSize:]) != moduleSignatureMagic {
+		return nil, nil
+	}
+	// struct module_signature { u8 algo, hash, id_type, signer_len, key_id_len; u8 pad[3]; __be32 sig_len; }
+	sig := &Signature{Length: binary.BigEndian.Uint32(trailer[8:moduleSignatureInfoSize])}
+	switch trailer[2] {
+	case 0:
+		sig.Type = "PGP"
+	case 1:
+		sig.Type = "X.509"
+	case 2:
+		sig.Type = "PKCS#7"
+	default:
+		sig.Type = fmt.Sprintf("unknown (%d)", trailer[2])
+	}
+	return sig, nil
+}
+
+// HasBTF tells whether the file carries BTF type information.
+func (i *Info) HasBTF() bool {
+	for _, s := range i.Sections {
+		if s.Name == ".BTF" {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the first value of key, or an empty string.
+func (i *Info) Get(key string) string {
+	if values := i.Fields[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Vermagic returns the version magic of the module, eg. "5.15.0-1019-aws SMP mod_unload modversions".
+func (i *Info) Vermagic() string {
+	return i.Get("vermagic")
+}
+
+// KernelRelease returns the kernel release the module was built for, ie. the first field of its vermagic.
+func (i *Info) KernelRelease() string {
+	fields := strings.Fields(i.Vermagic())
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Architecture returns the kernel architecture the file was built for,
+// or an empty string when it is not a supported one (eg. for eBPF probes).
+func (i *Info) Architecture() kernelrelease.Architecture {
+	switch i.Machine {
+	case elf.EM_X86_64:
+		return kernelrelease.ArchitectureAmd64
+	case elf.EM_AARCH64:
+		return kernelrelease.ArchitectureArm64
+	}
+	return ""
+}
+
+// IsBPF tells whether the file is an eBPF object.
+func (i *Info) IsBPF() bool {
+	return i.Machine == elf.EM_BPF
+}
+
+// VerifyModule checks that the kernel module was built for the given kernel release and architecture.
+// An empty kernel release is not checked.
+func (i *Info) VerifyModule(kernelRelease string, arch kernelrelease.Architecture) error {
+	if i.Vermagic() == "" {
+		return fmt.Errorf("not a kernel module: no vermagic found")
+	}
+	if len(kernelRelease) > 0 && i.KernelRelease() != kernelRelease {
+		return fmt.Errorf("module built for kernel %s (vermagic %q), expected %s: it was likely built against the headers of another kernel flavor",
+			i.KernelRelease(), i.Vermagic(), kernelRelease)
+	}
+	if got := i.Architecture(); got != arch {
+		return fmt.Errorf("module built for architecture %q (%s), expected %s", got, i.Machine, arch)
+	}
+	return nil
+}
+
+// VerifyProbe checks that the file is an eBPF probe.
+func (i *Info) VerifyProbe() error {
+	if !i.IsBPF() {
+		return fmt.Errorf("not an eBPF probe: machine is %s", i.Machine)
+	}
+	return nil
+}
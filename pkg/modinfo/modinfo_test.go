@@ -0,0 +1,125 @@
+package modinfo
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"gotest.tools/assert"
+)
+
+type testSection struct {
+	name string
+	data []byte
+}
+
+// testELF builds a minimal relocatable ELF64 file, with the given sections.
+func testELF(machine elf.Machine, sections ...testSection) []byte {
+	const headerSize = 64
+	const sectionHeaderSize = 64
+
+	shstrtab := []byte{0}
+	var body bytes.Buffer
+	headers := []elf.Section64{{}}
+	for _, s := range sections {
+		headers = append(headers, elf.Section64{
+			Name:      uint32(len(shstrtab)),
+			Type:      uint32(elf.SHT_PROGBITS),
+			Off:       uint64(headerSize + body.Len()),
+			Size:      uint64(len(s.data)),
+			Addralign: 1,
+		})
+		shstrtab = append(shstrtab, s.name+"\x00"...)
+		body.Write(s.data)
+	}
+	headers = append(headers, elf.Section64{
+		Name:      uint32(len(shstrtab)),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       uint64(headerSize + body.Len()),
+		Addralign: 1,
+	})
+	shstrtab = append(shstrtab, ".shstrtab\x00"...)
+	headers[len(headers)-1].Size = uint64(len(shstrtab))
+	body.Write(shstrtab)
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(headerSize + body.Len()),
+		Ehsize:    headerSize,
+		Shentsize: sectionHeaderSize,
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(len(headers) - 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, hdr)
+	out.Write(body.Bytes())
+	for _, h := range headers {
+		binary.Write(&out, binary.LittleEndian, h)
+	}
+	return out.Bytes()
+}
+
+func parse(t *testing.T, data []byte) *Info {
+	info, err := Parse(bytes.NewReader(data), int64(len(data)))
+	assert.NilError(t, err)
+	return info
+}
+
+func TestParse(t *testing.T) {
+	modinfo := []byte("license=GPL\x00parm=a:int\x00parm=b:int\x00vermagic=5.15.0-1019-aws SMP mod_unload modversions \x00\x00")
+	info := parse(t, testELF(elf.EM_X86_64, testSection{".modinfo", modinfo}))
+
+	assert.Equal(t, info.Get("license"), "GPL")
+	assert.DeepEqual(t, info.Fields["parm"], []string{"a:int", "b:int"})
+	assert.Equal(t, info.KernelRelease(), "5.15.0-1019-aws")
+	assert.Equal(t, info.Architecture(), kernelrelease.Architecture(kernelrelease.ArchitectureAmd64))
+
+	assert.NilError(t, info.VerifyModule("5.15.0-1019-aws", kernelrelease.ArchitectureAmd64))
+	assert.NilError(t, info.VerifyModule("", kernelrelease.ArchitectureAmd64))
+	assert.ErrorContains(t, info.VerifyModule("5.15.0-1019-gcp", kernelrelease.ArchitectureAmd64), "module built for kernel 5.15.0-1019-aws")
+	assert.ErrorContains(t, info.VerifyModule("5.15.0-1019-aws", kernelrelease.ArchitectureArm64), "expected arm64")
+	assert.ErrorContains(t, info.VerifyProbe(), "not an eBPF probe")
+	assert.Assert(t, info.Signature == nil)
+}
+
+func TestParseSignature(t *testing.T) {
+	data := testELF(elf.EM_AARCH64, testSection{".modinfo", []byte("vermagic=6.1.0 SMP\x00")})
+	data = append(data, make([]byte, 0x1f0)...)
+	// PKCS#7 signature of 0x1f0 bytes
+	data = append(data, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xf0)
+	data = append(data, moduleSignatureMagic...)
+
+	info := parse(t, data)
+	assert.Equal(t, info.KernelRelease(), "6.1.0")
+	assert.DeepEqual(t, info.Signature, &Signature{Type: "PKCS#7", Length: 0x1f0})
+}
+
+func TestParseProbe(t *testing.T) {
+	info := parse(t, testELF(elf.EM_BPF,
+		testSection{"license", []byte("GPL\x00")},
+		testSection{"tracepoint/syscalls/sys_enter", []byte{0, 0, 0, 0}},
+		testSection{".BTF", []byte{0}},
+	))
+
+	assert.Equal(t, len(info.Fields), 0)
+	assert.Equal(t, info.License, "GPL")
+	assert.Assert(t, info.HasBTF())
+	assert.Equal(t, len(info.Sections), 4)
+	assert.DeepEqual(t, info.Sections[1], Section{Name: "tracepoint/syscalls/sys_enter", Type: "SHT_PROGBITS", Size: 4})
+	assert.NilError(t, info.VerifyProbe())
+	assert.ErrorContains(t, info.VerifyModule("5.15.0", kernelrelease.ArchitectureAmd64), "not a kernel module")
+}
+
+func TestParseNotELF(t *testing.T) {
+	_, err := Parse(bytes.NewReader([]byte("not an elf file")), 15)
+	assert.Assert(t, err != nil)
+}
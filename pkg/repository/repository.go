@@ -0,0 +1,378 @@
+// Package repository implements the driverkit artifact repository: a directory holding every built driver,
+// indexed by a SQLite database, so that builds for kernels already covered can be skipped.
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// IndexFileName is the name of the index database, into the repository directory.
+	IndexFileName = "index.db"
+
+	// KindModule and KindProbe are the kinds of the artifacts.
+	KindModule = "module"
+	KindProbe  = "probe"
+
+	// manifestSuffix names the build manifests stored next to the artifacts.
+	manifestSuffix = ".manifest.json"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS artifacts (
+	target         TEXT NOT NULL,
+	kernel_release TEXT NOT NULL,
+	kernel_version TEXT NOT NULL,
+	arch           TEXT NOT NULL,
+	module_version TEXT NOT NULL,
+	kind           TEXT NOT NULL,
+	sha256         TEXT NOT NULL,
+	size           INTEGER NOT NULL,
+	path           TEXT NOT NULL,
+	created_at     INTEGER NOT NULL,
+	PRIMARY KEY (target, kernel_release, kernel_version, arch, module_version, kind)
+);
+`
+
+// Key identifies the drivers built for a kernel out of a module source.
+type Key struct {
+	Target        string `json:"target"`
+	KernelRelease string `json:"kernelRelease"`
+	KernelVersion string `json:"kernelVersion"`
+	Arch          string `json:"arch"`
+	// ModuleVersion identifies the module source, see ModuleVersion
+	ModuleVersion string `json:"moduleVersion"`
+}
+
+// KeyFromBuild returns the key of the drivers of the build.
+func KeyFromBuild(b *builder.Build) Key {
+	return Key{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		KernelVersion: b.KernelVersion,
+		Arch:          b.Architecture,
+		ModuleVersion: ModuleVersion(b.ModuleFilePath),
+	}
+}
+
+func (k Key) dir() string {
+	return filepath.Join(k.Target, k.KernelRelease, k.KernelVersion, k.Arch, k.ModuleVersion)
+}
+
+// Entry is an artifact of the repository.
+type Entry struct {
+	Key
+	Kind   string `json:"kind"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Path is relative to the repository directory
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Filter selects entries: empty fields match any value.
+type Filter struct {
+	Key
+	Kind string
+	// OlderThan, if not zero, selects the entries created before it
+	OlderThan time.Time
+}
+
+func (f Filter) where() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for _, c := range []struct {
+		column string
+		value  string
+	}{
+		{"target", f.Target},
+		{"kernel_release", f.KernelRelease},
+		{"kernel_version", f.KernelVersion},
+		{"arch", f.Arch},
+		{"module_version", f.ModuleVersion},
+		{"kind", f.Kind},
+	} {
+		if len(c.value) > 0 {
+			clauses = append(clauses, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if !f.OlderThan.IsZero() {
+		clauses = append(clauses, "created_at < ?")
+		args = append(args, f.OlderThan.UnixNano())
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// ModuleVersion identifies a module source: the short hash of the module tarball,
+// or the short hash of the module reference itself when it is not a local file,
+// since it names a directory of the repository.
+func ModuleVersion(moduleFilePath string) string {
+	f, err := os.Open(moduleFilePath)
+	if err == nil {
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err == nil {
+			return hex.EncodeToString(h.Sum(nil))[:12]
+		}
+	}
+	sum := sha256.Sum256([]byte(moduleFilePath))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Repository is an artifact repository rooted at a directory.
+type Repository struct {
+	dir string
+	db  *sql.DB
+}
+
+// Open opens the repository at dir, creating it if needed.
+func Open(dir string) (*Repository, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", filepath.Join(dir, IndexFileName)+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize the repository index: %v", err)
+	}
+	return &Repository{dir: dir, db: db}, nil
+}
+
+// Close closes the repository index.
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// Dir returns the repository directory.
+func (r *Repository) Dir() string {
+	return r.dir
+}
+
+// Path returns the absolute path of the artifact of the entry.
+func (r *Repository) Path(e Entry) string {
+	return filepath.Join(r.dir, e.Path)
+}
+
+// ManifestPath returns the path of the build manifest of the entry, empty if there is none.
+func (r *Repository) ManifestPath(e Entry) string {
+	p := r.Path(e) + manifestSuffix
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// Add copies the artifact, and its build manifest if any, into the repository.
+// It replaces the artifact of the same kind previously built for the key, if any.
+func (r *Repository) Add(key Key, kind string, file string, sum string) (*Entry, error) {
+	name := builder.ModuleFileName
+	if kind == KindProbe {
+		name = builder.ProbeFileName
+	}
+	e := Entry{Key: key, Kind: kind, SHA256: sum, Path: filepath.Join(key.dir(), name), CreatedAt: time.Now().UTC()}
+	dst := r.Path(e)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+	size, err := copyFile(file, dst)
+	if err != nil {
+		return nil, err
+	}
+	e.Size = size
+	if _, err := os.Stat(file + manifestSuffix); err == nil {
+		if _, err := copyFile(file+manifestSuffix, dst+manifestSuffix); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = r.db.Exec(`INSERT OR REPLACE INTO artifacts
+		(target, kernel_release, kernel_version, arch, module_version, kind, sha256, size, path, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Target, e.KernelRelease, e.KernelVersion, e.Arch, e.ModuleVersion, e.Kind, e.SHA256, e.Size, e.Path, e.CreatedAt.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("path", dst).Debug("artifact added to the repository")
+	return &e, nil
+}
+
+// List returns the entries matching the filter, the most recent first.
+func (r *Repository) List(f Filter) ([]Entry, error) {
+	where, args := f.where()
+	rows, err := r.db.Query(`SELECT target, kernel_release, kernel_version, arch, module_version, kind, sha256, size, path, created_at
+		FROM artifacts`+where+` ORDER BY created_at DESC, target, kernel_release, kernel_version, arch, kind`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var createdAt int64
+		if err := rows.Scan(&e.Target, &e.KernelRelease, &e.KernelVersion, &e.Arch, &e.ModuleVersion, &e.Kind, &e.SHA256, &e.Size, &e.Path, &createdAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = time.Unix(0, createdAt).UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Get returns the artifact of the given kind built for the key, nil if there is none.
+func (r *Repository) Get(key Key, kind string) (*Entry, error) {
+	entries, err := r.List(Filter{Key: key, Kind: kind})
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// Remove deletes the entry, along with its artifact and build manifest.
+func (r *Repository) Remove(e Entry) error {
+	for _, p := range []string{r.Path(e), r.Path(e) + manifestSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Clean up the key directories left empty, up to the repository directory
+	for dir := filepath.Dir(r.Path(e)); dir != r.dir && strings.HasPrefix(dir, r.dir); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	_, err := r.db.Exec(`DELETE FROM artifacts
+		WHERE target = ? AND kernel_release = ? AND kernel_version = ? AND arch = ? AND module_version = ? AND kind = ?`,
+		e.Target, e.KernelRelease, e.KernelVersion, e.Arch, e.ModuleVersion, e.Kind)
+	return err
+}
+
+// Prune removes the entries matching the filter, as well as the entries whose artifact went missing.
+// It returns the removed entries.
+func (r *Repository) Prune(f Filter) ([]Entry, error) {
+	all, err := r.List(Filter{})
+	if err != nil {
+		return nil, err
+	}
+	matching := map[string]bool{}
+	if f != (Filter{}) {
+		entries, err := r.List(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			matching[e.Path] = true
+		}
+	}
+	removed := []Entry{}
+	for _, e := range all {
+		if !matching[e.Path] {
+			if _, err := os.Stat(r.Path(e)); err == nil || !os.IsNotExist(err) {
+				continue
+			}
+		}
+		if err := r.Remove(e); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// Export writes the entries, their build manifests and an index.json listing them, as a tar.gz archive.
+// The archive lays out the artifacts as the repository directory does.
+func (r *Repository) Export(w io.Writer, entries []Entry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		files := []string{e.Path}
+		if len(r.ManifestPath(e)) > 0 {
+			files = append(files, e.Path+manifestSuffix)
+		}
+		for _, name := range files {
+			if err := addToTar(tw, filepath.Join(r.dir, name), filepath.ToSlash(name)); err != nil {
+				return err
+			}
+		}
+	}
+	index, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "index.json", Mode: 0644, Size: int64(len(index)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(index); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addToTar(tw *tar.Writer, path string, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// copyFile copies src to dst through a temporary file, so that dst is never partially written.
+func copyFile(src string, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, dst)
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestRepository(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := Open(filepath.Join(dir, "repo"))
+	assert.NilError(t, err)
+	defer repo.Close()
+
+	write := func(name string, content string) string {
+		p := filepath.Join(dir, name)
+		assert.NilError(t, os.WriteFile(p, []byte(content), 0644))
+		return p
+	}
+	module := write("falco.ko", "module")
+	write("falco.ko.manifest.json", "{}")
+	probe := write("falco.o", "probe")
+
+	ubuntu := Key{Target: "ubuntu", KernelRelease: "5.15.0-1019-aws", KernelVersion: "20", Arch: "amd64", ModuleVersion: "abc"}
+	debian := Key{Target: "debian", KernelRelease: "5.10.0-16-amd64", KernelVersion: "1", Arch: "amd64", ModuleVersion: "abc"}
+	_, err = repo.Add(ubuntu, KindModule, module, "sum-module")
+	assert.NilError(t, err)
+	_, err = repo.Add(ubuntu, KindProbe, probe, "sum-probe")
+	assert.NilError(t, err)
+	_, err = repo.Add(debian, KindModule, module, "sum-module")
+	assert.NilError(t, err)
+	// Rebuilds replace the previous entry
+	_, err = repo.Add(debian, KindModule, module, "sum-module")
+	assert.NilError(t, err)
+
+	entries, err := repo.List(Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 3)
+
+	e, err := repo.Get(ubuntu, KindModule)
+	assert.NilError(t, err)
+	assert.Equal(t, e.Size, int64(6))
+	assert.Equal(t, e.Path, filepath.Join("ubuntu", "5.15.0-1019-aws", "20", "amd64", "abc", "module.ko"))
+	assert.Assert(t, len(repo.ManifestPath(*e)) > 0)
+	e, err = repo.Get(ubuntu, KindProbe)
+	assert.NilError(t, err)
+	assert.Equal(t, repo.ManifestPath(*e), "")
+	e, err = repo.Get(Key{Target: "ubuntu", KernelRelease: "5.15.0-1020-aws"}, KindModule)
+	assert.NilError(t, err)
+	assert.Assert(t, e == nil)
+
+	var buf bytes.Buffer
+	assert.NilError(t, repo.Export(&buf, entries))
+	gr, err := gzip.NewReader(&buf)
+	assert.NilError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	assert.DeepEqual(t, names, []string{
+		"debian/5.10.0-16-amd64/1/amd64/abc/module.ko",
+		"debian/5.10.0-16-amd64/1/amd64/abc/module.ko.manifest.json",
+		"index.json",
+		"ubuntu/5.15.0-1019-aws/20/amd64/abc/module.ko",
+		"ubuntu/5.15.0-1019-aws/20/amd64/abc/module.ko.manifest.json",
+		"ubuntu/5.15.0-1019-aws/20/amd64/abc/probe.o",
+	})
+
+	// Without a filter, only the entries whose artifact went missing are pruned
+	e, err = repo.Get(ubuntu, KindProbe)
+	assert.NilError(t, err)
+	assert.NilError(t, os.Remove(repo.Path(*e)))
+	removed, err := repo.Prune(Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(removed), 1)
+	assert.Equal(t, removed[0].Kind, KindProbe)
+
+	removed, err = repo.Prune(Filter{OlderThan: time.Now().Add(-time.Hour)})
+	assert.NilError(t, err)
+	assert.Equal(t, len(removed), 0)
+	removed, err = repo.Prune(Filter{Key: Key{Target: "debian"}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(removed), 1)
+	_, err = os.Stat(filepath.Join(repo.Dir(), "debian"))
+	assert.Assert(t, os.IsNotExist(err), "empty directories are removed")
+
+	entries, err = repo.List(Filter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Target, "ubuntu")
+}
+
+func TestModuleVersion(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "libs.tar.gz")
+	assert.NilError(t, os.WriteFile(module, []byte("module source"), 0644))
+	assert.Equal(t, ModuleVersion(module), "9bd535ac63d7")
+
+	// References that are not local files are hashed too, since they name a directory
+	v := ModuleVersion("https://github.com/falcosecurity/libs/archive/master.tar.gz")
+	assert.Equal(t, len(v), 12)
+	assert.Assert(t, !strings.ContainsAny(v, "/:."))
+}
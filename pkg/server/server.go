@@ -0,0 +1,491 @@
+// Package server implements the driverkit build service: a REST API queueing build requests
+// onto a build processor, and serving back their status, logs and artifacts.
+//
+// The API is:
+//   - POST /v1/builds: submits a build, as a multipart form with the "spec" JSON field and the "module" tarball file
+//   - GET /v1/builds: lists the builds
+//   - GET /v1/builds/<id>: returns the status of the build
+//   - DELETE /v1/builds/<id>: cancels the build, either queued or running
+//   - GET /v1/builds/<id>/log: returns the output of the build script, so far
+//   - GET /v1/builds/<id>/diagnostics: returns the diagnosis of the failed build
+//   - GET /v1/builds/<id>/artifacts/<path>: downloads an artifact listed into the build status, by its path relative to the artifacts
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// DefaultMaxUploadSize bounds the size of the submitted module tarballs.
+	DefaultMaxUploadSize = 256 << 20
+	// DefaultJobRetention is how long finished jobs are kept.
+	DefaultJobRetention = 24 * time.Hour
+	// DefaultMaxFinishedJobs bounds the number of finished jobs kept.
+	DefaultMaxFinishedJobs = 1000
+
+	// pruneInterval is how often the finished jobs past the retention are looked for
+	pruneInterval = time.Minute
+
+	buildLogFileName    = "build.log"
+	moduleFileName      = "module.tar.gz"
+	artifactsDirName    = "artifacts"
+	diagnosticsFileName = "diagnostics.json"
+)
+
+// Status is the state of a build job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// BuildFunc turns a submitted build spec into the build to run.
+// The module tarball was saved to moduleFile, and the artifacts must be written into outputDir.
+type BuildFunc func(spec json.RawMessage, moduleFile string, outputDir string) (*builder.Build, error)
+
+// ValidationError is returned by a BuildFunc rejecting the spec.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Job is a build submitted to the server.
+type Job struct {
+	ID         string          `json:"id"`
+	Status     Status          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Spec       json.RawMessage `json:"spec"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	// Artifacts are the names of the files downloadable once the build succeeded
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	dir   string
+	build *builder.Build
+	// cancel interrupts the build, once running
+	cancel context.CancelFunc
+}
+
+// Server queues the submitted builds onto a bounded pool of workers.
+type Server struct {
+	processor driverbuilder.BuildProcessor
+	newBuild  BuildFunc
+	dir       string
+	workers   int
+	queue     chan *Job
+
+	// MaxUploadSize bounds the size of the submitted module tarballs
+	MaxUploadSize int64
+	// JobRetention is how long finished jobs are kept, along with their module tarball, log and artifacts.
+	// Zero keeps them regardless of their age.
+	JobRetention time.Duration
+	// MaxFinishedJobs bounds the number of finished jobs kept, the ones that finished first are removed first.
+	// Zero does not bound them.
+	MaxFinishedJobs int
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewServer returns a server running at most workers builds at a time onto the given processor,
+// with at most queueSize builds waiting. Jobs data is kept under dir.
+func NewServer(processor driverbuilder.BuildProcessor, newBuild BuildFunc, dir string, workers int, queueSize int) *Server {
+	return &Server{
+		processor:       processor,
+		newBuild:        newBuild,
+		dir:             dir,
+		workers:         workers,
+		queue:           make(chan *Job, queueSize),
+		MaxUploadSize:   DefaultMaxUploadSize,
+		JobRetention:    DefaultJobRetention,
+		MaxFinishedJobs: DefaultMaxFinishedJobs,
+		jobs:            make(map[string]*Job),
+	}
+}
+
+// Run starts the workers and serves the API on addr, until the context is done.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.prune(now)
+			}
+		}
+	}()
+
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	logger.WithField("addr", addr).WithField("processor", s.processor.String()).Info("serving the build API")
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		logger.Info("shutting down the build API")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// work runs the queued jobs, and cancels the running and the queued ones once the context is done.
+func (s *Server) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case job := <-s.queue:
+					s.finish(job, StatusCancelled, ctx.Err())
+				default:
+					return
+				}
+			}
+		case job := <-s.queue:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *Server) run(ctx context.Context, job *Job) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.mu.Lock()
+	if job.Status != StatusQueued {
+		// Cancelled while queued
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+	s.mu.Unlock()
+
+	log := logger.WithField("job", job.ID)
+	log.Info("build started")
+	if err := driverbuilder.Run(ctx, s.processor, job.build); err != nil {
+		if ctx.Err() != nil {
+			log.WithError(err).Info("build cancelled")
+			s.finish(job, StatusCancelled, err)
+			return
+		}
+		log.WithError(err).Error("build failed")
+		s.finish(job, StatusFailed, err)
+		return
+	}
+	log.Info("build succeeded")
+	s.finish(job, StatusSucceeded, nil)
+}
+
+func (s *Server) finish(job *Job, status Status, err error) {
+	artifacts := []string{}
+	if status == StatusSucceeded {
+		artifacts = listArtifacts(filepath.Join(job.dir, artifactsDirName))
+	}
+
+	s.mu.Lock()
+	now := time.Now().UTC()
+	job.Status = status
+	job.FinishedAt = &now
+	job.Artifacts = artifacts
+	if err != nil {
+		job.Error = err.Error()
+	}
+	s.mu.Unlock()
+	s.prune(now)
+}
+
+// prune forgets the finished jobs kept for longer than the retention, then the ones exceeding the maximum,
+// and removes their data.
+func (s *Server) prune(now time.Time) {
+	s.mu.Lock()
+	finished := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	var pruned []*Job
+	for i, job := range finished {
+		expired := s.JobRetention > 0 && now.Sub(*job.FinishedAt) > s.JobRetention
+		exceeding := s.MaxFinishedJobs > 0 && len(finished)-i > s.MaxFinishedJobs
+		// The jobs that finished later are neither expired nor exceeding
+		if !expired && !exceeding {
+			break
+		}
+		delete(s.jobs, job.ID)
+		pruned = append(pruned, job)
+	}
+	s.mu.Unlock()
+
+	for _, job := range pruned {
+		if err := os.RemoveAll(job.dir); err != nil {
+			logger.WithError(err).WithField("job", job.ID).Warn("could not remove the build data")
+			continue
+		}
+		logger.WithField("job", job.ID).Debug("build pruned")
+	}
+}
+
+// listArtifacts returns the paths of the files into dir, relative to it and slash separated,
+// since output names may lay out the artifacts into subdirectories (eg. the falco-repo preset).
+func listArtifacts(dir string) []string {
+	artifacts := []string{}
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil {
+			artifacts = append(artifacts, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(artifacts)
+	return artifacts
+}
+
+// Handler returns the handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/builds", s.handleBuilds)
+	mux.HandleFunc("/v1/builds/", s.handleBuild)
+	return mux
+}
+
+func (s *Server) handleBuilds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.submit(w, r)
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]Job, 0, len(s.jobs))
+		for _, job := range s.jobs {
+			jobs = append(jobs, *job)
+		}
+		s.mu.Unlock()
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+		writeJSON(w, http.StatusOK, jobs)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	spec := json.RawMessage(r.FormValue("spec"))
+	if !json.Valid(spec) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("the spec field must be a JSON object"))
+		return
+	}
+	module, _, err := r.FormFile("module")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing module tarball: %v", err))
+		return
+	}
+	defer module.Close()
+
+	job := &Job{
+		ID:        string(uuid.NewUUID()),
+		Status:    StatusQueued,
+		Spec:      spec,
+		CreatedAt: time.Now().UTC(),
+	}
+	job.dir = filepath.Join(s.dir, job.ID)
+	if err := os.MkdirAll(filepath.Join(job.dir, artifactsDirName), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	moduleFile := filepath.Join(job.dir, moduleFileName)
+	if err := saveFile(module, moduleFile); err != nil {
+		os.RemoveAll(job.dir)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	job.build, err = s.newBuild(spec, moduleFile, filepath.Join(job.dir, artifactsDirName))
+	if err != nil {
+		os.RemoveAll(job.dir)
+		status := http.StatusInternalServerError
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, err)
+		return
+	}
+	job.build.BuildLogPath = filepath.Join(job.dir, buildLogFileName)
+	job.build.DiagnosticsPath = filepath.Join(job.dir, diagnosticsFileName)
+	job.build.DiagnosticsFormat = "json"
+
+	s.mu.Lock()
+	select {
+	case s.queue <- job:
+		s.jobs[job.ID] = job
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		os.RemoveAll(job.dir)
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the build queue is full"))
+		return
+	}
+	logger.WithField("job", job.ID).Info("build queued")
+	w.Header().Set("Location", "/v1/builds/"+job.ID)
+	s.writeJob(w, http.StatusAccepted, job)
+}
+
+// handleBuild serves /v1/builds/<id>[/log|/artifacts/<name>]
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/builds/"), "/", 3)
+	s.mu.Lock()
+	job, ok := s.jobs[parts[0]]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no build %q", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.writeJob(w, http.StatusOK, job)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.cancel(w, job)
+	case len(parts) == 2 && parts[1] == "log" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, filepath.Join(job.dir, buildLogFileName))
+	case len(parts) == 2 && parts[1] == "diagnostics" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, filepath.Join(job.dir, diagnosticsFileName))
+	case len(parts) == 3 && parts[1] == "artifacts" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		artifacts := job.Artifacts
+		s.mu.Unlock()
+		for _, name := range artifacts {
+			if name == parts[2] {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+				http.ServeFile(w, r, filepath.Join(job.dir, artifactsDirName, filepath.FromSlash(name)))
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, fmt.Errorf("no artifact %q for build %s", parts[2], job.ID))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) cancel(w http.ResponseWriter, job *Job) {
+	s.mu.Lock()
+	status := job.Status
+	cancel := job.cancel
+	s.mu.Unlock()
+	switch status {
+	case StatusQueued:
+		s.finish(job, StatusCancelled, fmt.Errorf("cancelled by request"))
+		logger.WithField("job", job.ID).Info("build cancelled")
+		s.writeJob(w, http.StatusOK, job)
+	case StatusRunning:
+		// The job is reported as cancelled once the processor cleaned up
+		cancel()
+		logger.WithField("job", job.ID).Info("cancelling running build")
+		s.writeJob(w, http.StatusAccepted, job)
+	default:
+		writeError(w, http.StatusConflict, fmt.Errorf("build %s is already %s", job.ID, status))
+	}
+}
+
+func (s *Server) writeJob(w http.ResponseWriter, status int, job *Job) {
+	s.mu.Lock()
+	snapshot := *job
+	s.mu.Unlock()
+	writeJSON(w, status, snapshot)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.WithError(err).Debug("could not write the response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func saveFile(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+// fakeProcessor writes the module source as the built module, once released.
+type fakeProcessor struct {
+	release chan struct{}
+}
+
+func (p *fakeProcessor) String() string {
+	return "fake"
+}
+
+func (p *fakeProcessor) Start(ctx context.Context, b *builder.Build) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.release:
+	}
+	data, err := os.ReadFile(b.ModuleFilePath)
+	if err != nil {
+		return err
+	}
+	if string(data) == "broken" {
+		return fmt.Errorf("build script failed")
+	}
+	return os.WriteFile(b.ModuleOutPutFilePath, data, 0644)
+}
+
+func fakeBuild(spec json.RawMessage, moduleFile string, outputDir string) (*builder.Build, error) {
+	var s struct {
+		KernelRelease string `json:"kernelrelease"`
+		Arch          string `json:"arch"`
+	}
+	if err := json.Unmarshal(spec, &s); err != nil || len(s.KernelRelease) == 0 {
+		return nil, &ValidationError{Errors: []error{fmt.Errorf("--kernelrelease is a required field")}}
+	}
+	// Like the falco-repo preset, with a directory per architecture
+	outputDir = filepath.Join(outputDir, s.Arch)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, err
+	}
+	return &builder.Build{
+		KernelRelease:        s.KernelRelease,
+		ModuleFilePath:       moduleFile,
+		ModuleOutPutFilePath: filepath.Join(outputDir, "falco_"+s.KernelRelease+".ko"),
+	}, nil
+}
+
+func submit(t *testing.T, url string, spec string, module string) (*http.Response, Job) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	assert.NilError(t, mw.WriteField("spec", spec))
+	fw, err := mw.CreateFormFile("module", "module.tar.gz")
+	assert.NilError(t, err)
+	fw.Write([]byte(module))
+	assert.NilError(t, mw.Close())
+	resp, err := http.Post(url+"/v1/builds", mw.FormDataContentType(), &body)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	return resp, job
+}
+
+func waitJob(t *testing.T, url string, id string) Job {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get(url + "/v1/builds/" + id)
+		assert.NilError(t, err)
+		var job Job
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(&job))
+		resp.Body.Close()
+		if job.Status != StatusQueued && job.Status != StatusRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("build %s did not finish", id)
+	return Job{}
+}
+
+func TestServer(t *testing.T) {
+	processor := &fakeProcessor{release: make(chan struct{})}
+	s := NewServer(processor, fakeBuild, t.TempDir(), 1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.work(ctx)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, _ := submit(t, srv.URL, `{}`, "module")
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	// The first build runs, the second one waits, the third one does not fit into the queue
+	resp, running := submit(t, srv.URL, `{"kernelrelease": "5.10.0"}`, "module")
+	assert.Equal(t, resp.StatusCode, http.StatusAccepted)
+	assert.Equal(t, resp.Header.Get("Location"), "/v1/builds/"+running.ID)
+	for status := StatusQueued; status != StatusRunning; {
+		time.Sleep(time.Millisecond)
+		s.mu.Lock()
+		status = s.jobs[running.ID].Status
+		s.mu.Unlock()
+	}
+	_, queued := submit(t, srv.URL, `{"kernelrelease": "5.10.1"}`, "broken")
+	assert.Equal(t, queued.Status, StatusQueued)
+	resp, _ = submit(t, srv.URL, `{"kernelrelease": "5.10.2"}`, "module")
+	assert.Equal(t, resp.StatusCode, http.StatusServiceUnavailable)
+
+	// Running builds are interrupted
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/v1/builds/"+running.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusAccepted)
+	job := waitJob(t, srv.URL, running.ID)
+	assert.Equal(t, job.Status, StatusCancelled)
+	assert.Equal(t, job.Error, context.Canceled.Error())
+
+	close(processor.release)
+	job = waitJob(t, srv.URL, queued.ID)
+	assert.Equal(t, job.Status, StatusFailed)
+	assert.Equal(t, job.Error, "build script failed")
+
+	_, succeeded := submit(t, srv.URL, `{"kernelrelease": "5.10.3", "arch": "x86_64"}`, "module")
+	job = waitJob(t, srv.URL, succeeded.ID)
+	assert.Equal(t, job.Status, StatusSucceeded)
+	assert.DeepEqual(t, job.Artifacts, []string{"x86_64/falco_5.10.3.ko"})
+	resp, err = http.Get(srv.URL + "/v1/builds/" + succeeded.ID + "/artifacts/x86_64/falco_5.10.3.ko")
+	assert.NilError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, string(data), "module")
+	resp, err = http.Get(srv.URL + "/v1/builds/" + succeeded.ID + "/artifacts/..%2Fmodule.tar.gz")
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+
+	resp, err = http.Get(srv.URL + "/v1/builds")
+	assert.NilError(t, err)
+	var jobs []Job
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&jobs))
+	assert.Equal(t, len(jobs), 3)
+}
+
+func TestServerPrune(t *testing.T) {
+	processor := &fakeProcessor{release: make(chan struct{})}
+	close(processor.release)
+	s := NewServer(processor, fakeBuild, t.TempDir(), 1, 4)
+	s.MaxFinishedJobs = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.work(ctx)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	var jobs []Job
+	for _, release := range []string{"5.10.0", "5.10.1", "5.10.2"} {
+		_, job := submit(t, srv.URL, fmt.Sprintf(`{"kernelrelease": %q}`, release), "module")
+		jobs = append(jobs, waitJob(t, srv.URL, job.ID))
+	}
+
+	// Beyond the maximum, the build that finished first goes away along with its data
+	resp, err := http.Get(srv.URL + "/v1/builds/" + jobs[0].ID)
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+	_, err = os.Stat(filepath.Join(s.dir, jobs[0].ID))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(s.dir, jobs[1].ID))
+	assert.NilError(t, err)
+
+	// Past the retention, every finished build goes away
+	s.JobRetention = time.Hour
+	s.prune(time.Now().Add(30 * time.Minute))
+	assert.Equal(t, len(s.jobs), 2)
+	s.prune(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, len(s.jobs), 0)
+	entries, err := os.ReadDir(s.dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
@@ -0,0 +1,25 @@
+package validate
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// isHTTPURL only accepts absolute http(s) URLs.
+func isHTTPURL(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		u, err := url.Parse(field.String())
+		if err != nil {
+			return false
+		}
+		return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -42,6 +42,7 @@ func init() {
 	V.RegisterValidation("semvertolerant", isSemVerTolerant)
 	V.RegisterValidation("proxy", isProxy)
 	V.RegisterValidation("imagename", isImageName)
+	V.RegisterValidation("httpurl", isHTTPURL)
 
 	V.RegisterValidation("isExistFilePath", isExistFilePath)
 	V.RegisterValidation("isExistDirPath", isExistDirPath)
@@ -90,6 +91,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"httpurl",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("httpurl", "{0} must be a valid http(s) URL", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"target",
 		T,
@@ -155,6 +169,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"excluded_outputdir_with_output_paths",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("excluded_outputdir_with_output_paths", "{0} cannot be used along with --output-module or --output-probe", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("excluded_outputdir_with_output_paths", "--output-dir")
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"logrus",
 		T,